@@ -0,0 +1,199 @@
+package cim
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+// Datetime is a CIM datetime holding a timestamp.  It is sent as a
+// cim:Datetime holding an xs:dateTime.
+type Datetime struct {
+	time.Time
+}
+
+func (Datetime) Type() string { return "datetime" }
+
+// String formats the timestamp the DSP0004 way, as
+// yyyymmddhhmmss.mmmmmmsutc.
+func (v Datetime) String() string {
+	_, offset := v.Zone()
+	sign := '+'
+	if offset < 0 {
+		sign, offset = '-', -offset
+	}
+	return fmt.Sprintf("%s.%06d%c%03d", v.Format("20060102150405"), v.Nanosecond()/1000, sign, offset/60)
+}
+
+func (v Datetime) encode(e *dom.Element) {
+	e.AddChild(dom.ElemC("Datetime", NS_CIM, v.Format(time.RFC3339Nano)))
+}
+
+// Interval is a CIM datetime holding an interval.  It is sent as a
+// cim:Interval holding an xs:duration.
+type Interval time.Duration
+
+func (Interval) Type() string { return "datetime" }
+
+// String formats the interval the DSP0004 way, as
+// ddddddddhhmmss.mmmmmm:000.  DSP0004 intervals cannot be negative,
+// so the sign is dropped.
+func (v Interval) String() string {
+	d := time.Duration(v)
+	if d < 0 {
+		d = -d
+	}
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	hours := d / time.Hour
+	d -= hours * time.Hour
+	minutes := d / time.Minute
+	d -= minutes * time.Minute
+	seconds := d / time.Second
+	d -= seconds * time.Second
+	return fmt.Sprintf("%08d%02d%02d%02d.%06d:000", days, hours, minutes, seconds, d/time.Microsecond)
+}
+
+func (v Interval) encode(e *dom.Element) {
+	e.AddChild(dom.ElemC("Interval", NS_CIM, FormatInterval(time.Duration(v))))
+}
+
+var (
+	dspTimestamp = regexp.MustCompile(`^(\d{14})\.(\d{6})([+-])(\d{3})$`)
+	dspInterval  = regexp.MustCompile(`^(\d{8})(\d{2})(\d{2})(\d{2})\.(\d{6}):000$`)
+	xsdDuration  = regexp.MustCompile(`^(-)?P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+)
+
+// timeLayouts are the forms of xs:dateTime, xs:date, and xs:time
+// cim:Datetime values come in.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02Z07:00",
+	"2006-01-02",
+	"15:04:05.999999999Z07:00",
+	"15:04:05.999999999",
+}
+
+// durationParts matches s against xsdDuration, and returns nil unless
+// it is an xs:duration with at least one component.  Bare "P", "PT",
+// and a trailing "T" are not durations.
+func durationParts(s string) []string {
+	m := xsdDuration.FindStringSubmatch(s)
+	if m == nil || strings.HasSuffix(s, "T") || m[2]+m[3]+m[4]+m[5] == "" {
+		return nil
+	}
+	return m
+}
+
+// FormatInterval formats d as an xs:duration, as used for
+// cim:Interval.
+func FormatInterval(d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	hours := d / time.Hour
+	d -= hours * time.Hour
+	minutes := d / time.Minute
+	d -= minutes * time.Minute
+	return fmt.Sprintf("%sP%dDT%dH%dM%sS", sign, days, hours, minutes,
+		strconv.FormatFloat(d.Seconds(), 'f', -1, 64))
+}
+
+// ParseInterval parses an xs:duration with no year or month parts, or
+// a DSP0004 interval.
+func ParseInterval(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	res := time.Duration(0)
+	if m := dspInterval.FindStringSubmatch(s); m != nil {
+		for i, unit := range []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second, time.Microsecond} {
+			n, _ := strconv.ParseInt(m[i+1], 10, 64)
+			res += time.Duration(n) * unit
+		}
+		return res, nil
+	}
+	m := durationParts(s)
+	if m == nil {
+		return 0, fmt.Errorf("%q is not an interval", s)
+	}
+	for i, unit := range []time.Duration{24 * time.Hour, time.Hour, time.Minute} {
+		if m[i+2] != "" {
+			n, _ := strconv.ParseInt(m[i+2], 10, 64)
+			res += time.Duration(n) * unit
+		}
+	}
+	if m[5] != "" {
+		secs, _ := strconv.ParseFloat(m[5], 64)
+		res += time.Duration(secs * float64(time.Second))
+	}
+	if m[1] != "" {
+		res = -res
+	}
+	return res, nil
+}
+
+// ParseTime parses an xs:dateTime, xs:date, xs:time, or a DSP0004
+// timestamp.
+func ParseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if m := dspTimestamp.FindStringSubmatch(s); m != nil {
+		offset, _ := strconv.Atoi(m[4])
+		if m[3] == "-" {
+			offset = -offset
+		}
+		t, err := time.ParseInLocation("20060102150405", m[1], time.FixedZone("", offset*60))
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%q is not a datetime", s)
+		}
+		micros, _ := strconv.Atoi(m[2])
+		return t.Add(time.Duration(micros) * time.Microsecond), nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a datetime", s)
+}
+
+// ParseDatetime parses either kind of CIM datetime, in DSP0004 form or
+// as an xs:dateTime or xs:duration, and returns a Datetime or an
+// Interval.
+func ParseDatetime(s string) (Value, error) {
+	s = strings.TrimSpace(s)
+	if dspInterval.MatchString(s) || durationParts(s) != nil {
+		d, err := ParseInterval(s)
+		if err != nil {
+			return nil, err
+		}
+		return Interval(d), nil
+	}
+	t, err := ParseTime(s)
+	if err != nil {
+		return nil, err
+	}
+	return Datetime{t}, nil
+}
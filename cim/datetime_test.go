@@ -0,0 +1,175 @@
+package cim
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimestampRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		s   string
+		utc string
+	}{
+		{"20151021123456.123456+000", "2015-10-21T12:34:56.123456Z"},
+		{"20151021123456.000001+060", "2015-10-21T11:34:56.000001Z"},
+		{"20151021123456.000000-300", "2015-10-21T17:34:56Z"},
+		{"20151231230000.500000-330", "2016-01-01T04:30:00.5Z"},
+		{"19991231235959.999999+720", "1999-12-31T11:59:59.999999Z"},
+	} {
+		v, err := ParseDatetime(test.s)
+		if err != nil {
+			t.Errorf("ParseDatetime(%q): %v", test.s, err)
+			continue
+		}
+		d, ok := v.(Datetime)
+		if !ok {
+			t.Errorf("ParseDatetime(%q) = %#v, wanted a Datetime", test.s, v)
+			continue
+		}
+		if got := d.UTC().Format(time.RFC3339Nano); got != test.utc {
+			t.Errorf("%q is %s, wanted %s", test.s, got, test.utc)
+		}
+		if got := d.String(); got != test.s {
+			t.Errorf("%q formatted as %q", test.s, got)
+		}
+		// And the other way round, through xs:dateTime.
+		again, err := ParseTime(d.Format(time.RFC3339Nano))
+		if err != nil || !again.Equal(d.Time) || (Datetime{again}).String() != test.s {
+			t.Errorf("%q did not survive xs:dateTime: %v, %v", test.s, again, err)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	for _, test := range []struct {
+		s, utc string
+	}{
+		{"2015-10-21T12:34:56-05:00", "2015-10-21T17:34:56Z"},
+		{"2015-10-21T12:34:56.25Z", "2015-10-21T12:34:56.25Z"},
+		{"2015-10-21T12:34:56", "2015-10-21T12:34:56Z"},
+		{"2015-10-21", "2015-10-21T00:00:00Z"},
+		{"2015-10-21-08:00", "2015-10-21T08:00:00Z"},
+		{" 12:00:00+01:00 ", "0000-01-01T11:00:00Z"},
+	} {
+		got, err := ParseTime(test.s)
+		if err != nil {
+			t.Errorf("ParseTime(%q): %v", test.s, err)
+			continue
+		}
+		if s := got.UTC().Format(time.RFC3339Nano); s != test.utc {
+			t.Errorf("ParseTime(%q) = %s, wanted %s", test.s, s, test.utc)
+		}
+	}
+}
+
+func TestIntervalRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		s string
+		d time.Duration
+	}{
+		{"00000000000000.000000:000", 0},
+		{"00000001020304.000005:000", 26*time.Hour + 3*time.Minute + 4*time.Second + 5*time.Microsecond},
+		{"00099999235959.999999:000", 99999*24*time.Hour + 23*time.Hour + 59*time.Minute + 59999999*time.Microsecond},
+	} {
+		v, err := ParseDatetime(test.s)
+		if err != nil {
+			t.Errorf("ParseDatetime(%q): %v", test.s, err)
+			continue
+		}
+		if v != Interval(test.d) {
+			t.Errorf("ParseDatetime(%q) = %#v, wanted %v", test.s, v, test.d)
+		}
+		if got := Interval(test.d).String(); got != test.s {
+			t.Errorf("%v formatted as %q, wanted %q", test.d, got, test.s)
+		}
+	}
+	// DSP0004 intervals have no sign.
+	if got := Interval(-time.Second).String(); got != "00000000000001.000000:000" {
+		t.Errorf("-1s formatted as %q", got)
+	}
+}
+
+func TestDurationRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		d time.Duration
+		s string
+	}{
+		{0, "P0DT0H0M0S"},
+		{90 * time.Minute, "P0DT1H30M0S"},
+		{49*time.Hour + 1500*time.Millisecond, "P2DT1H0M1.5S"},
+		{-time.Minute, "-P0DT0H1M0S"},
+		{time.Microsecond, "P0DT0H0M0.000001S"},
+	} {
+		if got := FormatInterval(test.d); got != test.s {
+			t.Errorf("FormatInterval(%v) = %q, wanted %q", test.d, got, test.s)
+		}
+		if got, err := ParseInterval(test.s); err != nil || got != test.d {
+			t.Errorf("ParseInterval(%q) = %v, %v, wanted %v", test.s, got, err, test.d)
+		}
+	}
+	for s, d := range map[string]time.Duration{
+		"P1D":     24 * time.Hour,
+		"PT1H":    time.Hour,
+		"PT5M":    5 * time.Minute,
+		"PT0.25S": 250 * time.Millisecond,
+		"-PT3S":   -3 * time.Second,
+		"P1DT2M":  24*time.Hour + 2*time.Minute,
+		" PT0S ":  0,
+	} {
+		if got, err := ParseInterval(s); err != nil || got != d {
+			t.Errorf("ParseInterval(%q) = %v, %v, wanted %v", s, got, err, d)
+		}
+		if v, err := ParseDatetime(s); err != nil || v != Interval(d) {
+			t.Errorf("ParseDatetime(%q) = %#v, %v, wanted %v", s, v, err, d)
+		}
+	}
+}
+
+func TestDatetimeRejects(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"yesterday",
+		"P",
+		"PT",
+		"-P",
+		"-PT",
+		"P1DT",
+		"PT1H1",
+		"P1Y",
+		"P1M",
+		"PT.5S",
+		"20151021123456.123456*060",
+		"20151321000000.000000+000",
+		"20151021123456.12345+000",
+		"2015102112345.123456+000",
+		"0000000102030.000000:000",
+		"00000001020304.000005:001",
+		"00000001020304:000",
+		"2015-10-21T25:00:00Z",
+	} {
+		if v, err := ParseDatetime(s); err == nil {
+			t.Errorf("ParseDatetime(%q) = %#v, wanted an error", s, v)
+		}
+	}
+	for _, s := range []string{"P", "PT", "P1DT", "20151021123456.000000+000"} {
+		if d, err := ParseInterval(s); err == nil {
+			t.Errorf("ParseInterval(%q) = %v, wanted an error", s, d)
+		}
+	}
+}
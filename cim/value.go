@@ -0,0 +1,268 @@
+// Package cim has Go representations of the CIM intrinsic types from
+// DSP0004, and knows how to encode them the way DSP0230 says they
+// should be sent over WS-Management.
+package cim
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+const (
+	NS_CIM = "http://schemas.dmtf.org/wbem/wscim/1/common"
+	NS_XSI = "http://www.w3.org/2001/XMLSchema-instance"
+)
+
+// Value is a typed CIM value.
+type Value interface {
+	// Type is the DSP0004 name of the type, such as uint16 or
+	// datetime.  Arrays have [] appended.
+	Type() string
+	// encode sets the content of the element for a property or
+	// parameter.
+	encode(e *dom.Element)
+}
+
+type (
+	Boolean     bool
+	Uint8       uint8
+	Uint16      uint16
+	Uint32      uint32
+	Uint64      uint64
+	Sint8       int8
+	Sint16      int16
+	Sint32      int32
+	Sint64      int64
+	Real32      float32
+	Real64      float64
+	Char16      rune
+	String      string
+	OctetString []byte
+)
+
+func (Boolean) Type() string     { return "boolean" }
+func (Uint8) Type() string       { return "uint8" }
+func (Uint16) Type() string      { return "uint16" }
+func (Uint32) Type() string      { return "uint32" }
+func (Uint64) Type() string      { return "uint64" }
+func (Sint8) Type() string       { return "sint8" }
+func (Sint16) Type() string      { return "sint16" }
+func (Sint32) Type() string      { return "sint32" }
+func (Sint64) Type() string      { return "sint64" }
+func (Real32) Type() string      { return "real32" }
+func (Real64) Type() string      { return "real64" }
+func (Char16) Type() string      { return "char16" }
+func (String) Type() string      { return "string" }
+func (OctetString) Type() string { return "octetstring" }
+
+// String renders booleans the only way xs:boolean allows.
+func (v Boolean) String() string { return strconv.FormatBool(bool(v)) }
+func (v Uint8) String() string   { return strconv.FormatUint(uint64(v), 10) }
+func (v Uint16) String() string  { return strconv.FormatUint(uint64(v), 10) }
+func (v Uint32) String() string  { return strconv.FormatUint(uint64(v), 10) }
+func (v Uint64) String() string  { return strconv.FormatUint(uint64(v), 10) }
+func (v Sint8) String() string   { return strconv.FormatInt(int64(v), 10) }
+func (v Sint16) String() string  { return strconv.FormatInt(int64(v), 10) }
+func (v Sint32) String() string  { return strconv.FormatInt(int64(v), 10) }
+func (v Sint64) String() string  { return strconv.FormatInt(int64(v), 10) }
+func (v Real32) String() string  { return strconv.FormatFloat(float64(v), 'g', -1, 32) }
+func (v Real64) String() string  { return strconv.FormatFloat(float64(v), 'g', -1, 64) }
+func (v Char16) String() string  { return string(rune(v)) }
+
+// String renders octet strings as xs:hexBinary.
+func (v OctetString) String() string { return strings.ToUpper(hex.EncodeToString(v)) }
+
+func (v Boolean) encode(e *dom.Element)     { e.Content = []byte(v.String()) }
+func (v Uint8) encode(e *dom.Element)       { e.Content = []byte(v.String()) }
+func (v Uint16) encode(e *dom.Element)      { e.Content = []byte(v.String()) }
+func (v Uint32) encode(e *dom.Element)      { e.Content = []byte(v.String()) }
+func (v Uint64) encode(e *dom.Element)      { e.Content = []byte(v.String()) }
+func (v Sint8) encode(e *dom.Element)       { e.Content = []byte(v.String()) }
+func (v Sint16) encode(e *dom.Element)      { e.Content = []byte(v.String()) }
+func (v Sint32) encode(e *dom.Element)      { e.Content = []byte(v.String()) }
+func (v Sint64) encode(e *dom.Element)      { e.Content = []byte(v.String()) }
+func (v Real32) encode(e *dom.Element)      { e.Content = []byte(v.String()) }
+func (v Real64) encode(e *dom.Element)      { e.Content = []byte(v.String()) }
+func (v Char16) encode(e *dom.Element)      { e.Content = []byte(v.String()) }
+func (v String) encode(e *dom.Element)      { e.Content = []byte(v) }
+func (v OctetString) encode(e *dom.Element) { e.Content = []byte(v.String()) }
+
+// Null is a missing value of a type, sent as xsi:nil.
+type Null string
+
+func (v Null) Type() string { return string(v) }
+
+func (v Null) encode(e *dom.Element) { e.Attr("nil", NS_XSI, "true") }
+
+// Reference is anything that can render itself as an endpoint
+// reference, like *wsman.EndpointReference.
+type Reference interface {
+	Element(name, space string) *dom.Element
+}
+
+// Ref is a value of a reference type.  A Ref with no Reference is sent
+// as xsi:nil.
+type Ref struct {
+	Reference
+}
+
+func (Ref) Type() string { return "ref" }
+
+func (v Ref) encode(e *dom.Element) {
+	if v.Reference == nil {
+		Null("ref").encode(e)
+		return
+	}
+	for _, child := range v.Element(e.Name.Local, e.Name.Space).Children() {
+		e.AddChild(child)
+	}
+}
+
+// Property is a single named value of an Instance.
+type Property struct {
+	Name  string
+	Value Value
+}
+
+// Instance is an embedded instance, with its properties in the
+// namespace of its ResourceURI.
+type Instance struct {
+	ResourceURI string
+	Properties  []Property
+}
+
+func (Instance) Type() string { return "instance" }
+
+func (v Instance) encode(e *dom.Element) {
+	for _, prop := range v.Properties {
+		for _, child := range Elements(prop.Name, v.ResourceURI, prop.Value) {
+			e.AddChild(child)
+		}
+	}
+}
+
+// Array is an array of values, which are sent as repeated elements.
+// All its values should have the same type.
+type Array []Value
+
+func (v Array) Type() string {
+	if len(v) == 0 || v[0] == nil {
+		return "[]"
+	}
+	return v[0].Type() + "[]"
+}
+
+func (v Array) encode(e *dom.Element) {
+	panic("cim.Array cannot be encoded in a single element")
+}
+
+// Encode sets the content of e, which should be a property, parameter,
+// selector, or option, to v.  A nil v is sent as xsi:nil.  Arrays
+// need more than one element, so use Elements for them instead.
+func Encode(e *dom.Element, v Value) *dom.Element {
+	if v == nil {
+		Null("").encode(e)
+	} else {
+		v.encode(e)
+	}
+	return e
+}
+
+// Elements makes the elements that send v as the property or parameter
+// name in namespace space.  Arrays make one element per value.
+func Elements(name, space string, v Value) []*dom.Element {
+	if arr, ok := v.(Array); ok {
+		res := []*dom.Element{}
+		for _, item := range arr {
+			res = append(res, Elements(name, space, item)...)
+		}
+		return res
+	}
+	return []*dom.Element{Encode(dom.Elem(name, space), v)}
+}
+
+// Parse parses s as a value of the named DSP0004 type, checking that it
+// is in range.  Booleans are accepted in any case.  Datetimes are
+// accepted in DSP0004 form as well as xs:dateTime and xs:duration.
+func Parse(typ, s string) (Value, error) {
+	var err error
+	bits := 0
+	if n, convErr := strconv.Atoi(strings.TrimLeft(typ, "suintreal")); convErr == nil {
+		bits = n
+	}
+	switch typ {
+	case "string":
+		return String(s), nil
+	case "boolean":
+		var b bool
+		if b, err = strconv.ParseBool(strings.ToLower(s)); err == nil {
+			return Boolean(b), nil
+		}
+	case "uint8", "uint16", "uint32", "uint64":
+		var n uint64
+		if n, err = strconv.ParseUint(s, 10, bits); err == nil {
+			return map[int]Value{8: Uint8(n), 16: Uint16(n), 32: Uint32(n), 64: Uint64(n)}[bits], nil
+		}
+	case "sint8", "sint16", "sint32", "sint64":
+		var n int64
+		if n, err = strconv.ParseInt(s, 10, bits); err == nil {
+			return map[int]Value{8: Sint8(n), 16: Sint16(n), 32: Sint32(n), 64: Sint64(n)}[bits], nil
+		}
+	case "real32":
+		var f float64
+		if f, err = strconv.ParseFloat(s, 32); err == nil {
+			return Real32(f), nil
+		}
+	case "real64":
+		var f float64
+		if f, err = strconv.ParseFloat(s, 64); err == nil {
+			return Real64(f), nil
+		}
+	case "char16":
+		if utf8.RuneCountInString(s) == 1 {
+			r, _ := utf8.DecodeRuneInString(s)
+			if r <= 0xffff {
+				return Char16(r), nil
+			}
+		}
+	case "octetstring":
+		var b []byte
+		if b, err = hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")); err == nil {
+			return OctetString(b), nil
+		}
+	case "datetime":
+		return ParseDatetime(s)
+	default:
+		return nil, fmt.Errorf("Unknown CIM type %s", typ)
+	}
+	return nil, fmt.Errorf("%q is not a valid %s", s, typ)
+}
+
+// Types lists the type names Parse understands.
+var Types = []string{
+	"boolean", "char16", "datetime", "octetstring", "string",
+	"real32", "real64",
+	"sint8", "sint16", "sint32", "sint64",
+	"uint8", "uint16", "uint32", "uint64",
+}
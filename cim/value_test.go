@@ -0,0 +1,153 @@
+package cim
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		typ, s string
+		want   Value
+	}{
+		{"uint8", "0", Uint8(0)},
+		{"uint8", "255", Uint8(255)},
+		{"uint8", "256", nil},
+		{"uint8", "-1", nil},
+		{"uint16", "65535", Uint16(65535)},
+		{"uint16", "65536", nil},
+		{"uint32", "4294967295", Uint32(4294967295)},
+		{"uint32", "4294967296", nil},
+		{"uint64", "18446744073709551615", Uint64(18446744073709551615)},
+		{"uint64", "18446744073709551616", nil},
+		{"uint64", "0x10", nil},
+		{"sint8", "-128", Sint8(-128)},
+		{"sint8", "127", Sint8(127)},
+		{"sint8", "128", nil},
+		{"sint8", "-129", nil},
+		{"sint16", "-32768", Sint16(-32768)},
+		{"sint16", "32768", nil},
+		{"sint32", "-2147483648", Sint32(-2147483648)},
+		{"sint32", "2147483648", nil},
+		{"sint64", "-9223372036854775808", Sint64(-9223372036854775808)},
+		{"sint64", "9223372036854775808", nil},
+		{"real32", "1.5", Real32(1.5)},
+		{"real32", "-3.4028235e38", Real32(-3.4028235e38)},
+		{"real32", "3.5e38", nil},
+		{"real64", "1e308", Real64(1e308)},
+		{"real64", "1e309", nil},
+		{"real64", "one", nil},
+		{"char16", "a", Char16('a')},
+		{"char16", "é", Char16('é')},
+		{"char16", "￿", Char16(0xffff)},
+		{"char16", "😀", nil},
+		{"char16", "ab", nil},
+		{"char16", "", nil},
+		{"boolean", "true", Boolean(true)},
+		{"boolean", "FALSE", Boolean(false)},
+		{"boolean", "True", Boolean(true)},
+		{"boolean", "1", Boolean(true)},
+		{"boolean", "yes", nil},
+		{"boolean", "", nil},
+		{"octetstring", "0A0b", OctetString{0x0a, 0x0b}},
+		{"octetstring", "0x0A0B", OctetString{0x0a, 0x0b}},
+		{"octetstring", "0XFF", OctetString{0xff}},
+		{"octetstring", "", OctetString{}},
+		{"octetstring", "ABC", nil},
+		{"octetstring", "zz", nil},
+		{"string", " as is ", String(" as is ")},
+		{"datetime", "PT1S", Interval(1e9)},
+		{"datetime", "P", nil},
+		{"uint128", "1", nil},
+	} {
+		got, err := Parse(test.typ, test.s)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("Parse(%s, %q) = %#v, wanted an error", test.typ, test.s, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%s, %q): %v", test.typ, test.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Parse(%s, %q) = %#v, wanted %#v", test.typ, test.s, got, test.want)
+		}
+		if got.Type() != test.typ {
+			t.Errorf("Parse(%s, %q) made a %s", test.typ, test.s, got.Type())
+		}
+	}
+}
+
+func TestParseTypes(t *testing.T) {
+	for _, typ := range Types {
+		if _, err := Parse(typ, ""); err != nil && err.Error() == "Unknown CIM type "+typ {
+			t.Errorf("Parse does not know %s", typ)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	for _, test := range []struct {
+		v    Value
+		want string
+	}{
+		{Boolean(true), "true"},
+		{Sint16(-3), "-3"},
+		{Real32(0.1), "0.1"},
+		{Char16('x'), "x"},
+		{OctetString{0xde, 0xad}, "DEAD"},
+		{String("<&>"), "<&>"},
+	} {
+		if got := string(Encode(dom.Elem("P", "urn:x"), test.v).Content); got != test.want {
+			t.Errorf("%#v encoded as %q, wanted %q", test.v, got, test.want)
+		}
+	}
+	for _, v := range []Value{nil, Null("uint8"), Ref{}} {
+		e := Encode(dom.Elem("P", "urn:x"), v)
+		if len(e.Attributes) != 1 || e.Attributes[0].Name.Local != "nil" ||
+			e.Attributes[0].Name.Space != NS_XSI || e.Attributes[0].Value != "true" {
+			t.Errorf("%#v was not sent as xsi:nil: %v", v, e.Attributes)
+		}
+	}
+}
+
+func TestElements(t *testing.T) {
+	arr := Array{Uint8(1), Uint8(2), Uint8(3)}
+	if arr.Type() != "uint8[]" {
+		t.Errorf("Got array type %s", arr.Type())
+	}
+	elems := Elements("Speeds", "urn:x", arr)
+	if len(elems) != 3 {
+		t.Fatalf("Got %d elements for 3 values", len(elems))
+	}
+	for i, e := range elems {
+		if e.Name.Local != "Speeds" || string(e.Content) != arr[i].(Uint8).String() {
+			t.Errorf("Element %d is %s=%s", i, e.Name.Local, e.Content)
+		}
+	}
+	inst := Instance{ResourceURI: "urn:y", Properties: []Property{{"A", String("a")}, {"B", arr}}}
+	e := Encode(dom.Elem("Embedded", "urn:x"), inst)
+	if children := e.Children(); len(children) != 4 || children[3].Name.Space != "urn:y" {
+		t.Errorf("Embedded instance encoded as %v", children)
+	}
+}
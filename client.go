@@ -20,6 +20,8 @@ limitations under the License.
 */
 
 import (
+	"bytes"
+	"context"
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/tls"
@@ -29,6 +31,7 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/VictorLowther/simplexml/dom"
@@ -158,12 +161,35 @@ func (c *challenge) parseChallenge(input string) error {
 	return nil
 }
 
+// HTTPError is returned by Post when the endpoint replies with an HTTP
+// error.  If the reply is a SOAP fault, Post returns the parsed fault
+// as well as the error.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("wsman.Client: post recieved %v\n'%v'", e.Status, e.Body)
+}
+
 // Client is a thin wrapper around http.Client.
 type Client struct {
 	http.Client
 	target, username, password     string
 	useDigest, Debug, OptimizeEnum bool
-	challenge                      *challenge
+	// MaxEnvelopeSize is the biggest MaxEnvelopeSize Send will ask
+	// for when a reply does not fit in the default one.  It defaults
+	// to MaxEnvelopeSizeCeiling.
+	MaxEnvelopeSize int
+	// Retry says when Send tries a request again after a transient
+	// failure.  If it is nil, requests are sent once.
+	Retry     *RetryPolicy
+	challenge *challenge
+	// authLock serializes access to challenge, which is updated on
+	// every digest authorization.
+	authLock sync.Mutex
 }
 
 // NewClient creates a new wsman.Client.
@@ -174,6 +200,32 @@ type Client struct {
 // If useDigest is true, we will try to use digest auth instead of
 // basic auth.
 func NewClient(target, username, password string, useDigest bool) *Client {
+	res, err := Connect(target, username, password, useDigest)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return res
+}
+
+// Connect creates a new wsman.Client like NewClient does, but returns
+// an error instead of exiting if it cannot set up digest auth.
+func Connect(target, username, password string, useDigest bool) (*Client, error) {
+	return ConnectWith(target, username, password, useDigest, nil)
+}
+
+// defaultTransport is the transport Connect uses.  BMCs almost always
+// have self-signed certificates, so it does not check them.
+func defaultTransport() http.RoundTripper {
+	return &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+}
+
+// ConnectWith is Connect, but sends every request through transport,
+// including the one that fetches the digest challenge.  Use it to
+// check certificates, present a client certificate, or limit requests
+// with a Limiter.  If transport is nil, it does the same as Connect.
+func ConnectWith(target, username, password string, useDigest bool, transport http.RoundTripper) (*Client, error) {
 	res := &Client{
 		target:    target,
 		username:  username,
@@ -181,23 +233,26 @@ func NewClient(target, username, password string, useDigest bool) *Client {
 		useDigest: useDigest,
 	}
 	res.Timeout = 10 * time.Second
-	res.Transport = &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	res.MaxEnvelopeSize = MaxEnvelopeSizeCeiling
+	if transport == nil {
+		transport = defaultTransport()
 	}
+	res.Transport = transport
 	if res.useDigest {
 		res.challenge = &challenge{Username: res.username, Password: res.password}
 		resp, err := res.PostForm(res.target, nil)
 		if err != nil {
-			log.Fatalf("Unable to perform digest auth with %s: %v", res.target, err)
+			return nil, fmt.Errorf("Unable to perform digest auth with %s: %v", res.target, err)
 		}
+		resp.Body.Close()
 		if resp.StatusCode != 401 {
-			log.Fatalf("No digest auth at %s", res.target)
+			return nil, fmt.Errorf("No digest auth at %s", res.target)
 		}
 		if err := res.challenge.parseChallenge(resp.Header.Get("WWW-Authenticate")); err != nil {
-			log.Fatalf("Failed to parse auth header %v", err)
+			return nil, fmt.Errorf("Failed to parse auth header %v", err)
 		}
 	}
-	return res
+	return res, nil
 }
 
 // Endpoint returns the endpoint that the Client will try to ocmmunicate with.
@@ -205,18 +260,41 @@ func (c *Client) Endpoint() string {
 	return c.target
 }
 
+func (c *Client) digestAuth(reauth string) (string, error) {
+	c.authLock.Lock()
+	defer c.authLock.Unlock()
+	if reauth != "" {
+		if err := c.challenge.parseChallenge(reauth); err != nil {
+			return "", err
+		}
+	}
+	auth, err := c.challenge.authorize("POST", c.target)
+	if err != nil {
+		return "", fmt.Errorf("Failed digest auth %v", err)
+	}
+	return auth, nil
+}
+
 // Post overrides http.Client's Post method and adds digext auth handling
-// and SOAP pre and post processing.
+// and SOAP pre and post processing.  A SOAP fault is returned along
+// with an *HTTPError for it.  It is safe to call Post from multiple
+// goroutines.
 func (c *Client) Post(msg *soap.Message) (response *soap.Message, err error) {
+	return c.post(context.Background(), msg)
+}
+
+// post is Post, giving up when ctx is done.
+func (c *Client) post(ctx context.Context, msg *soap.Message) (response *soap.Message, err error) {
 	req, err := http.NewRequest("POST", c.target, msg.Reader())
 	if err != nil {
 		return nil, err
 	}
+	req = req.WithContext(ctx)
 	if c.username != "" && c.password != "" {
 		if c.useDigest {
-			auth, err := c.challenge.authorize("POST", c.target)
+			auth, err := c.digestAuth("")
 			if err != nil {
-				return nil, fmt.Errorf("Failed digest auth %v", err)
+				return nil, err
 			}
 			req.Header.Set("Authorization", auth)
 		} else {
@@ -233,17 +311,16 @@ func (c *Client) Post(msg *soap.Message) (response *soap.Message, err error) {
 	}
 	if c.useDigest && res.StatusCode == 401 {
 		log.Printf("Digest reauthorizing")
-		if err := c.challenge.parseChallenge(res.Header.Get("WWW-Authenticate")); err != nil {
-			return nil, err
-		}
-		auth, err := c.challenge.authorize("POST", c.target)
+		res.Body.Close()
+		auth, err := c.digestAuth(res.Header.Get("WWW-Authenticate"))
 		if err != nil {
-			return nil, fmt.Errorf("Failed digest auth %v", err)
+			return nil, err
 		}
 		req, err = http.NewRequest("POST", c.target, msg.Reader())
 		if err != nil {
 			return nil, err
 		}
+		req = req.WithContext(ctx)
 		req.Header.Set("Authorization", auth)
 		req.Header.Add("content-type", soap.ContentType)
 		res, err = c.Do(req)
@@ -256,7 +333,20 @@ func (c *Client) Post(msg *soap.Message) (response *soap.Message, err error) {
 
 	if res.StatusCode >= 400 {
 		b, _ := ioutil.ReadAll(res.Body)
-		return nil, fmt.Errorf("wsman.Client: post recieved %v\n'%v'", res.Status, string(b))
+		herr := &HTTPError{StatusCode: res.StatusCode, Status: res.Status, Body: string(b)}
+		// WSMAN endpoints report SOAP faults with a 500 status.
+		// Hand them back along with the error so callers can see
+		// what went wrong.
+		if res.StatusCode == 500 {
+			response, err = soap.Parse(bytes.NewReader(b))
+			if err == nil && response.Fault() != nil {
+				if c.Debug {
+					log.Printf("res: %#v\nbody:\n%s\n", res, response.String())
+				}
+				return response, herr
+			}
+		}
+		return nil, herr
 	}
 	response, err = soap.Parse(res.Body)
 	if err != nil {
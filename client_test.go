@@ -0,0 +1,227 @@
+package wsman_test
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/search"
+	"github.com/VictorLowther/wsman"
+	"github.com/VictorLowther/wsman/wsmantest"
+)
+
+const fanURI = "http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_Fan"
+
+// fan answers a Get of CIM_Fan with the DeviceID it was asked for.
+func fan(req *wsmantest.Request) (*dom.Element, error) {
+	id := req.Selectors["DeviceID"]
+	if id == "" {
+		return nil, wsmantest.InvalidSelectors("No DeviceID")
+	}
+	return dom.Elem("CIM_Fan", fanURI).AddChild(dom.ElemC("DeviceID", fanURI, id)), nil
+}
+
+func fans(n int) wsmantest.ItemsHandler {
+	return func(req *wsmantest.Request) ([]*dom.Element, error) {
+		res := []*dom.Element{}
+		for i := 1; i <= n; i++ {
+			res = append(res, dom.Elem("CIM_Fan", fanURI).AddChild(
+				dom.ElemC("DeviceID", fanURI, fmt.Sprintf("Fan.%d", i))))
+		}
+		return res, nil
+	}
+}
+
+func deviceID(t *testing.T, e *dom.Element) string {
+	id := search.First(search.Tag("DeviceID", fanURI), e.Children())
+	if id == nil {
+		t.Fatalf("%s has no DeviceID", e.Name.Local)
+	}
+	return strings.TrimSpace(string(id.Content))
+}
+
+func getFan(t *testing.T, client *wsman.Client, id string) {
+	reply, err := client.Get(fanURI).Selectors("DeviceID", id).Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err := reply.GetItem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := deviceID(t, item); got != id {
+		t.Errorf("Got DeviceID %q, wanted %q", got, id)
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	s := wsmantest.NewServer()
+	defer s.Close()
+	reply, err := s.NewClient().Identify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if search.First(search.Tag("ProductVendor", wsman.NS_WSMID), reply.AllBodyElements()) == nil {
+		t.Error("Identify reply has no ProductVendor")
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	s := wsmantest.NewServer()
+	defer s.Close()
+	s.Username, s.Password = "root", "calvin"
+	s.HandleGet(fanURI, fan)
+	getFan(t, s.NewClient(), "Fan.1")
+	if auth := s.Requests()[0].HTTP.Header.Get("Authorization"); !strings.HasPrefix(auth, "Basic ") {
+		t.Errorf("Sent Authorization %q", auth)
+	}
+
+	bad := wsman.NewClient(s.Endpoint(), "root", "wrong", false)
+	_, err := bad.Get(fanURI).Selectors("DeviceID", "Fan.1").Send()
+	if herr, ok := err.(*wsman.HTTPError); !ok || herr.StatusCode != 401 {
+		t.Errorf("Bad password got %v, wanted a 401", err)
+	}
+}
+
+func TestDigestAuth(t *testing.T) {
+	s := wsmantest.NewServer()
+	defer s.Close()
+	s.Username, s.Password, s.Digest = "root", "calvin", true
+	s.HandleGet(fanURI, fan)
+	client := s.NewClient()
+	getFan(t, client, "Fan.1")
+	if auth := s.Requests()[0].HTTP.Header.Get("Authorization"); !strings.HasPrefix(auth, "Digest ") {
+		t.Errorf("Sent Authorization %q", auth)
+	}
+	// The client has to pick up the new nonce and send the request
+	// again.
+	s.Inject("", wsman.GET, 1, wsmantest.StaleNonce)
+	getFan(t, client, "Fan.2")
+	getFan(t, client, "Fan.3")
+
+	bad, err := wsman.Connect(s.Endpoint(), "root", "wrong", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bad.Get(fanURI).Selectors("DeviceID", "Fan.1").Send(); err == nil {
+		t.Error("Bad password should have failed")
+	}
+}
+
+func TestNoDigest(t *testing.T) {
+	s := wsmantest.NewServer()
+	defer s.Close()
+	if _, err := wsman.Connect(s.Endpoint(), "root", "calvin", true); err == nil {
+		t.Error("Connect should fail when the endpoint does not ask for digest auth")
+	}
+}
+
+func TestEnumeratePull(t *testing.T) {
+	s := wsmantest.NewServer()
+	defer s.Close()
+	s.HandleEnumerate(fanURI, fans(5))
+	client := s.NewClient()
+	reply, err := client.Enumerate(fanURI).Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := reply.EnumItems()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 5 {
+		t.Fatalf("Got %d items, wanted 5", len(items))
+	}
+	for i, item := range items {
+		if got, want := deviceID(t, item), fmt.Sprintf("Fan.%d", i+1); got != want {
+			t.Errorf("Item %d is %s, wanted %s", i, got, want)
+		}
+	}
+	// The server hands out one item per Pull unless told otherwise.
+	reqs := s.Requests()
+	if len(reqs) != 6 || reqs[0].Action != wsman.ENUMERATE || reqs[5].Action != wsman.PULL {
+		t.Errorf("Sent %d requests, wanted an Enumerate and 5 Pulls", len(reqs))
+	}
+}
+
+func TestEnumerateOptimized(t *testing.T) {
+	s := wsmantest.NewServer()
+	defer s.Close()
+	s.HandleEnumerate(fanURI, fans(150))
+	client := s.NewClient()
+	client.OptimizeEnum = true
+	reply, err := client.Enumerate(fanURI).Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := reply.EnumItems()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 150 {
+		t.Errorf("Got %d items, wanted 150", len(items))
+	}
+	// 100 come back with the Enumerate, and the rest with one Pull.
+	if n := len(s.Requests()); n != 2 {
+		t.Errorf("Sent %d requests, wanted 2", n)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	s := wsmantest.NewServer()
+	defer s.Close()
+	s.HandleGet(fanURI, fan)
+	client := s.NewClient()
+	for _, test := range []struct {
+		msg     *wsman.Message
+		subcode string
+	}{
+		{client.Get(fanURI), "InvalidSelectors"},
+		{client.Get(fanURI+"X").Selectors("DeviceID", "Fan.1"), "DestinationUnreachable"},
+		{client.Delete(fanURI).Selectors("DeviceID", "Fan.1"), "ActionNotSupported"},
+	} {
+		reply, err := test.msg.Send()
+		if err == nil {
+			t.Errorf("Expected a %s fault", test.subcode)
+			continue
+		}
+		if reply == nil || reply.Fault() == nil {
+			t.Errorf("Expected the %s fault to be returned, got %v", test.subcode, err)
+			continue
+		}
+		if got := reply.FaultSubcode(); got != test.subcode {
+			t.Errorf("Got fault %s, wanted %s", got, test.subcode)
+		}
+		if reply.FaultReason() == "" {
+			t.Errorf("The %s fault has no reason", test.subcode)
+		}
+	}
+}
+
+func TestPullFault(t *testing.T) {
+	s := wsmantest.NewServer()
+	defer s.Close()
+	s.HandleEnumerate(fanURI, fans(3))
+	s.Inject("", wsman.PULL, 1, wsmantest.ExpireContext)
+	_, err := s.NewClient().Enumerate(fanURI).Send()
+	if err == nil || !strings.Contains(err.Error(), "InvalidEnumerationContext") {
+		t.Errorf("Got %v, wanted an InvalidEnumerationContext fault", err)
+	}
+}
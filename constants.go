@@ -70,4 +70,27 @@ const (
 
 	// Used for a singleton event that does not define its own action
 	EVENT = "http://schemas.dmtf.org/wbem/wsman/1/wsman/Event"
+
+	// Starts a command in a remote shell
+	COMMAND = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Command"
+
+	// Sends a signal to a command running in a remote shell
+	SIGNAL = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Signal"
+
+	// Feeds input to a command running in a remote shell
+	SEND = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Send"
+
+	// Retrieves output from a remote shell or a command running in it
+	RECEIVE = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Receive"
+)
+
+const (
+	// The resource URI of a Windows cmd.exe shell
+	CMD_SHELL = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd"
+
+	// Asks a remote command to stop, like pressing Ctrl-C
+	SIGNAL_CTRL_C = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/ctrl_c"
+
+	// Forcibly terminates a remote command
+	SIGNAL_TERMINATE = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/terminate"
 )
@@ -67,6 +67,9 @@ func enumHelper(firstreq, resp *Message) error {
 			body.AddChild(enumEpr)
 		}
 		nextResp, err := req.Send()
+		if nextResp != nil {
+			resp.Tuned = append(resp.Tuned, nextResp.Tuned...)
+		}
 		if err != nil {
 			resp.client.enumRelease(context)
 			return err
@@ -0,0 +1,108 @@
+package wsman
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/search"
+	"github.com/VictorLowther/soap"
+)
+
+// MaxEnvelopeSizeCeiling is how far Send will raise the MaxEnvelopeSize
+// of a request whose reply did not fit, unless the Client's
+// MaxEnvelopeSize says otherwise.  It is what current versions of
+// WinRM accept by default.  Requests start out at
+// DefaultMaxEnvelopeSize.
+const MaxEnvelopeSizeCeiling = 512000
+
+// MaxEnvelopeSize sets the MaxEnvelopeSize header of the message,
+// which tells the endpoint how big a reply may be.
+func (m *Message) MaxEnvelopeSize(size int) *Message {
+	m.SetHeader(soap.MuElemC("MaxEnvelopeSize", NS_WSMAN, strconv.Itoa(size)))
+	return m
+}
+
+// FaultDetail returns the last part of the wsman:FaultDetail of the
+// SOAP fault the message contains, such as "MaxEnvelopeSize".  It
+// returns an empty string if there is no fault or no detail.
+func (m *Message) FaultDetail() string {
+	detail := m.faultPart("Detail")
+	if detail == nil {
+		return ""
+	}
+	found := search.First(search.Tag("FaultDetail", NS_WSMAN), detail.Children())
+	if found == nil {
+		return ""
+	}
+	uri := strings.TrimSpace(string(found.Content))
+	return uri[strings.LastIndex(uri, "/")+1:]
+}
+
+func (m *Message) tuned(format string, args ...interface{}) {
+	change := fmt.Sprintf(format, args...)
+	m.Tuned = append(m.Tuned, change)
+	m.newMessageID()
+	if m.client.Debug {
+		log.Printf("Retrying with %s", change)
+	}
+}
+
+// tune changes m so that the reply to it might fit in an envelope, if
+// the fault in reply says it did not.  Enumerations get fewer elements
+// per reply, and anything else asks for a bigger envelope.  It reports
+// whether there was anything left to change.
+func (m *Message) tune(reply *Message) bool {
+	if reply.FaultSubcode() != "EncodingLimit" {
+		return false
+	}
+	detail := reply.FaultDetail()
+	if detail != "MaxEnvelopeSize" && detail != "ServiceEnvelopeLimit" {
+		return false
+	}
+	if maxElem := search.First(search.Tag("MaxElements", NS_WSMAN), m.AllBodyElements()); maxElem != nil {
+		n, err := strconv.Atoi(strings.TrimSpace(string(maxElem.Content)))
+		if err == nil && n > 1 {
+			maxElem.Content = []byte(strconv.Itoa(n / 2))
+			m.tuned("MaxElements %d", n/2)
+			return true
+		}
+	}
+	// The endpoint will not send anything bigger than it already is.
+	if detail == "ServiceEnvelopeLimit" {
+		return false
+	}
+	size := DefaultMaxEnvelopeSize
+	if hdr := m.GetHeader(dom.Elem("MaxEnvelopeSize", NS_WSMAN)); hdr != nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(string(hdr.Content))); err == nil {
+			size = n
+		}
+	}
+	if size >= m.client.MaxEnvelopeSize {
+		return false
+	}
+	if size *= 2; size > m.client.MaxEnvelopeSize {
+		size = m.client.MaxEnvelopeSize
+	}
+	m.MaxEnvelopeSize(size)
+	m.tuned("MaxEnvelopeSize %d", size)
+	return true
+}
@@ -0,0 +1,123 @@
+package wsman_test
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/search"
+	"github.com/VictorLowther/wsman"
+	"github.com/VictorLowther/wsman/wsmantest"
+)
+
+const faultDetail = "http://schemas.dmtf.org/wbem/wsman/1/wsman/faultDetail/"
+
+var tooBig = wsmantest.SendFault(wsmantest.EncodingLimit(faultDetail + "MaxEnvelopeSize"))
+
+func envelopeSize(req *wsmantest.Request) string {
+	hdr := search.First(search.Tag("MaxEnvelopeSize", wsman.NS_WSMAN), req.AllHeaderElements())
+	if hdr == nil {
+		return ""
+	}
+	return strings.TrimSpace(string(hdr.Content))
+}
+
+func TestTuneEnvelopeSize(t *testing.T) {
+	s := wsmantest.NewServer()
+	defer s.Close()
+	s.HandleGet(fanURI, fan)
+	s.Inject("", wsman.GET, 2, tooBig)
+	reply, err := s.NewClient().Get(fanURI).Selectors("DeviceID", "Fan.1").Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"MaxEnvelopeSize 307200", "MaxEnvelopeSize 512000"}
+	if !reflect.DeepEqual(reply.Tuned, want) {
+		t.Errorf("Tuned is %v, wanted %v", reply.Tuned, want)
+	}
+	reqs := s.Requests()
+	if len(reqs) != 3 || envelopeSize(reqs[1]) != "307200" || envelopeSize(reqs[2]) != "512000" {
+		t.Errorf("Sent %d requests with the wrong MaxEnvelopeSize", len(reqs))
+	}
+	if reqs[0].MessageID == reqs[1].MessageID {
+		t.Error("The retry reused the MessageID")
+	}
+}
+
+func TestTuneCeiling(t *testing.T) {
+	s := wsmantest.NewServer()
+	defer s.Close()
+	s.HandleGet(fanURI, fan)
+	s.Inject("", wsman.GET, 0, tooBig)
+	client := s.NewClient()
+	client.MaxEnvelopeSize = 200000
+	reply, err := client.Get(fanURI).Selectors("DeviceID", "Fan.1").Send()
+	if err == nil || reply == nil || reply.FaultSubcode() != "EncodingLimit" {
+		t.Fatalf("Got %v, wanted an EncodingLimit fault", err)
+	}
+	if want := []string{"MaxEnvelopeSize 200000"}; !reflect.DeepEqual(reply.Tuned, want) {
+		t.Errorf("Tuned is %v, wanted %v", reply.Tuned, want)
+	}
+	if n := len(s.Requests()); n != 2 {
+		t.Errorf("Sent %d requests, wanted 2", n)
+	}
+}
+
+func TestTuneServiceLimit(t *testing.T) {
+	s := wsmantest.NewServer()
+	defer s.Close()
+	s.HandleGet(fanURI, fan)
+	s.Inject("", wsman.GET, 1, wsmantest.SendFault(wsmantest.EncodingLimit(faultDetail+"ServiceEnvelopeLimit")))
+	reply, err := s.NewClient().Get(fanURI).Selectors("DeviceID", "Fan.1").Send()
+	if err == nil || reply.FaultDetail() != "ServiceEnvelopeLimit" {
+		t.Errorf("Got %v, wanted the ServiceEnvelopeLimit fault", err)
+	}
+	if n := len(s.Requests()); n != 1 {
+		t.Errorf("A bigger envelope will not help, but sent %d requests", n)
+	}
+}
+
+func TestTuneMaxElements(t *testing.T) {
+	s := wsmantest.NewServer()
+	defer s.Close()
+	s.HandleEnumerate(fanURI, fans(60))
+	s.Inject("", wsman.ENUMERATE, 1, tooBig)
+	s.Inject("", wsman.PULL, 1, tooBig)
+	client := s.NewClient()
+	client.OptimizeEnum = true
+	reply, err := client.Enumerate(fanURI).Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := reply.EnumItems()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 60 {
+		t.Errorf("Got %d items, wanted 60", len(items))
+	}
+	if want := []string{"MaxElements 50", "MaxElements 25"}; !reflect.DeepEqual(reply.Tuned, want) {
+		t.Errorf("Tuned is %v, wanted %v", reply.Tuned, want)
+	}
+	// Enumerate, Enumerate of 50, Pull, Pull of 25 that finishes.
+	reqs := s.Requests()
+	if len(reqs) != 4 || reqs[1].MaxElements != 50 || reqs[3].MaxElements != 25 {
+		t.Errorf("Sent %d requests with the wrong MaxElements", len(reqs))
+	}
+}
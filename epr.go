@@ -0,0 +1,157 @@
+package wsman
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/search"
+)
+
+// ANONYMOUS is the address to use when there is no better one.
+const ANONYMOUS = "http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous"
+
+// Selector is a single selector of an EndpointReference.  Selectors
+// that refer to other instances have an EPR instead of a Value.
+type Selector struct {
+	Name, Value string
+	EPR         *EndpointReference
+}
+
+// EndpointReference is a WS-Addressing endpoint reference, which
+// identifies a single instance of a resource.  EnumerateEPR hands
+// these back, and CIM references are passed around as them.
+type EndpointReference struct {
+	Address     string
+	ResourceURI string
+	Selectors   []Selector
+}
+
+// ParseEPR parses e, which must have wsa:Address and
+// wsa:ReferenceParameters children, into an EndpointReference.
+func ParseEPR(e *dom.Element) (*EndpointReference, error) {
+	children := e.Children()
+	params := search.First(search.Tag("ReferenceParameters", NS_WSA), children)
+	if params == nil {
+		return nil, fmt.Errorf("%s is not an endpoint reference", e.Name.Local)
+	}
+	res := &EndpointReference{}
+	if addr := search.First(search.Tag("Address", NS_WSA), children); addr != nil {
+		res.Address = strings.TrimSpace(string(addr.Content))
+	}
+	if uri := search.First(search.Tag("ResourceURI", NS_WSMAN), params.Children()); uri != nil {
+		res.ResourceURI = strings.TrimSpace(string(uri.Content))
+	}
+	if res.ResourceURI == "" {
+		return nil, fmt.Errorf("Endpoint reference has no ResourceURI")
+	}
+	selset := search.First(search.Tag("SelectorSet", NS_WSMAN), params.Children())
+	if selset == nil {
+		return res, nil
+	}
+	for _, sel := range selset.Children() {
+		selector := Selector{}
+		for _, attr := range sel.Attributes {
+			if attr.Name.Local == "Name" {
+				selector.Name = attr.Value
+			}
+		}
+		if ref := search.First(search.Tag("EndpointReference", NS_WSA), sel.Children()); ref != nil {
+			epr, err := ParseEPR(ref)
+			if err != nil {
+				return nil, err
+			}
+			selector.EPR = epr
+		} else {
+			selector.Value = strings.TrimSpace(string(sel.Content))
+		}
+		res.Selectors = append(res.Selectors, selector)
+	}
+	return res, nil
+}
+
+// Selector returns the value of the named selector, and whether the
+// EndpointReference has it.
+func (epr *EndpointReference) Selector(name string) (string, bool) {
+	for _, sel := range epr.Selectors {
+		if sel.Name == name {
+			return sel.Value, true
+		}
+	}
+	return "", false
+}
+
+// makeSelectors makes the elements of a SelectorSet for the selectors.
+func (epr *EndpointReference) makeSelectors() []*dom.Element {
+	res := make([]*dom.Element, len(epr.Selectors))
+	for i, sel := range epr.Selectors {
+		res[i] = dom.Elem("Selector", NS_WSMAN).Attr("Name", "", sel.Name)
+		if sel.EPR != nil {
+			res[i].AddChild(sel.EPR.Element("EndpointReference", NS_WSA))
+		} else {
+			res[i].Content = []byte(sel.Value)
+		}
+	}
+	return res
+}
+
+// Element turns the EndpointReference into an element with the
+// passed name, suitable for use as a reference parameter or property.
+func (epr *EndpointReference) Element(name, space string) *dom.Element {
+	addr := epr.Address
+	if addr == "" {
+		addr = ANONYMOUS
+	}
+	params := dom.Elem("ReferenceParameters", NS_WSA).AddChild(
+		dom.ElemC("ResourceURI", NS_WSMAN, epr.ResourceURI))
+	if len(epr.Selectors) > 0 {
+		selset := dom.Elem("SelectorSet", NS_WSMAN)
+		selset.AddChildren(epr.makeSelectors()...)
+		params.AddChild(selset)
+	}
+	return dom.Elem(name, space).AddChild(
+		dom.ElemC("Address", NS_WSA, addr)).AddChild(params)
+}
+
+// String renders the EndpointReference as its ResourceURI followed by
+// its selectors, like a URL query.
+func (epr *EndpointReference) String() string {
+	parts := []string{}
+	for _, sel := range epr.Selectors {
+		if sel.EPR != nil {
+			parts = append(parts, fmt.Sprintf("%s=(%s)", sel.Name, sel.EPR))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s=%s", sel.Name, sel.Value))
+		}
+	}
+	if len(parts) == 0 {
+		return epr.ResourceURI
+	}
+	return epr.ResourceURI + "?" + strings.Join(parts, "&")
+}
+
+// Target points the message at the instance epr refers to, by setting
+// its ResourceURI and SelectorSet.
+func (m *Message) Target(epr *EndpointReference) *Message {
+	m.ResourceURI(epr.ResourceURI)
+	if len(epr.Selectors) > 0 {
+		m.AddSelector(epr.makeSelectors()...)
+	}
+	return m
+}
@@ -0,0 +1,186 @@
+package wsman_test
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/search"
+	"github.com/VictorLowther/wsman"
+)
+
+const profileURI = "http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_RegisteredProfile"
+
+func TestEPRRoundTrip(t *testing.T) {
+	s, repo := fanRepository(t, true)
+	defer s.Close()
+	client := s.NewClient()
+
+	epr, err := client.CreateInstance(fanURI, &fanSettings{DeviceID: "Fan.2", ElementName: "Rear"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if epr.ResourceURI != fanURI || epr.Address != s.Endpoint() {
+		t.Errorf("Created %s at %s", epr, epr.Address)
+	}
+	if id, ok := epr.Selector("DeviceID"); !ok || id != "Fan.2" {
+		t.Errorf("Created %s", epr)
+	}
+	if _, err := client.CreateInstance(fanURI, &fanSettings{DeviceID: "Fan.2"}); err == nil {
+		t.Errorf("Created Fan.2 twice")
+	}
+
+	reply, err := client.GetEPR(epr).Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := fanSettings{}
+	if err := reply.Unmarshal(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.DeviceID != "Fan.2" || got.ElementName != "Rear" {
+		t.Errorf("Got %+v", got)
+	}
+
+	put := client.PutEPR(epr)
+	if err := put.Marshal(&fanSettings{DeviceID: "Fan.2", ElementName: "Back"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := put.Send(); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err = client.GetEPR(epr).Send(); err != nil {
+		t.Fatal(err)
+	}
+	if err := reply.Unmarshal(&got); err != nil || got.ElementName != "Back" {
+		t.Errorf("Put did not stick: %+v, %v", got, err)
+	}
+
+	if _, err := client.DeleteEPR(epr).Send(); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := client.GetEPR(epr).Send(); err == nil || reply.FaultSubcode() != "InvalidSelectors" {
+		t.Errorf("Got %v getting a deleted instance", err)
+	}
+	if n := len(repo.Instances(fanURI)); n != 1 {
+		t.Errorf("Repository has %d fans, wanted 1", n)
+	}
+}
+
+func TestResourceCreatedMissing(t *testing.T) {
+	s, _ := fanRepository(t, true)
+	defer s.Close()
+	reply, err := s.NewClient().Get(fanURI).Selectors("DeviceID", "Fan.1").Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if epr, err := reply.ResourceCreated(); err == nil {
+		t.Errorf("Found %s in a Get reply", epr)
+	}
+}
+
+// nestedEPR refers to a profile by reference to the fan.
+var nestedEPR = &wsman.EndpointReference{
+	Address:     "https://bmc/wsman",
+	ResourceURI: profileURI,
+	Selectors: []wsman.Selector{
+		{Name: "InstanceID", Value: "DCIM:Fan:1.0"},
+		{Name: "Element", EPR: &wsman.EndpointReference{
+			Address:     wsman.ANONYMOUS,
+			ResourceURI: fanURI,
+			Selectors:   []wsman.Selector{{Name: "DeviceID", Value: "Fan.1"}},
+		}},
+	},
+}
+
+func TestParseEPR(t *testing.T) {
+	e := nestedEPR.Element("Antecedent", "urn:x")
+	if e.Name.Local != "Antecedent" || e.Name.Space != "urn:x" {
+		t.Errorf("Element is %s in %s", e.Name.Local, e.Name.Space)
+	}
+	got, err := wsman.ParseEPR(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, nestedEPR) {
+		t.Errorf("Got %#v, wanted %#v", got, nestedEPR)
+	}
+	// EPRs with no Address get the anonymous one.
+	bare := &wsman.EndpointReference{ResourceURI: fanURI}
+	if got, err := wsman.ParseEPR(bare.Element("EPR", wsman.NS_WSA)); err != nil || got.Address != wsman.ANONYMOUS || len(got.Selectors) != 0 {
+		t.Errorf("Got %#v, %v", got, err)
+	}
+	if want := profileURI + "?InstanceID=DCIM:Fan:1.0&Element=(" + fanURI + "?DeviceID=Fan.1)"; nestedEPR.String() != want {
+		t.Errorf("Got %s, wanted %s", nestedEPR, want)
+	}
+	if v, ok := nestedEPR.Selector("Element"); !ok || v != "" {
+		t.Errorf("Got %q, %v for a reference selector", v, ok)
+	}
+	if _, ok := nestedEPR.Selector("DeviceID"); ok {
+		t.Errorf("Found a selector of the nested EPR")
+	}
+}
+
+func TestParseEPRMalformed(t *testing.T) {
+	noParams := dom.Elem("EPR", wsman.NS_WSA).AddChild(dom.ElemC("Address", wsman.NS_WSA, wsman.ANONYMOUS))
+	noURI := dom.Elem("EPR", wsman.NS_WSA).AddChild(dom.Elem("ReferenceParameters", wsman.NS_WSA))
+	// The nested EPR has no ResourceURI.
+	badNested := (&wsman.EndpointReference{
+		ResourceURI: profileURI,
+		Selectors:   []wsman.Selector{{Name: "Element", EPR: &wsman.EndpointReference{}}},
+	}).Element("EPR", wsman.NS_WSA)
+	for name, e := range map[string]*dom.Element{
+		"no ReferenceParameters": noParams,
+		"no ResourceURI":         noURI,
+		"bad nested EPR":         badNested,
+		"not an EPR":             dom.ElemC("DeviceID", fanURI, "Fan.1"),
+	} {
+		if epr, err := wsman.ParseEPR(e); err == nil {
+			t.Errorf("Parsed %s as %s", name, epr)
+		}
+	}
+}
+
+func TestTarget(t *testing.T) {
+	s, _ := fanRepository(t, true)
+	defer s.Close()
+	msg := s.NewClient().GetEPR(nestedEPR)
+	if got := msg.GetResource(); got != profileURI {
+		t.Errorf("Got ResourceURI %s", got)
+	}
+	selset := search.First(search.Tag("SelectorSet", wsman.NS_WSMAN), msg.AllHeaderElements())
+	if selset == nil {
+		t.Fatal("No SelectorSet")
+	}
+	sels := selset.Children()
+	if len(sels) != 2 || string(sels[0].Content) != "DCIM:Fan:1.0" {
+		t.Fatalf("Got selectors %v", sels)
+	}
+	ref, err := wsman.ParseEPR(search.First(search.Tag("EndpointReference", wsman.NS_WSA), sels[1].Children()))
+	if err != nil || !reflect.DeepEqual(ref, nestedEPR.Selectors[1].EPR) {
+		t.Errorf("Got reference selector %v, %v", ref, err)
+	}
+	// The endpoint sees the selectors of an EPR like any others.
+	if _, err := s.NewClient().GetEPR(&wsman.EndpointReference{
+		ResourceURI: fanURI,
+		Selectors:   []wsman.Selector{{Name: "DeviceID", Value: "Fan.1"}},
+	}).Send(); err != nil {
+		t.Errorf("Get of a targeted fan: %v", err)
+	}
+}
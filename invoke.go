@@ -0,0 +1,200 @@
+package wsman
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/search"
+)
+
+// ReturnValues that mean the same thing for most methods that follow
+// the DMTF profiles, including Dell's.
+const (
+	RETURN_SUCCESS     = 0
+	RETURN_FAILED      = 1
+	RETURN_JOB_STARTED = 4096
+)
+
+// InvokeStatus is what a ReturnValue says happened.
+type InvokeStatus int
+
+const (
+	InvokeSucceeded InvokeStatus = iota
+	InvokeFailed
+	InvokeJobStarted
+)
+
+func (s InvokeStatus) String() string {
+	switch s {
+	case InvokeSucceeded:
+		return "succeeded"
+	case InvokeFailed:
+		return "failed"
+	case InvokeJobStarted:
+		return "job started"
+	}
+	return fmt.Sprintf("InvokeStatus(%d)", int(s))
+}
+
+// InvokeResult is the decoded reply to an Invoke message.
+type InvokeResult struct {
+	Method string
+	// ReturnValue is the raw ReturnValue.  Methods with no ReturnValue
+	// are taken to have succeeded.
+	ReturnValue uint32
+	Status      InvokeStatus
+	// Message, MessageID, and MessageArguments explain what happened,
+	// for methods like Dell's that return them.
+	Message, MessageID string
+	MessageArguments   []string
+	// Job refers to the job the method started, if it started one.
+	Job *EndpointReference
+	// Output is the Method_OUTPUT element of the reply.
+	Output *dom.Element
+}
+
+// InvokeError is returned by InvokeResult.Err for methods that failed.
+type InvokeError struct {
+	Method      string
+	ReturnValue uint32
+	Message     string
+	MessageID   string
+}
+
+func (e *InvokeError) Error() string {
+	res := fmt.Sprintf("%s failed with ReturnValue %d", e.Method, e.ReturnValue)
+	if e.MessageID != "" {
+		res += " " + e.MessageID
+	}
+	if e.Message != "" {
+		res += ": " + e.Message
+	}
+	return res
+}
+
+// outputEPR parses e as an endpoint reference, either directly or
+// wrapped in a wsa:EndpointReference.
+func outputEPR(e *dom.Element) *EndpointReference {
+	if wrapped := search.First(search.Tag("EndpointReference", NS_WSA), e.Children()); wrapped != nil {
+		e = wrapped
+	}
+	epr, err := ParseEPR(e)
+	if err != nil {
+		return nil
+	}
+	return epr
+}
+
+// InvokeResult decodes the reply to an Invoke message.  A ReturnValue
+// of 0 is success, 4096 means a job was started, and anything else is
+// a failure.  Use Err to turn failures into errors.
+func (m *Message) InvokeResult() (*InvokeResult, error) {
+	out, _, err := m.InvokeResponse()
+	if out == nil {
+		return nil, err
+	}
+	res := &InvokeResult{
+		Method: strings.TrimSuffix(out.Name.Local, "_OUTPUT"),
+		Output: out,
+	}
+	for _, param := range out.Children() {
+		content := strings.TrimSpace(string(param.Content))
+		switch param.Name.Local {
+		case "ReturnValue":
+			n, err := strconv.ParseUint(content, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("%s has a bad ReturnValue %q", res.Method, content)
+			}
+			res.ReturnValue = uint32(n)
+		case "Message":
+			res.Message = content
+		case "MessageID":
+			res.MessageID = content
+		case "MessageArguments":
+			res.MessageArguments = append(res.MessageArguments, content)
+		case "Job":
+			res.Job = outputEPR(param)
+		}
+	}
+	switch res.ReturnValue {
+	case RETURN_SUCCESS:
+		res.Status = InvokeSucceeded
+	case RETURN_JOB_STARTED:
+		res.Status = InvokeJobStarted
+	default:
+		res.Status = InvokeFailed
+	}
+	return res, nil
+}
+
+// Err returns an *InvokeError if the method failed, and nil otherwise.
+func (r *InvokeResult) Err() error {
+	if r.Status != InvokeFailed {
+		return nil
+	}
+	return &InvokeError{
+		Method:      r.Method,
+		ReturnValue: r.ReturnValue,
+		Message:     r.Message,
+		MessageID:   r.MessageID,
+	}
+}
+
+// Decode sets the fields of v, a pointer to a struct, from the output
+// parameters, the same way Unmarshal does.
+func (r *InvokeResult) Decode(v interface{}) error {
+	return Unmarshal(r.Output, v)
+}
+
+// Map returns the output parameters by name.  Values are strings,
+// *EndpointReferences, or nil for xsi:nil, and parameters that appear
+// more than once are []interface{} of them.
+func (r *InvokeResult) Map() map[string]interface{} {
+	res := map[string]interface{}{}
+	for _, param := range r.Output.Children() {
+		var val interface{}
+		switch {
+		case isNilElem(param):
+		case len(param.Children()) > 0:
+			if epr := outputEPR(param); epr != nil {
+				val = epr
+			} else {
+				val = strings.TrimSpace(string(param.Content))
+			}
+		default:
+			val = strings.TrimSpace(string(param.Content))
+		}
+		name := param.Name.Local
+		switch prev := res[name].(type) {
+		case nil:
+			if _, ok := res[name]; ok {
+				res[name] = []interface{}{nil, val}
+			} else {
+				res[name] = val
+			}
+		case []interface{}:
+			res[name] = append(prev, val)
+		default:
+			res[name] = []interface{}{prev, val}
+		}
+	}
+	return res
+}
@@ -0,0 +1,145 @@
+package wsman_test
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/wsman"
+	"github.com/VictorLowther/wsman/wsmantest"
+)
+
+const jobURI = "http://schemas.dell.com/wbem/wscim/1/cim-schema/2/DCIM_LifecycleJob"
+
+// output makes a Method_OUTPUT element with the passed parameters.
+func output(method string, params ...*dom.Element) *dom.Element {
+	res := dom.Elem(method+"_OUTPUT", fanURI)
+	for _, param := range params {
+		res.AddChild(param)
+	}
+	return res
+}
+
+func invoke(t *testing.T, method string, h wsmantest.Handler) *wsman.InvokeResult {
+	s := wsmantest.NewServer()
+	defer s.Close()
+	s.HandleInvoke(fanURI, method, h)
+	reply, err := s.NewClient().Invoke(fanURI, method).Selectors("DeviceID", "Fan.1").Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := reply.InvokeResult()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Method != method {
+		t.Errorf("Method is %q, wanted %q", res.Method, method)
+	}
+	return res
+}
+
+func TestInvokeSucceeded(t *testing.T) {
+	res := invoke(t, "GetSpeed", func(req *wsmantest.Request) (*dom.Element, error) {
+		return output("GetSpeed",
+			dom.ElemC("ReturnValue", fanURI, "0"),
+			dom.ElemC("Speed", fanURI, "3000"),
+			dom.ElemC("Levels", fanURI, "1"),
+			dom.ElemC("Levels", fanURI, "2")), nil
+	})
+	if res.Status != wsman.InvokeSucceeded || res.ReturnValue != 0 || res.Err() != nil {
+		t.Errorf("Got %v with ReturnValue %d, wanted success", res.Status, res.ReturnValue)
+	}
+	out := &struct {
+		ReturnValue uint32
+		Speed       int
+		Levels      []string
+	}{}
+	if err := res.Decode(out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Speed != 3000 || !reflect.DeepEqual(out.Levels, []string{"1", "2"}) {
+		t.Errorf("Decoded %+v", out)
+	}
+	if m := res.Map(); m["Speed"] != "3000" || !reflect.DeepEqual(m["Levels"], []interface{}{"1", "2"}) {
+		t.Errorf("Map is %v", m)
+	}
+}
+
+func TestInvokeFailed(t *testing.T) {
+	res := invoke(t, "SetSpeed", func(req *wsmantest.Request) (*dom.Element, error) {
+		return output("SetSpeed",
+			dom.ElemC("ReturnValue", fanURI, "2"),
+			dom.ElemC("MessageID", fanURI, "FAN001"),
+			dom.ElemC("Message", fanURI, "Speed out of range"),
+			dom.ElemC("MessageArguments", fanURI, "9000")), nil
+	})
+	if res.Status != wsman.InvokeFailed {
+		t.Errorf("Got %v, wanted failed", res.Status)
+	}
+	if !reflect.DeepEqual(res.MessageArguments, []string{"9000"}) {
+		t.Errorf("MessageArguments are %v", res.MessageArguments)
+	}
+	err, ok := res.Err().(*wsman.InvokeError)
+	if !ok {
+		t.Fatalf("Err is %v, wanted an *InvokeError", res.Err())
+	}
+	want := &wsman.InvokeError{Method: "SetSpeed", ReturnValue: 2, MessageID: "FAN001", Message: "Speed out of range"}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("Got %+v, wanted %+v", err, want)
+	}
+	if got := err.Error(); got != "SetSpeed failed with ReturnValue 2 FAN001: Speed out of range" {
+		t.Errorf("Error is %q", got)
+	}
+}
+
+func TestInvokeJobStarted(t *testing.T) {
+	job := &wsman.EndpointReference{
+		ResourceURI: jobURI,
+		Selectors:   []wsman.Selector{{Name: "InstanceID", Value: "JID_001"}},
+	}
+	res := invoke(t, "Reset", func(req *wsmantest.Request) (*dom.Element, error) {
+		return output("Reset",
+			dom.ElemC("ReturnValue", fanURI, "4096"),
+			job.Element("Job", fanURI)), nil
+	})
+	if res.Status != wsman.InvokeJobStarted || res.Err() != nil {
+		t.Errorf("Got %v, wanted job started", res.Status)
+	}
+	if res.Job == nil || res.Job.ResourceURI != jobURI {
+		t.Fatalf("Job is %v", res.Job)
+	}
+	if id, _ := res.Job.Selector("InstanceID"); id != "JID_001" {
+		t.Errorf("Job InstanceID is %q", id)
+	}
+}
+
+func TestInvokeNoOutput(t *testing.T) {
+	s := wsmantest.NewServer()
+	defer s.Close()
+	s.HandleInvoke(fanURI, "Reset", func(req *wsmantest.Request) (*dom.Element, error) {
+		return dom.Elem("Other_OUTPUT", fanURI), nil
+	})
+	reply, err := s.NewClient().Invoke(fanURI, "Reset").Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reply.InvokeResult(); err == nil {
+		t.Error("A reply without Reset_OUTPUT should not decode")
+	}
+}
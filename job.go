@@ -0,0 +1,234 @@
+package wsman
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JobStates of CIM_ConcreteJob.
+const (
+	JOB_NEW           = 2
+	JOB_STARTING      = 3
+	JOB_RUNNING       = 4
+	JOB_SUSPENDED     = 5
+	JOB_SHUTTING_DOWN = 6
+	JOB_COMPLETED     = 7
+	JOB_TERMINATED    = 8
+	JOB_KILLED        = 9
+	JOB_EXCEPTION     = 10
+)
+
+// How often WaitForJob polls.  It starts at JobPollInterval, and backs
+// off by half again each time up to JobPollMaxInterval.
+var (
+	JobPollInterval    = 2 * time.Second
+	JobPollMaxInterval = 30 * time.Second
+)
+
+// Job is the state of a CIM_ConcreteJob, or of a subclass like
+// DCIM_LifecycleJob.
+type Job struct {
+	EPR        *EndpointReference
+	InstanceID string
+	// JobState is one of the JOB_ constants, or 0 for jobs like
+	// DCIM_LifecycleJob that only have a JobStatus.
+	JobState  int
+	JobStatus string
+	// PercentComplete is -1 if the job does not say.
+	PercentComplete int
+	// Message and MessageID are set by DCIM jobs, and ErrorCode and
+	// ErrorDescription by jobs that follow the DMTF Job Control
+	// profile.
+	Message, MessageID string
+	ErrorCode          string
+	ErrorDescription   string
+}
+
+// Done reports whether the job has finished, one way or another.
+func (j *Job) Done() bool {
+	return j.Succeeded() || j.Failed()
+}
+
+// Succeeded reports whether the job finished without error.
+func (j *Job) Succeeded() bool {
+	if j.JobState != 0 {
+		return j.JobState == JOB_COMPLETED
+	}
+	return strings.EqualFold(j.JobStatus, "Completed")
+}
+
+// Failed reports whether the job finished with an error.
+func (j *Job) Failed() bool {
+	switch j.JobState {
+	case 0:
+	case JOB_TERMINATED, JOB_KILLED, JOB_EXCEPTION:
+		return true
+	default:
+		return false
+	}
+	status := strings.ToLower(j.JobStatus)
+	return strings.Contains(status, "fail") ||
+		strings.Contains(status, "error") ||
+		status == "killed" ||
+		status == "terminated"
+}
+
+func (j *Job) String() string {
+	res := j.InstanceID
+	if j.JobStatus != "" {
+		res += " " + j.JobStatus
+	} else {
+		res += fmt.Sprintf(" JobState %d", j.JobState)
+	}
+	if j.PercentComplete >= 0 {
+		res += fmt.Sprintf(" (%d%%)", j.PercentComplete)
+	}
+	if j.Message != "" {
+		res += ": " + j.Message
+	} else if j.ErrorDescription != "" {
+		res += ": " + j.ErrorDescription
+	}
+	return res
+}
+
+// JobError is returned by WaitForJob when the job fails, or when the
+// context is done before it finishes.  Job is the last state of the
+// job that was seen.
+type JobError struct {
+	Job *Job
+	// Err is the error from the context if the wait timed out or was
+	// canceled, and nil if the job failed.
+	Err error
+}
+
+func (e *JobError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("Gave up waiting for job %s: %v", e.Job, e.Err)
+	}
+	return fmt.Sprintf("Job %s failed", e.Job)
+}
+
+// TimedOut reports whether the wait ended because the context did.
+func (e *JobError) TimedOut() bool {
+	return e.Err != nil
+}
+
+// GetJob fetches the current state of the job epr refers to, giving
+// up when ctx is done.
+func (c *Client) GetJob(ctx context.Context, epr *EndpointReference) (*Job, error) {
+	job, _, err := c.getJob(ctx, epr)
+	return job, err
+}
+
+// getJob is GetJob, and also returns the reply, to tell faults apart.
+func (c *Client) getJob(ctx context.Context, epr *EndpointReference) (*Job, *Message, error) {
+	reply, err := c.GetEPR(epr).Context(ctx).Send()
+	if err != nil {
+		return nil, reply, err
+	}
+	body := reply.Body()
+	if len(body) == 0 {
+		return nil, reply, fmt.Errorf("No job in reply to Get %s", epr)
+	}
+	res := &Job{EPR: epr, PercentComplete: -1}
+	for _, prop := range body[0].Children() {
+		content := strings.TrimSpace(string(prop.Content))
+		switch prop.Name.Local {
+		case "InstanceID":
+			res.InstanceID = content
+		case "JobState":
+			res.JobState, _ = strconv.Atoi(content)
+		case "JobStatus":
+			res.JobStatus = content
+		case "PercentComplete":
+			// Dell jobs say NA when they do not know.
+			if n, err := strconv.Atoi(content); err == nil {
+				res.PercentComplete = n
+			}
+		case "Message":
+			res.Message = content
+		case "MessageID":
+			res.MessageID = content
+		case "ErrorCode":
+			res.ErrorCode = content
+		case "ErrorDescription":
+			res.ErrorDescription = content
+		}
+	}
+	return res, reply, nil
+}
+
+// pollAgain reports whether a failed poll of a job might work next
+// time, because it timed out or the endpoint was too busy to answer.
+func pollAgain(reply *Message, err error) bool {
+	if reply != nil && reply.Fault() != nil {
+		return reply.FaultSubcode() == "TimedOut"
+	}
+	return transient(nil, err) != nil
+}
+
+// WaitForJob polls the job epr refers to until it finishes or ctx is
+// done, backing off between polls.  Polls that fail in ways that
+// might not happen again, like timeouts, are tried again at the next
+// poll, on top of whatever the Client's RetryPolicy does.  It returns
+// the final state of the job, along with a *JobError if the job failed
+// or ctx ended first.
+func (c *Client) WaitForJob(ctx context.Context, epr *EndpointReference) (*Job, error) {
+	return c.WatchJob(ctx, epr, nil)
+}
+
+// WatchJob is WaitForJob, but calls progress with the state of the job
+// every time it is polled.
+func (c *Client) WatchJob(ctx context.Context, epr *EndpointReference, progress func(*Job)) (*Job, error) {
+	interval := JobPollInterval
+	// Until the first poll works, all we know is which job it is.
+	job := &Job{EPR: epr, PercentComplete: -1}
+	job.InstanceID, _ = epr.Selector("InstanceID")
+	for {
+		polled, reply, err := c.getJob(ctx, epr)
+		switch {
+		case err == nil:
+			job = polled
+			if progress != nil {
+				progress(job)
+			}
+			if job.Failed() {
+				return job, &JobError{Job: job}
+			}
+			if job.Done() {
+				return job, nil
+			}
+		case ctx.Err() != nil:
+			return job, &JobError{Job: job, Err: ctx.Err()}
+		case !pollAgain(reply, err):
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return job, &JobError{Job: job, Err: ctx.Err()}
+		case <-time.After(interval):
+		}
+		if interval += interval / 2; interval > JobPollMaxInterval {
+			interval = JobPollMaxInterval
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package wsman_test
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/wsman"
+	"github.com/VictorLowther/wsman/wsmantest"
+)
+
+var jobEPR = &wsman.EndpointReference{
+	ResourceURI: jobURI,
+	Selectors:   []wsman.Selector{{Name: "InstanceID", Value: "JID_001"}},
+}
+
+// fakeJob is a job that goes through states, one per poll, and stays
+// in the last one.
+type fakeJob struct {
+	mu     sync.Mutex
+	states []int
+	polls  []time.Time
+}
+
+func (j *fakeJob) get(req *wsmantest.Request) (*dom.Element, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	state := j.states[0]
+	if len(j.states) > 1 {
+		j.states = j.states[1:]
+	}
+	j.polls = append(j.polls, time.Now())
+	return dom.Elem("DCIM_LifecycleJob", jobURI).AddChild(
+		dom.ElemC("InstanceID", jobURI, req.Selectors["InstanceID"])).AddChild(
+		dom.ElemC("JobState", jobURI, fmt.Sprint(state))).AddChild(
+		dom.ElemC("PercentComplete", jobURI, "NA")), nil
+}
+
+// fastPolls makes WaitForJob poll quickly, and returns a func that
+// puts things back.
+func fastPolls() func() {
+	oldInterval, oldMax := wsman.JobPollInterval, wsman.JobPollMaxInterval
+	wsman.JobPollInterval, wsman.JobPollMaxInterval = 20*time.Millisecond, 45*time.Millisecond
+	return func() { wsman.JobPollInterval, wsman.JobPollMaxInterval = oldInterval, oldMax }
+}
+
+func jobServer(states ...int) (*wsmantest.Server, *fakeJob) {
+	job := &fakeJob{states: states}
+	s := wsmantest.NewServer()
+	s.HandleGet(jobURI, job.get)
+	return s, job
+}
+
+func TestGetJob(t *testing.T) {
+	s, _ := jobServer(wsman.JOB_RUNNING)
+	defer s.Close()
+	defer fastPolls()()
+	job, err := s.NewClient().GetJob(context.Background(), jobEPR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.InstanceID != "JID_001" || job.JobState != wsman.JOB_RUNNING || job.PercentComplete != -1 || job.Done() {
+		t.Errorf("Got job %#v", job)
+	}
+}
+
+func TestWaitForJobCompleted(t *testing.T) {
+	s, fake := jobServer(wsman.JOB_NEW, wsman.JOB_RUNNING, wsman.JOB_RUNNING, wsman.JOB_RUNNING, wsman.JOB_COMPLETED)
+	defer s.Close()
+	defer fastPolls()()
+	seen := []int{}
+	job, err := s.NewClient().WatchJob(context.Background(), jobEPR, func(job *wsman.Job) {
+		seen = append(seen, job.JobState)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !job.Succeeded() {
+		t.Errorf("Job ended as %v", job)
+	}
+	if fmt.Sprint(seen) != "[2 4 4 4 7]" {
+		t.Errorf("Saw states %v", seen)
+	}
+	// The waits go 20ms, 30ms, 45ms, and stay there.
+	for i, min := range []time.Duration{20, 30, 45, 45} {
+		if wait := fake.polls[i+1].Sub(fake.polls[i]); wait < min*time.Millisecond {
+			t.Errorf("Wait %d was %v, wanted at least %dms", i, wait, min)
+		}
+	}
+}
+
+func TestWaitForJobException(t *testing.T) {
+	s, _ := jobServer(wsman.JOB_RUNNING, wsman.JOB_EXCEPTION)
+	defer s.Close()
+	defer fastPolls()()
+	job, err := s.NewClient().WaitForJob(context.Background(), jobEPR)
+	jerr, ok := err.(*wsman.JobError)
+	if !ok {
+		t.Fatalf("Got %v, wanted a JobError", err)
+	}
+	if jerr.TimedOut() || jerr.Job != job || job.JobState != wsman.JOB_EXCEPTION {
+		t.Errorf("Got %v for job %v", err, job)
+	}
+}
+
+func TestWaitForJobTransient(t *testing.T) {
+	s, fake := jobServer(wsman.JOB_RUNNING, wsman.JOB_COMPLETED)
+	defer s.Close()
+	defer fastPolls()()
+	// Busy endpoints do not end the wait.
+	s.Inject("", wsman.GET, 1, wsmantest.SendFault(wsmantest.TimedOut()))
+	s.Inject("", wsman.GET, 1, wsmantest.SendFault(&wsmantest.Fault{
+		Code: "Receiver", Subcode: "wsman:InternalError", Reason: "Busy", Status: http.StatusServiceUnavailable}))
+	job, err := s.NewClient().WaitForJob(context.Background(), jobEPR)
+	if err != nil || !job.Succeeded() {
+		t.Fatalf("Got %v, %v", job, err)
+	}
+	if len(fake.polls) != 2 {
+		t.Errorf("Job was polled %d times, wanted 2", len(fake.polls))
+	}
+
+	// Jobs that are not there will not come back.
+	s.Inject("", wsman.GET, 1, wsmantest.SendFault(wsmantest.InvalidSelectors("No such job")))
+	if job, err := s.NewClient().WaitForJob(context.Background(), jobEPR); err == nil || job != nil {
+		t.Errorf("Got %v, %v waiting for a missing job", job, err)
+	} else if _, ok := err.(*wsman.JobError); ok {
+		t.Errorf("Got a JobError for a missing job")
+	}
+}
+
+func TestWaitForJobCanceled(t *testing.T) {
+	s, _ := jobServer(wsman.JOB_RUNNING)
+	defer s.Close()
+	defer fastPolls()()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	job, err := s.NewClient().WaitForJob(ctx, jobEPR)
+	jerr, ok := err.(*wsman.JobError)
+	if !ok || !jerr.TimedOut() || jerr.Err != context.DeadlineExceeded {
+		t.Fatalf("Got %v, wanted a timed out JobError", err)
+	}
+	if job.JobState != wsman.JOB_RUNNING {
+		t.Errorf("Got job %v, wanted the last state seen", job)
+	}
+}
+
+func TestWaitForJobCanceledPoll(t *testing.T) {
+	s, _ := jobServer(wsman.JOB_RUNNING)
+	defer s.Close()
+	defer fastPolls()()
+	s.Inject("", wsman.GET, 1, wsmantest.Delay(time.Second))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	job, err := s.NewClient().WaitForJob(ctx, jobEPR)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Took %v to give up on a poll", elapsed)
+	}
+	jerr, ok := err.(*wsman.JobError)
+	if !ok || !jerr.TimedOut() {
+		t.Fatalf("Got %v, wanted a timed out JobError", err)
+	}
+	// The only poll never came back, so all that is known is which
+	// job it was.
+	if job.InstanceID != "JID_001" || job.JobState != 0 {
+		t.Errorf("Got job %#v", job)
+	}
+	if msg := err.Error(); msg == "" {
+		t.Errorf("JobError has no message")
+	}
+}
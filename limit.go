@@ -0,0 +1,176 @@
+package wsman
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Limiter caps how hard Clients talk to an endpoint.  Many BMCs only
+// handle a few WSMAN sessions at a time, and fall over when given
+// more.  A Limiter is safe to share between Clients and goroutines.
+//
+// Limits apply to every HTTP request made through the Transport of the
+// Limiter, so pass it to ConnectWith to cover the request that fetches
+// the digest challenge as well:
+//
+//	client, err := wsman.ConnectWith(endpoint, user, pass, true, limiters.For(endpoint).Transport(nil))
+type Limiter struct {
+	slots    chan struct{}
+	interval time.Duration
+	mux      sync.Mutex
+	next     time.Time
+}
+
+// NewLimiter makes a Limiter that lets at most maxInFlight requests be
+// outstanding at once, and starts at most rps requests per second.
+// Either limit is ignored if it is 0 or less.
+func NewLimiter(maxInFlight int, rps float64) *Limiter {
+	res := &Limiter{}
+	if maxInFlight > 0 {
+		res.slots = make(chan struct{}, maxInFlight)
+	}
+	if rps > 0 {
+		res.interval = time.Duration(float64(time.Second) / rps)
+	}
+	return res
+}
+
+// Acquire waits until another request may be sent.  Every Acquire
+// must be followed by a Release once the reply has been read.
+func (l *Limiter) Acquire() {
+	if l.slots != nil {
+		l.slots <- struct{}{}
+	}
+	if l.interval == 0 {
+		return
+	}
+	l.mux.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mux.Unlock()
+	time.Sleep(wait)
+}
+
+// Release lets someone else Acquire.
+func (l *Limiter) Release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// Limiters hands out a Limiter for each host, so that every Client
+// talking to the same host shares the same limits no matter which
+// goroutine made it.  The zero value has no limits until MaxInFlight
+// or RPS are set.
+type Limiters struct {
+	MaxInFlight int
+	RPS         float64
+	mux         sync.Mutex
+	hosts       map[string]*Limiter
+}
+
+// NewLimiters makes a Limiters whose Limiters are made with NewLimiter
+// and the passed limits.
+func NewLimiters(maxInFlight int, rps float64) *Limiters {
+	return &Limiters{
+		MaxInFlight: maxInFlight,
+		RPS:         rps,
+		hosts:       map[string]*Limiter{},
+	}
+}
+
+// For returns the Limiter for the host (and port) of endpoint, making
+// it if needed.  Set a different one with Set to give a host limits of
+// its own.
+func (l *Limiters) For(endpoint string) *Limiter {
+	host := endpoint
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.hosts == nil {
+		l.hosts = map[string]*Limiter{}
+	}
+	res, ok := l.hosts[host]
+	if !ok {
+		res = NewLimiter(l.MaxInFlight, l.RPS)
+		l.hosts[host] = res
+	}
+	return res
+}
+
+// Set makes limiter the Limiter for host, which includes the port if
+// endpoint URLs for it do.  Clients that already have the old one keep
+// it.
+func (l *Limiters) Set(host string, limiter *Limiter) {
+	l.mux.Lock()
+	if l.hosts == nil {
+		l.hosts = map[string]*Limiter{}
+	}
+	l.hosts[host] = limiter
+	l.mux.Unlock()
+}
+
+// Transport returns an http.RoundTripper that sends requests through
+// next once the Limiter lets it.  A request counts as in flight until
+// the body of its response is closed.  If next is nil, the transport
+// Connect uses is used.
+func (l *Limiter) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = defaultTransport()
+	}
+	return &limitedTransport{limiter: l, next: next}
+}
+
+type limitedTransport struct {
+	limiter *Limiter
+	next    http.RoundTripper
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.limiter.Acquire()
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.limiter.Release()
+		return nil, err
+	}
+	res.Body = &limitedBody{ReadCloser: res.Body, limiter: t.limiter}
+	return res, nil
+}
+
+// limitedBody releases its Limiter when it is closed.
+type limitedBody struct {
+	io.ReadCloser
+	limiter *Limiter
+	once    sync.Once
+}
+
+func (b *limitedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.limiter.Release)
+	return err
+}
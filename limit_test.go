@@ -0,0 +1,132 @@
+package wsman_test
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VictorLowther/wsman"
+	"github.com/VictorLowther/wsman/wsmantest"
+)
+
+// counter is an http.RoundTripper that keeps track of the requests
+// sent through it.
+type counter struct {
+	mu                sync.Mutex
+	inFlight, maxSeen int
+	starts            []time.Time
+}
+
+func (c *counter) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	c.inFlight++
+	if c.inFlight > c.maxSeen {
+		c.maxSeen = c.inFlight
+	}
+	c.starts = append(c.starts, time.Now())
+	c.mu.Unlock()
+	res, err := http.DefaultTransport.RoundTrip(req)
+	// Hold the request in flight a little so that overlaps show up.
+	time.Sleep(5 * time.Millisecond)
+	c.mu.Lock()
+	c.inFlight--
+	c.mu.Unlock()
+	return res, err
+}
+
+func TestLimiterSharedPerHost(t *testing.T) {
+	s := wsmantest.NewServer()
+	defer s.Close()
+	s.Username, s.Password, s.Digest = "root", "calvin", true
+	s.HandleGet(fanURI, fan)
+	// The first Get has to reauthorize, which is a second HTTP request.
+	s.Inject("", wsman.GET, 1, wsmantest.StaleNonce)
+	limiters := wsman.NewLimiters(1, 50)
+	if limiters.For(s.Endpoint()) != limiters.For(s.URL+"/other") {
+		t.Fatal("Endpoints on the same host got different Limiters")
+	}
+	under := &counter{}
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tr := limiters.For(s.Endpoint()).Transport(under)
+			client, err := wsman.ConnectWith(s.Endpoint(), s.Username, s.Password, true, tr)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := client.Get(fanURI).Selectors("DeviceID", "Fan.1").Send(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	// 4 digest probes, 4 Gets, and at least 1 reauthorized Get.
+	// Clients that fetched the old nonce reauthorize as well.
+	if len(under.starts) < 9 {
+		t.Fatalf("Sent %d HTTP requests, wanted at least 9", len(under.starts))
+	}
+	if under.maxSeen != 1 {
+		t.Errorf("%d requests were in flight at once, wanted 1", under.maxSeen)
+	}
+	starts := under.starts
+	sort.Sort(byTime(starts))
+	for i := 1; i < len(starts); i++ {
+		// 50 per second is one every 20ms.  A request can start late,
+		// which makes the gap to the next one look short, so measure
+		// from the first one, leaving some slack for it starting late.
+		want := time.Duration(i)*20*time.Millisecond - 5*time.Millisecond
+		if since := starts[i].Sub(starts[0]); since < want {
+			t.Errorf("Request %d started %v after the first, wanted at least %v", i, since, want)
+		}
+	}
+}
+
+func TestLimiterReleasesOnError(t *testing.T) {
+	l := wsman.NewLimiter(1, 0)
+	client, err := wsman.ConnectWith("http://127.0.0.1:1/wsman", "", "", false, l.Transport(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		done := make(chan error, 1)
+		go func() {
+			_, err := client.Get(fanURI).Send()
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Fatal("Get of a closed port should fail")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("A failed request did not release its slot")
+		}
+	}
+}
+
+type byTime []time.Time
+
+func (b byTime) Len() int           { return len(b) }
+func (b byTime) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byTime) Less(i, j int) bool { return b[i].Before(b[j]) }
@@ -0,0 +1,407 @@
+package wsman
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/wsman/cim"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	eprType      = reflect.TypeOf(EndpointReference{})
+)
+
+type resourcer interface {
+	ResourceURI() string
+}
+
+// field is a property of a struct.
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// fields lists the properties of a struct type, including the ones of
+// anonymous struct fields.
+func fields(t reflect.Type) []field {
+	res := []field{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		tag := f.Tag.Get("wsman")
+		if tag == "-" {
+			continue
+		}
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct && f.Type != timeType && f.Type != eprType {
+			for _, inner := range fields(f.Type) {
+				inner.index = append([]int{i}, inner.index...)
+				res = append(res, inner)
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		fi := field{name: f.Name, index: []int{i}}
+		if parts[0] != "" {
+			fi.name = parts[0]
+		}
+		for _, opt := range parts[1:] {
+			if opt == "omitempty" {
+				fi.omitEmpty = true
+			}
+		}
+		res = append(res, fi)
+	}
+	return res
+}
+
+// className is the last part of a ResourceURI.
+func className(resourceURI string) string {
+	return resourceURI[strings.LastIndex(resourceURI, "/")+1:]
+}
+
+// isEmpty is what omitempty checks, which is the same as for
+// encoding/json, except that zero times are empty too.
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Func, reflect.Chan:
+		return v.IsNil()
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return v.Interface().(time.Time).IsZero()
+		}
+		return false
+	}
+	return v.Interface() == reflect.Zero(v.Type()).Interface()
+}
+
+// marshalValue makes the elements for a single property.
+func marshalValue(name, space string, v reflect.Value) ([]*dom.Element, error) {
+	switch {
+	case v.Kind() == reflect.Ptr:
+		if v.IsNil() {
+			return []*dom.Element{dom.Elem(name, space).Attr("nil", NS_XSI, "true")}, nil
+		}
+		return marshalValue(name, space, v.Elem())
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8:
+		res := []*dom.Element{}
+		for i := 0; i < v.Len(); i++ {
+			elems, err := marshalValue(name, space, v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, elems...)
+		}
+		return res, nil
+	case v.Type() == timeType:
+		return cim.Elements(name, space, cim.Datetime{Time: v.Interface().(time.Time)}), nil
+	case v.Type() == durationType:
+		return cim.Elements(name, space, cim.Interval(v.Interface().(time.Duration))), nil
+	case v.Type() == eprType:
+		epr := v.Interface().(EndpointReference)
+		return []*dom.Element{epr.Element(name, space)}, nil
+	case v.Kind() == reflect.Struct:
+		inner := space
+		if r, ok := v.Interface().(resourcer); ok {
+			inner = r.ResourceURI()
+		} else if v.CanAddr() {
+			if r, ok := v.Addr().Interface().(resourcer); ok {
+				inner = r.ResourceURI()
+			}
+		}
+		e := dom.Elem(name, space)
+		if err := marshalFields(e, inner, v); err != nil {
+			return nil, err
+		}
+		return []*dom.Element{e}, nil
+	}
+	var content string
+	switch v.Kind() {
+	case reflect.String:
+		content = v.String()
+	case reflect.Bool:
+		content = strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		content = strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		content = strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32:
+		content = strconv.FormatFloat(v.Float(), 'g', -1, 32)
+	case reflect.Float64:
+		content = strconv.FormatFloat(v.Float(), 'g', -1, 64)
+	case reflect.Slice:
+		content = cim.OctetString(v.Bytes()).String()
+	default:
+		return nil, fmt.Errorf("Cannot marshal %s of type %s", name, v.Type())
+	}
+	return []*dom.Element{dom.ElemC(name, space, content)}, nil
+}
+
+func marshalFields(e *dom.Element, space string, v reflect.Value) error {
+	for _, f := range fields(v.Type()) {
+		fv := v.FieldByIndex(f.index)
+		if f.omitEmpty && isEmpty(fv) {
+			continue
+		}
+		elems, err := marshalValue(f.name, space, fv)
+		if err != nil {
+			return err
+		}
+		for _, elem := range elems {
+			e.AddChild(elem)
+		}
+	}
+	return nil
+}
+
+// Marshal turns v, which must be a struct or a pointer to one, into an
+// instance of the class resourceURI names.
+//
+// Each exported field is a property named after the field, which can be
+// changed with a struct tag:
+//
+//	type BIOSEnumeration struct {
+//		InstanceID     string
+//		CurrentValue   []string   `wsman:"CurrentValue"`
+//		PendingValue   *string    `wsman:",omitempty"`
+//		LastChanged    time.Time  `wsman:"LastChanged,omitempty"`
+//		Owner          *wsman.EndpointReference
+//		Internal       string     `wsman:"-"`
+//	}
+//
+// Slices become repeated properties, nil pointers become xsi:nil
+// properties unless they are omitempty, time.Time and time.Duration
+// are sent as cim:Datetime and cim:Interval, []byte is sent as
+// xs:hexBinary like cim.OctetString, EndpointReferences are sent as
+// references, and struct fields are sent as embedded instances.  An
+// embedded instance whose type has a ResourceURI()
+// string method has its properties put in that namespace.  Anonymous
+// struct fields have their properties added to the outer instance,
+// which is handy for modelling class inheritance.
+func Marshal(resourceURI string, v interface{}) (*dom.Element, error) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("Cannot marshal %T, it is not a struct", v)
+	}
+	res := dom.Elem(className(resourceURI), resourceURI)
+	if err := marshalFields(res, resourceURI, rv); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func isNilElem(e *dom.Element) bool {
+	for _, attr := range e.Attributes {
+		if attr.Name.Local == "nil" && attr.Name.Space == NS_XSI {
+			return attr.Value == "true"
+		}
+	}
+	return false
+}
+
+// unmarshalValue sets v from a single property element.
+func unmarshalValue(e *dom.Element, v reflect.Value) error {
+	if v.Kind() == reflect.Ptr {
+		if isNilElem(e) {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return unmarshalValue(e, v.Elem())
+	}
+	if isNilElem(e) {
+		return nil
+	}
+	content := strings.TrimSpace(string(e.Content))
+	switch v.Type() {
+	case timeType, durationType:
+		// The value is in a cim:Datetime, cim:Interval, cim:Date, or
+		// cim:Time, but take it bare as well.
+		if children := e.Children(); len(children) > 0 {
+			content = strings.TrimSpace(string(children[0].Content))
+		}
+		if v.Type() == durationType {
+			d, err := cim.ParseInterval(content)
+			if err != nil {
+				return err
+			}
+			v.SetInt(int64(d))
+			return nil
+		}
+		t, err := cim.ParseTime(content)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	case eprType:
+		epr, err := ParseEPR(e)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(*epr))
+		return nil
+	}
+	var err error
+	switch v.Kind() {
+	case reflect.Struct:
+		return unmarshalFields(e, v)
+	case reflect.String:
+		v.SetString(content)
+	case reflect.Bool:
+		var b bool
+		if b, err = strconv.ParseBool(content); err == nil {
+			v.SetBool(b)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		if n, err = strconv.ParseInt(content, 10, v.Type().Bits()); err == nil {
+			v.SetInt(n)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		if n, err = strconv.ParseUint(content, 10, v.Type().Bits()); err == nil {
+			v.SetUint(n)
+		}
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		if f, err = strconv.ParseFloat(content, v.Type().Bits()); err == nil {
+			v.SetFloat(f)
+		}
+	case reflect.Slice:
+		var b cim.Value
+		if b, err = cim.Parse("octetstring", content); err == nil {
+			v.SetBytes(b.(cim.OctetString))
+		}
+	default:
+		return fmt.Errorf("Cannot unmarshal %s into %s", e.Name.Local, v.Type())
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot unmarshal %s: %v", e.Name.Local, err)
+	}
+	return nil
+}
+
+func unmarshalFields(e *dom.Element, v reflect.Value) error {
+	props := map[string][]*dom.Element{}
+	for _, child := range e.Children() {
+		props[child.Name.Local] = append(props[child.Name.Local], child)
+	}
+	for _, f := range fields(v.Type()) {
+		elems := props[f.name]
+		if len(elems) == 0 {
+			continue
+		}
+		fv := v.FieldByIndex(f.index)
+		if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+			slice := reflect.MakeSlice(fv.Type(), len(elems), len(elems))
+			for i, elem := range elems {
+				if err := unmarshalValue(elem, slice.Index(i)); err != nil {
+					return err
+				}
+			}
+			fv.Set(slice)
+			continue
+		}
+		if err := unmarshalValue(elems[0], fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Unmarshal sets the fields of v, which must be a pointer to a struct,
+// from the properties of the CIM instance e.  Properties are matched
+// by local name, and properties v has no field for are ignored.
+func Unmarshal(e *dom.Element, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Cannot unmarshal into %T, it is not a pointer to a struct", v)
+	}
+	return unmarshalFields(e, rv.Elem())
+}
+
+// Marshal sets the body of the message to v, marshaled as an instance
+// of the message's ResourceURI.  Use it to build Put and Create
+// requests.
+func (m *Message) Marshal(v interface{}) error {
+	e, err := Marshal(m.GetResource(), v)
+	if err != nil {
+		return err
+	}
+	m.SetBody(e)
+	return nil
+}
+
+// Unmarshal sets v from the first element of the body of the message,
+// which is the instance in replies to Get, Put, and Create.
+func (m *Message) Unmarshal(v interface{}) error {
+	body := m.Body()
+	if len(body) == 0 {
+		return fmt.Errorf("Message has no body to unmarshal")
+	}
+	return Unmarshal(body[0], v)
+}
+
+// MarshalParameters adds the fields of v, a struct like Marshal takes,
+// as the parameters of an Invoke message.  A nil v adds an empty
+// Method_INPUT element, for methods that take no parameters.
+func (m *Message) MarshalParameters(v interface{}) error {
+	resourceNS, resourceName := m.paramNamespace()
+	rv := reflect.ValueOf(v)
+	if v == nil || (rv.Kind() == reflect.Ptr && rv.IsNil()) {
+		m.AddParameter()
+		return nil
+	}
+	rv = reflect.Indirect(rv)
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("Cannot marshal %T, it is not a struct", v)
+	}
+	params := dom.Elem(resourceName, resourceNS)
+	if err := marshalFields(params, resourceNS, rv); err != nil {
+		return err
+	}
+	m.AddParameter(params.Children()...)
+	return nil
+}
+
+// UnmarshalOutput sets v from the Method_OUTPUT element of a reply to
+// an Invoke message.
+func (m *Message) UnmarshalOutput(v interface{}) error {
+	out, _, err := m.InvokeResponse()
+	if out == nil {
+		return err
+	}
+	return Unmarshal(out, v)
+}
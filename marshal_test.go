@@ -0,0 +1,128 @@
+package wsman_test
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/wsman"
+)
+
+type fanSettings struct {
+	DeviceID    string
+	ElementName string            `wsman:",omitempty"`
+	Speeds      []uint16          `wsman:"Speed,omitempty"`
+	Pending     *string           `wsman:",omitempty"`
+	Cleared     *string           `wsman:"Cleared"`
+	LastChanged time.Time         `wsman:",omitempty"`
+	Labels      map[string]string `wsman:",omitempty"`
+	Firmware    []byte            `wsman:",omitempty"`
+	Slots       [0]int            `wsman:",omitempty"`
+	OnChange    func()            `wsman:",omitempty"`
+	Internal    string            `wsman:"-"`
+}
+
+func TestMarshalOmitEmpty(t *testing.T) {
+	e, err := wsman.Marshal(fanURI, &fanSettings{DeviceID: "Fan.1", Internal: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Name.Local != "CIM_Fan" || e.Name.Space != fanURI {
+		t.Errorf("Marshalled as %s in %s", e.Name.Local, e.Name.Space)
+	}
+	names := []string{}
+	for _, child := range e.Children() {
+		names = append(names, child.Name.Local)
+	}
+	if want := []string{"DeviceID", "Cleared"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Marshalled %v, wanted %v", names, want)
+	}
+
+	// Maps are not empty once they have something in them, but there
+	// is no property they can be sent as either.
+	_, err = wsman.Marshal(fanURI, &fanSettings{DeviceID: "Fan.1", Labels: map[string]string{"a": "b"}})
+	if err == nil {
+		t.Error("Marshalling a map should fail")
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	pending := "Fan 2"
+	in := &fanSettings{
+		DeviceID:    "Fan.1",
+		ElementName: "Fan 1",
+		Speeds:      []uint16{1000, 2000},
+		Pending:     &pending,
+		LastChanged: time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC),
+		Firmware:    []byte{0x00, 0x1a, 0xff, '<'},
+	}
+	e, err := wsman.Marshal(fanURI, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &fanSettings{}
+	if err := wsman.Unmarshal(e, out); err != nil {
+		t.Fatal(err)
+	}
+	if !out.LastChanged.Equal(in.LastChanged) {
+		t.Errorf("LastChanged came back as %v", out.LastChanged)
+	}
+	out.LastChanged = in.LastChanged
+	if !reflect.DeepEqual(out, in) {
+		t.Errorf("Round trip got %+v, wanted %+v", out, in)
+	}
+}
+
+func TestMarshalBytes(t *testing.T) {
+	e, err := wsman.Marshal(fanURI, &fanSettings{DeviceID: "Fan.1", Firmware: []byte{0x00, 0x1a, 0xff}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, child := range e.Children() {
+		if child.Name.Local == "Firmware" && string(child.Content) != "001AFF" {
+			t.Errorf("Firmware was sent as %q, wanted hex", child.Content)
+		}
+	}
+
+	for _, test := range []struct {
+		content string
+		want    []byte
+	}{
+		{"001AFF", []byte{0x00, 0x1a, 0xff}},
+		{"001aff", []byte{0x00, 0x1a, 0xff}},
+		{" 0x001AFF\n", []byte{0x00, 0x1a, 0xff}},
+	} {
+		in := dom.Elem("CIM_Fan", fanURI)
+		in.AddChild(dom.ElemC("Firmware", fanURI, test.content))
+		out := &fanSettings{}
+		if err := wsman.Unmarshal(in, out); err != nil {
+			t.Errorf("%q: %v", test.content, err)
+		} else if !reflect.DeepEqual(out.Firmware, test.want) {
+			t.Errorf("%q came back as %x", test.content, out.Firmware)
+		}
+	}
+
+	in := dom.Elem("CIM_Fan", fanURI)
+	in.AddChild(dom.ElemC("Firmware", fanURI, "not hex"))
+	if err := wsman.Unmarshal(in, &fanSettings{}); err == nil || !strings.Contains(err.Error(), "Firmware") {
+		t.Errorf("Got %v, wanted an error about Firmware", err)
+	}
+}
@@ -17,13 +17,15 @@ limitations under the License.
 */
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/VictorLowther/simplexml/dom"
 	"github.com/VictorLowther/simplexml/search"
 	"github.com/VictorLowther/soap"
+	"github.com/VictorLowther/wsman/cim"
 	uuid "github.com/satori/go.uuid"
 )
 
@@ -35,6 +37,11 @@ type Message struct {
 	// For now, this is used to allow Enumerate to Pull additional
 	// replys without having to make API users do it.
 	replyHelper func(*Message, *Message) error
+	// Tuned lists what Send had to change about a request to get a
+	// reply that fit in an envelope, such as a smaller MaxElements.
+	// It is set on both the request and the reply.
+	Tuned []string
+	ctx   context.Context
 }
 
 // Resource turns a resource URI into an appropriate DOM element
@@ -61,6 +68,13 @@ func (c *Client) NewMessage(action string) (msg *Message) {
 	return msg
 }
 
+// Context makes Send give up on the message, and on any retries of
+// it, when ctx is done.
+func (m *Message) Context(ctx context.Context) *Message {
+	m.ctx = ctx
+	return m
+}
+
 // Options are used to modify how certian WSMAN operations work.
 // For now, the only thing we use it for is to make EnumerateEPR work.
 // See http://www.dmtf.org/sites/default/files/standards/documents/DSP0226_1.2.0.pdf,
@@ -252,6 +266,53 @@ func (m *Message) Parameters(args ...string) *Message {
 	return m
 }
 
+// ArrayParameter adds an array parameter to an Invoke message, as one
+// element per value.  Methods like Dell's SetAttributes take parallel
+// arrays:
+//
+//	msg.ArrayParameter("AttributeName", "BootMode", "SriovGlobalEnable").
+//	    ArrayParameter("AttributeValue", "Uefi", "Enabled")
+func (m *Message) ArrayParameter(name string, values ...string) *Message {
+	arr := make(cim.Array, len(values))
+	for i, val := range values {
+		arr[i] = cim.String(val)
+	}
+	return m.TypedParameter(name, arr)
+}
+
+// EPRParameter adds a parameter that refers to the instance epr refers
+// to, such as the Target or ManagedElement of many methods.  A nil epr
+// is sent as xsi:nil.
+func (m *Message) EPRParameter(name string, epr *EndpointReference) *Message {
+	if epr == nil {
+		return m.TypedParameter(name, cim.Null("ref"))
+	}
+	return m.TypedParameter(name, cim.Ref{Reference: epr})
+}
+
+// instancePrefix is the namespace prefix InstanceParameter binds to the
+// class of the instance, for its xsi:type.
+const instancePrefix = "inst"
+
+// InstanceParameter adds an embedded instance parameter, with v
+// marshaled as an instance of resourceURI the same way Marshal does.
+// The parameter gets an xsi:type of the class, which endpoints need to
+// tell what kind of instance it is.  It panics if v cannot be
+// marshaled, since that is a bug in the caller.
+func (m *Message) InstanceParameter(name, resourceURI string, v interface{}) *Message {
+	instance, err := Marshal(resourceURI, v)
+	if err != nil {
+		panic(err.Error())
+	}
+	param := m.MakeParameter(name).
+		Attr(instancePrefix, "xmlns", resourceURI).
+		Attr("type", NS_XSI, instancePrefix+":"+className(resourceURI)+"_Type")
+	for _, prop := range instance.Children() {
+		param.AddChild(prop)
+	}
+	return m.AddParameter(param)
+}
+
 func (m *Message) GetResource() string {
 	hdr := search.First(search.Tag("ResourceURI", NS_WSMAN), m.AllHeaderElements())
 	if hdr == nil {
@@ -295,20 +356,82 @@ func (m *Message) Values(args ...string) *Message {
 	return m
 }
 
+// OperationTimeout sets the OperationTimeout header of the message,
+// which tells the endpoint how long it may take to reply.
+func (m *Message) OperationTimeout(d time.Duration) *Message {
+	timeout := dom.Elem("OperationTimeout", NS_WSMAN)
+	if found := m.GetHeader(timeout); found != nil {
+		timeout = found
+	} else {
+		m.SetHeader(timeout)
+	}
+	timeout.Content = []byte(fmt.Sprintf("PT%.3fS", d.Seconds()))
+	return m
+}
+
+func (m *Message) faultPart(path ...string) *dom.Element {
+	part := search.First(search.Tag("Fault", NS_SOAP), m.AllBodyElements())
+	for _, name := range path {
+		if part == nil {
+			break
+		}
+		part = search.First(search.Tag(name, NS_SOAP), part.Children())
+	}
+	return part
+}
+
+// FaultSubcode returns the local part of the subcode of the SOAP fault
+// the message contains, such as "TimedOut" or "InvalidSelectors".
+// It returns an empty string if the message is not a fault or the fault
+// has no subcode.
+func (m *Message) FaultSubcode() string {
+	val := m.faultPart("Code", "Subcode", "Value")
+	if val == nil {
+		return ""
+	}
+	code := strings.TrimSpace(string(val.Content))
+	return code[strings.LastIndex(code, ":")+1:]
+}
+
+// FaultReason returns the human readable reason for the SOAP fault the
+// message contains, or an empty string if the message is not a fault.
+func (m *Message) FaultReason() string {
+	text := m.faultPart("Reason", "Text")
+	if text == nil {
+		return ""
+	}
+	return strings.TrimSpace(string(text.Content))
+}
+
 // Send sends a message to the endpoint of the Client it was
 // constructed with, and returns either the Message that was
 // returned, or an error statung what went wrong.
+//
+// If the reply would not fit in an envelope, Send shrinks the
+// MaxElements of enumerations or asks for a bigger MaxEnvelopeSize, up
+// to the Client's MaxEnvelopeSize, and tries again.  Transient
+// failures are retried as the Client's Retry policy says.
 func (m *Message) Send() (*Message, error) {
-	res, err := m.client.Post(m.Message)
-	if err != nil {
+	res, err := m.post()
+	if res == nil {
 		return nil, err
 	}
 	msg := &Message{Message: res, client: m.client}
-	if m.replyHelper != nil {
-		err = m.replyHelper(m, msg)
+	for msg.Fault() != nil {
+		if !m.tune(msg) {
+			msg.Tuned = m.Tuned
+			return msg, fmt.Errorf("SOAP Fault: %s %s", msg.FaultSubcode(), msg.FaultReason())
+		}
+		if res, err = m.post(); res == nil {
+			return nil, err
+		}
+		msg = &Message{Message: res, client: m.client}
 	}
-	if msg.Fault() != nil {
-		return msg, errors.New("SOAP Fault")
+	msg.Tuned = m.Tuned
+	if m.replyHelper != nil {
+		if err = m.replyHelper(m, msg); err != nil {
+			return msg, err
+		}
 	}
 	return msg, nil
 }
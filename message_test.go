@@ -0,0 +1,79 @@
+package wsman_test
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/search"
+	"github.com/VictorLowther/wsman"
+	"github.com/VictorLowther/wsman/cim"
+)
+
+func attr(e *dom.Element, name, space string) (string, bool) {
+	for _, a := range e.Attributes {
+		if a.Name.Local == name && a.Name.Space == space {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+func parameter(t *testing.T, msg *wsman.Message, name string) *dom.Element {
+	param := search.First(search.Tag(name, fanURI), msg.AllBodyElements())
+	if param == nil {
+		t.Fatalf("No %s parameter in %s", name, msg.String())
+	}
+	return param
+}
+
+func TestInstanceParameter(t *testing.T) {
+	client := wsman.NewClient("http://127.0.0.1:1/wsman", "", "", false)
+	msg := client.Invoke(fanURI, "SetSettings").
+		InstanceParameter("Settings", fanURI, &fanSettings{DeviceID: "Fan.1"})
+	param := parameter(t, msg, "Settings")
+	typ, ok := attr(param, "type", wsman.NS_XSI)
+	if !ok {
+		t.Fatal("Instance parameter has no xsi:type")
+	}
+	parts := strings.SplitN(typ, ":", 2)
+	if len(parts) != 2 || parts[1] != "CIM_Fan_Type" {
+		t.Fatalf("Instance parameter has xsi:type %q", typ)
+	}
+	prefix := parts[0]
+	if ns, _ := attr(param, prefix, "xmlns"); ns != fanURI {
+		t.Errorf("Prefix %s of the xsi:type is bound to %q, wanted the class namespace", prefix, ns)
+	}
+	if id := search.First(search.Tag("DeviceID", fanURI), param.Children()); id == nil {
+		t.Error("Instance properties are missing")
+	}
+}
+
+func TestNilEPRParameter(t *testing.T) {
+	client := wsman.NewClient("http://127.0.0.1:1/wsman", "", "", false)
+	msg := client.Invoke(fanURI, "Reset").EPRParameter("Target", nil)
+	if isNil, _ := attr(parameter(t, msg, "Target"), "nil", wsman.NS_XSI); isNil != "true" {
+		t.Error("A nil EPR should be sent as xsi:nil")
+	}
+
+	e := cim.Encode(dom.Elem("Target", fanURI), cim.Ref{})
+	if isNil, _ := attr(e, "nil", cim.NS_XSI); isNil != "true" {
+		t.Error("A Ref with no Reference should be sent as xsi:nil")
+	}
+}
@@ -27,4 +27,8 @@ const (
 	NS_WSMEN = "http://schemas.xmlsoap.org/ws/2004/09/enumeration"
 	NS_WSMT  = "http://schemas.xmlsoap.org/ws/2004/09/transfer"
 	NS_WSP   = "http://schemas.xmlsoap.org/ws/2004/09/policy"
+	NS_SOAP  = "http://www.w3.org/2003/05/soap-envelope"
+	NS_SHELL = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell"
+	NS_XSI   = "http://www.w3.org/2001/XMLSchema-instance"
+	NS_CIM   = "http://schemas.dmtf.org/wbem/wscim/1/common"
 )
@@ -0,0 +1,394 @@
+package psrp
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Object is a deserialized PowerShell object that has no direct Go
+// equivalent.  See MS-PSRP section 2.2.5.2.
+type Object struct {
+	// TypeNames has the .NET type of the object, followed by the
+	// types it inherits from.
+	TypeNames []string
+	ToString  string
+	// Value is set for objects that wrap a primitive value,
+	// such as enums.
+	Value interface{}
+	// Properties has both the adapted and extended properties of the
+	// object.
+	Properties map[string]interface{}
+	// List has the items of lists, stacks, and queues.
+	List []interface{}
+	// Dict has the entries of dictionaries, keyed by the string form
+	// of their keys.
+	Dict map[string]interface{}
+}
+
+// String returns the ToString of the object.
+func (o *Object) String() string {
+	return o.ToString
+}
+
+type node struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Text    string     `xml:",chardata"`
+	Nodes   []node     `xml:",any"`
+}
+
+func (n *node) attr(name string) string {
+	for _, attr := range n.Attrs {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+type deserializer struct {
+	refs      map[string]*Object
+	typeNames map[string][]string
+}
+
+var escaped = regexp.MustCompile(`_x[0-9A-Fa-f]{4}_`)
+
+// decodeString undoes the _xHHHH_ escaping CLIXML uses for characters
+// that cannot appear in XML.
+func decodeString(s string) string {
+	if !strings.Contains(s, "_x") {
+		return s
+	}
+	units := []uint16{}
+	for {
+		loc := escaped.FindStringIndex(s)
+		if loc == nil {
+			break
+		}
+		units = append(units, utf16.Encode([]rune(s[:loc[0]]))...)
+		unit, _ := strconv.ParseUint(s[loc[0]+2:loc[1]-1], 16, 16)
+		units = append(units, uint16(unit))
+		s = s[loc[1]:]
+	}
+	units = append(units, utf16.Encode([]rune(s))...)
+	return string(utf16.Decode(units))
+}
+
+// encodeString escapes s for inclusion in CLIXML.
+func encodeString(s string) string {
+	buf := &bytes.Buffer{}
+	for i, r := range s {
+		switch {
+		case r == '_' && strings.HasPrefix(s[i+1:], "x"):
+			buf.WriteString("_x005F_")
+		case r < 0x20:
+			fmt.Fprintf(buf, "_x%04X_", r)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	res := &bytes.Buffer{}
+	xml.EscapeText(res, buf.Bytes())
+	return res.String()
+}
+
+// parseDuration parses the subset of xs:duration PowerShell uses for
+// TimeSpans.
+func parseDuration(s string) (time.Duration, error) {
+	orig := s
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	if !strings.HasPrefix(s, "P") {
+		return 0, fmt.Errorf("Bad duration %s", orig)
+	}
+	s = s[1:]
+	res := time.Duration(0)
+	inTime := false
+	for len(s) > 0 {
+		if s[0] == 'T' {
+			inTime = true
+			s = s[1:]
+			continue
+		}
+		idx := strings.IndexAny(s, "YMDHS")
+		if idx < 1 {
+			return 0, fmt.Errorf("Bad duration %s", orig)
+		}
+		val, err := strconv.ParseFloat(s[:idx], 64)
+		if err != nil {
+			return 0, fmt.Errorf("Bad duration %s", orig)
+		}
+		var unit time.Duration
+		switch {
+		case s[idx] == 'D':
+			unit = 24 * time.Hour
+		case s[idx] == 'H':
+			unit = time.Hour
+		case s[idx] == 'M' && inTime:
+			unit = time.Minute
+		case s[idx] == 'S':
+			unit = time.Second
+		default:
+			return 0, fmt.Errorf("Duration %s has years or months", orig)
+		}
+		res += time.Duration(val * float64(unit))
+		s = s[idx+1:]
+	}
+	if neg {
+		res = -res
+	}
+	return res, nil
+}
+
+func (d *deserializer) primitive(n *node) (interface{}, error) {
+	text := n.Text
+	switch n.XMLName.Local {
+	case "S", "URI", "XD", "SBK", "Version", "G", "D", "SS":
+		return decodeString(text), nil
+	case "C":
+		c, err := strconv.ParseUint(text, 10, 16)
+		return rune(c), err
+	case "B":
+		return strconv.ParseBool(text)
+	case "DT":
+		return time.Parse(time.RFC3339Nano, text)
+	case "TS":
+		return parseDuration(text)
+	case "By":
+		v, err := strconv.ParseUint(text, 10, 8)
+		return uint8(v), err
+	case "SB":
+		v, err := strconv.ParseInt(text, 10, 8)
+		return int8(v), err
+	case "U16":
+		v, err := strconv.ParseUint(text, 10, 16)
+		return uint16(v), err
+	case "I16":
+		v, err := strconv.ParseInt(text, 10, 16)
+		return int16(v), err
+	case "U32":
+		v, err := strconv.ParseUint(text, 10, 32)
+		return uint32(v), err
+	case "I32":
+		v, err := strconv.ParseInt(text, 10, 32)
+		return int32(v), err
+	case "U64":
+		return strconv.ParseUint(text, 10, 64)
+	case "I64":
+		return strconv.ParseInt(text, 10, 64)
+	case "Sg":
+		v, err := strconv.ParseFloat(text, 32)
+		return float32(v), err
+	case "Db":
+		return strconv.ParseFloat(text, 64)
+	case "BA":
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+	case "Nil":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("Unknown CLIXML element %s", n.XMLName.Local)
+}
+
+func (d *deserializer) decode(n *node) (interface{}, error) {
+	switch n.XMLName.Local {
+	case "Obj":
+		return d.object(n)
+	case "Ref":
+		obj, ok := d.refs[n.attr("RefId")]
+		if !ok {
+			return nil, fmt.Errorf("Reference to unknown object %s", n.attr("RefId"))
+		}
+		return obj, nil
+	}
+	return d.primitive(n)
+}
+
+func (d *deserializer) members(n *node, obj *Object) error {
+	for i := range n.Nodes {
+		val, err := d.decode(&n.Nodes[i])
+		if err != nil {
+			return err
+		}
+		obj.Properties[decodeString(n.Nodes[i].attr("N"))] = val
+	}
+	return nil
+}
+
+func (d *deserializer) object(n *node) (*Object, error) {
+	obj := &Object{Properties: map[string]interface{}{}}
+	if id := n.attr("RefId"); id != "" {
+		d.refs[id] = obj
+	}
+	for i := range n.Nodes {
+		child := &n.Nodes[i]
+		switch child.XMLName.Local {
+		case "TN":
+			names := []string{}
+			for _, t := range child.Nodes {
+				names = append(names, decodeString(t.Text))
+			}
+			d.typeNames[child.attr("RefId")] = names
+			obj.TypeNames = names
+		case "TNRef":
+			obj.TypeNames = d.typeNames[child.attr("RefId")]
+		case "ToString":
+			obj.ToString = decodeString(child.Text)
+		case "Props", "MS":
+			if err := d.members(child, obj); err != nil {
+				return nil, err
+			}
+		case "LST", "STK", "QUE", "IE":
+			obj.List = []interface{}{}
+			for j := range child.Nodes {
+				val, err := d.decode(&child.Nodes[j])
+				if err != nil {
+					return nil, err
+				}
+				obj.List = append(obj.List, val)
+			}
+		case "DCT":
+			obj.Dict = map[string]interface{}{}
+			for _, entry := range child.Nodes {
+				var key, val interface{}
+				for j := range entry.Nodes {
+					v, err := d.decode(&entry.Nodes[j])
+					if err != nil {
+						return nil, err
+					}
+					if entry.Nodes[j].attr("N") == "Key" {
+						key = v
+					} else {
+						val = v
+					}
+				}
+				obj.Dict[fmt.Sprint(key)] = val
+			}
+		default:
+			val, err := d.primitive(child)
+			if err != nil {
+				return nil, err
+			}
+			obj.Value = val
+		}
+	}
+	return obj, nil
+}
+
+// Deserialize turns CLIXML into Go values.  Primitive types map to
+// their natural Go equivalents, with decimals, GUIDs, and versions
+// left as strings, DateTimes as time.Time, and TimeSpans as
+// time.Duration.  Everything else becomes an *Object.
+func Deserialize(clixml []byte) (interface{}, error) {
+	n := &node{}
+	if err := xml.Unmarshal(bytes.TrimPrefix(clixml, bom), n); err != nil {
+		return nil, err
+	}
+	d := &deserializer{refs: map[string]*Object{}, typeNames: map[string][]string{}}
+	return d.decode(n)
+}
+
+// deserializeObject is Deserialize for messages whose payload must be
+// an object.
+func deserializeObject(clixml []byte) (*Object, error) {
+	val, err := Deserialize(clixml)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := val.(*Object)
+	if !ok {
+		return nil, fmt.Errorf("Expected an object, got %T", val)
+	}
+	return obj, nil
+}
+
+const hostInfo = `<Obj N="HostInfo" RefId="%d"><MS>` +
+	`<B N="_isHostNull">true</B><B N="_isHostUINull">true</B>` +
+	`<B N="_isHostRawUINull">true</B><B N="_useRunspaceHost">true</B>` +
+	`</MS></Obj>`
+
+const enumTypes = `<T>System.Enum</T><T>System.ValueType</T><T>System.Object</T>`
+
+const apartmentState = `<Obj N="ApartmentState" RefId="%d"><TN RefId="%d">` +
+	`<T>System.Threading.ApartmentState</T>` + enumTypes + `</TN>` +
+	`<ToString>Unknown</ToString><I32>2</I32></Obj>`
+
+func sessionCapability() []byte {
+	return []byte(`<Obj RefId="0"><MS>` +
+		`<Version N="protocolversion">2.3</Version>` +
+		`<Version N="PSVersion">2.0</Version>` +
+		`<Version N="SerializationVersion">1.1.0.1</Version>` +
+		`</MS></Obj>`)
+}
+
+func initRunspacePool(min, max int) []byte {
+	return []byte(fmt.Sprintf(`<Obj RefId="0"><MS>`+
+		`<I32 N="MinRunspaces">%d</I32><I32 N="MaxRunspaces">%d</I32>`+
+		`<Obj N="PSThreadOptions" RefId="1"><TN RefId="0">`+
+		`<T>System.Management.Automation.Runspaces.PSThreadOptions</T>`+enumTypes+`</TN>`+
+		`<ToString>Default</ToString><I32>0</I32></Obj>`+
+		apartmentState+hostInfo+
+		`<Nil N="ApplicationArguments" />`+
+		`</MS></Obj>`, min, max, 2, 1, 3))
+}
+
+func createPipeline(script string) []byte {
+	merge := func(name string, refID int) string {
+		return fmt.Sprintf(`<Obj N="%s" RefId="%d"><TNRef RefId="3" />`+
+			`<ToString>None</ToString><I32>0</I32></Obj>`, name, refID)
+	}
+	return []byte(fmt.Sprintf(`<Obj RefId="0"><MS>`+
+		`<B N="NoInput">true</B>`+
+		apartmentState+
+		`<Obj N="RemoteStreamOptions" RefId="2"><TN RefId="1">`+
+		`<T>System.Management.Automation.RemoteStreamOptions</T>`+enumTypes+`</TN>`+
+		`<ToString>0</ToString><I32>0</I32></Obj>`+
+		`<B N="AddToHistory">false</B>`+
+		hostInfo+
+		`<Obj N="PowerShell" RefId="4"><MS>`+
+		`<Obj N="Cmds" RefId="5"><TN RefId="2">`+
+		"<T>System.Collections.Generic.List`1[[System.Management.Automation.PSObject, "+
+		`System.Management.Automation, Version=1.0.0.0, Culture=neutral, `+
+		`PublicKeyToken=31bf3856ad364e35]]</T><T>System.Object</T></TN>`+
+		`<LST><Obj RefId="6"><MS>`+
+		`<S N="Cmd">%s</S><B N="IsScript">true</B><Nil N="UseLocalScope" />`+
+		`<Obj N="MergeMyResult" RefId="7"><TN RefId="3">`+
+		`<T>System.Management.Automation.Runspaces.PipelineResultTypes</T>`+enumTypes+`</TN>`+
+		`<ToString>None</ToString><I32>0</I32></Obj>`+
+		merge("MergeToResult", 8)+
+		merge("MergePreviousResults", 9)+
+		merge("MergeError", 10)+
+		merge("MergeWarning", 11)+
+		merge("MergeVerbose", 12)+
+		merge("MergeDebug", 13)+
+		merge("MergeInformation", 14)+
+		`<Obj N="Args" RefId="15"><TNRef RefId="2" /><LST /></Obj>`+
+		`</MS></Obj></LST></Obj>`+
+		`<B N="IsNested">false</B><Nil N="History" />`+
+		`<B N="RedirectShellErrorOutputPipe">true</B>`+
+		`</MS></Obj>`+
+		`<B N="IsNested">false</B>`+
+		`</MS></Obj>`, 1, 0, 3, encodeString(script)))
+}
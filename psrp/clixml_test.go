@@ -0,0 +1,232 @@
+package psrp
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStrings(t *testing.T) {
+	for _, test := range []struct {
+		plain, encoded string
+	}{
+		{"plain", "plain"},
+		{"tab\there", "tab_x0009_here"},
+		{"_x0041_", "_x005F_x0041_"},
+		{"a < b & c", "a &lt; b &amp; c"},
+	} {
+		if got := encodeString(test.plain); got != test.encoded {
+			t.Errorf("encodeString(%q) is %q, wanted %q", test.plain, got, test.encoded)
+		}
+	}
+	for _, test := range []struct {
+		encoded, plain string
+	}{
+		{"tab_x0009_here", "tab\there"},
+		{"_x005F_x0041_", "_x0041_"},
+		// Surrogate pairs are escaped one half at a time.
+		{"_xD83D__xDE00_", "\U0001F600"},
+	} {
+		if got := decodeString(test.encoded); got != test.plain {
+			t.Errorf("decodeString(%q) is %q, wanted %q", test.encoded, got, test.plain)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want time.Duration
+		ok   bool
+	}{
+		{"PT9.0269026S", 9026902600 * time.Nanosecond, true},
+		{"P1DT2H3M4S", 26*time.Hour + 3*time.Minute + 4*time.Second, true},
+		{"-PT1M", -time.Minute, true},
+		{"PT0S", 0, true},
+		{"P1M", 0, false},
+		{"P1Y", 0, false},
+		{"T1S", 0, false},
+		{"PTxS", 0, false},
+	} {
+		got, err := parseDuration(test.in)
+		if test.ok && (err != nil || got != test.want) {
+			t.Errorf("parseDuration(%q) is %v, %v, wanted %v", test.in, got, err, test.want)
+		} else if !test.ok && err == nil {
+			t.Errorf("parseDuration(%q) should have failed", test.in)
+		}
+	}
+}
+
+func TestDeserializePrimitives(t *testing.T) {
+	for _, test := range []struct {
+		clixml string
+		want   interface{}
+	}{
+		{`<S>a_x000A_b</S>`, "a\nb"},
+		{`<B>true</B>`, true},
+		{`<C>65</C>`, 'A'},
+		{`<By>255</By>`, uint8(255)},
+		{`<SB>-1</SB>`, int8(-1)},
+		{`<I16>-2</I16>`, int16(-2)},
+		{`<U16>2</U16>`, uint16(2)},
+		{`<I32>-3</I32>`, int32(-3)},
+		{`<U32>3</U32>`, uint32(3)},
+		{`<I64>-4</I64>`, int64(-4)},
+		{`<U64>4</U64>`, uint64(4)},
+		{`<Sg>1.5</Sg>`, float32(1.5)},
+		{`<Db>2.5</Db>`, 2.5},
+		{`<D>1.10</D>`, "1.10"},
+		{`<G>792e5b37-4505-47ef-b7d2-8711bb7affa8</G>`, "792e5b37-4505-47ef-b7d2-8711bb7affa8"},
+		{`<Version>6.2.9200.0</Version>`, "6.2.9200.0"},
+		{`<DT>2008-04-11T10:42:32.2731993-07:00</DT>`,
+			time.Date(2008, 4, 11, 10, 42, 32, 273199300, time.FixedZone("", -7*60*60))},
+		{`<TS>PT9.0269026S</TS>`, 9026902600 * time.Nanosecond},
+		{`<BA>AQID</BA>`, []byte{1, 2, 3}},
+		{`<Nil />`, nil},
+	} {
+		got, err := Deserialize([]byte(test.clixml))
+		if err != nil {
+			t.Errorf("%s: %v", test.clixml, err)
+			continue
+		}
+		if want, ok := test.want.(time.Time); ok {
+			if got, ok := got.(time.Time); !ok || !got.Equal(want) {
+				t.Errorf("%s: got %v, wanted %v", test.clixml, got, want)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %#v, wanted %#v", test.clixml, got, test.want)
+		}
+	}
+	for _, bad := range []string{`<I32>x</I32>`, `<Unknown>1</Unknown>`, `<Ref RefId="9" />`, `<S>`} {
+		if _, err := Deserialize([]byte(bad)); err == nil {
+			t.Errorf("%s should not deserialize", bad)
+		}
+	}
+}
+
+// pipelineState is a PIPELINE_STATE message from MS-PSRP section
+// 3.1.5.4.10, with an error record added.
+const pipelineState = `<Obj RefId="0"><MS>` +
+	`<I32 N="PipelineState">5</I32>` +
+	`<Obj N="ExceptionAsErrorRecord" RefId="1"><TN RefId="0">` +
+	`<T>System.Management.Automation.ErrorRecord</T><T>System.Object</T></TN>` +
+	`<ToString>Access is denied.</ToString>` +
+	`<Props><S N="FullyQualifiedErrorId">AccessDenied</S></Props>` +
+	`</Obj></MS></Obj>`
+
+const outputList = `<Obj RefId="0"><TN RefId="0">` +
+	`<T>System.Collections.ArrayList</T><T>System.Object</T></TN>` +
+	`<LST><I32>1</I32><S>two</S>` +
+	`<Obj RefId="1"><TN RefId="1"><T>System.IO.FileAttributes</T>` + enumTypes + `</TN>` +
+	`<ToString>Directory</ToString><I32>16</I32></Obj>` +
+	`<Obj RefId="2"><TNRef RefId="1" /><ToString>Archive</ToString><I32>32</I32></Obj>` +
+	`<Ref RefId="1" />` +
+	`</LST></Obj>`
+
+const hashtable = `<Obj RefId="0"><TN RefId="0"><T>System.Collections.Hashtable</T><T>System.Object</T></TN>` +
+	`<DCT>` +
+	`<En><S N="Key">Name</S><S N="Value">fan</S></En>` +
+	`<En><I32 N="Key">2</I32><Nil N="Value" /></En>` +
+	`</DCT></Obj>`
+
+func TestDeserializeObjects(t *testing.T) {
+	state, err := deserializeObject([]byte(pipelineState))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if intProperty(state, "PipelineState") != PipelineFailed {
+		t.Errorf("PipelineState is %v", state.Properties["PipelineState"])
+	}
+	if intProperty(state, "Missing") != -1 {
+		t.Error("A missing property should be -1")
+	}
+	rec, ok := state.Properties["ExceptionAsErrorRecord"].(*Object)
+	if !ok {
+		t.Fatalf("ExceptionAsErrorRecord is %T", state.Properties["ExceptionAsErrorRecord"])
+	}
+	if rec.String() != "Access is denied." || rec.TypeNames[0] != "System.Management.Automation.ErrorRecord" {
+		t.Errorf("Error record is %+v", rec)
+	}
+	if rec.Properties["FullyQualifiedErrorId"] != "AccessDenied" {
+		t.Errorf("Props were not decoded: %v", rec.Properties)
+	}
+
+	list, err := deserializeObject([]byte(outputList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.List) != 5 || list.List[0] != int32(1) || list.List[1] != "two" {
+		t.Fatalf("List is %v", list.List)
+	}
+	dir := list.List[2].(*Object)
+	archive := list.List[3].(*Object)
+	if dir.Value != int32(16) || dir.String() != "Directory" {
+		t.Errorf("Enum is %+v", dir)
+	}
+	// TNRef reuses the type names, and Ref the object itself.
+	if !reflect.DeepEqual(archive.TypeNames, dir.TypeNames) {
+		t.Errorf("TNRef got type names %v, wanted %v", archive.TypeNames, dir.TypeNames)
+	}
+	if list.List[4] != dir {
+		t.Error("Ref did not return the object it refers to")
+	}
+
+	table, err := deserializeObject([]byte(hashtable))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"Name": "fan", "2": nil}
+	if !reflect.DeepEqual(table.Dict, want) {
+		t.Errorf("Dict is %v, wanted %v", table.Dict, want)
+	}
+
+	if _, err := deserializeObject([]byte(`<S>x</S>`)); err == nil {
+		t.Error("A string is not an object")
+	}
+}
+
+func TestMessagesDeserialize(t *testing.T) {
+	// What we send has to make sense to our own deserializer, at
+	// least.
+	for name, clixml := range map[string][]byte{
+		"sessionCapability": sessionCapability(),
+		"initRunspacePool":  initRunspacePool(1, 2),
+		"createPipeline":    createPipeline("Get-Item 'C:\\' | Out-String\r\n"),
+	} {
+		obj, err := deserializeObject(clixml)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		switch name {
+		case "initRunspacePool":
+			if intProperty(obj, "MinRunspaces") != 1 || intProperty(obj, "MaxRunspaces") != 2 {
+				t.Errorf("Runspaces are %v", obj.Properties)
+			}
+		case "createPipeline":
+			ps := obj.Properties["PowerShell"].(*Object)
+			cmd := ps.Properties["Cmds"].(*Object).List[0].(*Object)
+			if got := cmd.Properties["Cmd"]; got != "Get-Item 'C:\\' | Out-String\r\n" {
+				t.Errorf("Script came back as %q", got)
+			}
+		}
+	}
+}
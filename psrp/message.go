@@ -0,0 +1,199 @@
+package psrp
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// MessageType identifies the kind of a PSRP message.
+// See MS-PSRP section 2.2.1.
+type MessageType uint32
+
+const (
+	SESSION_CAPABILITY         MessageType = 0x00010002
+	INIT_RUNSPACEPOOL          MessageType = 0x00010004
+	PUBLIC_KEY                 MessageType = 0x00010005
+	ENCRYPTED_SESSION_KEY      MessageType = 0x00010006
+	PUBLIC_KEY_REQUEST         MessageType = 0x00010007
+	CONNECT_RUNSPACEPOOL       MessageType = 0x00010008
+	SET_MAX_RUNSPACES          MessageType = 0x00021002
+	SET_MIN_RUNSPACES          MessageType = 0x00021003
+	RUNSPACE_AVAILABILITY      MessageType = 0x00021004
+	RUNSPACEPOOL_STATE         MessageType = 0x00021005
+	CREATE_PIPELINE            MessageType = 0x00021006
+	GET_AVAILABLE_RUNSPACES    MessageType = 0x00021007
+	USER_EVENT                 MessageType = 0x00021008
+	APPLICATION_PRIVATE_DATA   MessageType = 0x00021009
+	GET_COMMAND_METADATA       MessageType = 0x0002100A
+	RUNSPACEPOOL_INIT_DATA     MessageType = 0x0002100B
+	RESET_RUNSPACE_STATE       MessageType = 0x0002100C
+	RUNSPACEPOOL_HOST_CALL     MessageType = 0x00021100
+	RUNSPACEPOOL_HOST_RESPONSE MessageType = 0x00021101
+	PIPELINE_INPUT             MessageType = 0x00041002
+	END_OF_PIPELINE_INPUT      MessageType = 0x00041003
+	PIPELINE_OUTPUT            MessageType = 0x00041004
+	ERROR_RECORD               MessageType = 0x00041005
+	PIPELINE_STATE             MessageType = 0x00041006
+	DEBUG_RECORD               MessageType = 0x00041007
+	VERBOSE_RECORD             MessageType = 0x00041008
+	WARNING_RECORD             MessageType = 0x00041009
+	PROGRESS_RECORD            MessageType = 0x00041010
+	INFORMATION_RECORD         MessageType = 0x00041011
+	PIPELINE_HOST_CALL         MessageType = 0x00041100
+	PIPELINE_HOST_RESPONSE     MessageType = 0x00041101
+)
+
+const (
+	toServer uint32 = 2
+	// fragmentHeader is the size of the fixed part of a fragment.
+	fragmentHeader = 21
+	startFragment  = 0x1
+	endFragment    = 0x2
+)
+
+var bom = []byte{0xef, 0xbb, 0xbf}
+
+// Message is a single PSRP message.
+type Message struct {
+	Destination uint32
+	Type        MessageType
+	// RPID is the ID of the runspace pool the message is for.
+	RPID uuid.UUID
+	// PID is the ID of the pipeline the message is for, or all
+	// zeros for messages about the runspace pool.
+	PID uuid.UUID
+	// Data is the CLIXML payload of the message.
+	Data []byte
+}
+
+// GUIDs go over the wire in Microsoft byte order, where the first
+// three fields are little endian.
+func guidBytes(u uuid.UUID) []byte {
+	b := make([]byte, 16)
+	copy(b, u[:])
+	b[0], b[1], b[2], b[3] = b[3], b[2], b[1], b[0]
+	b[4], b[5] = b[5], b[4]
+	b[6], b[7] = b[7], b[6]
+	return b
+}
+
+func bytesGUID(b []byte) (u uuid.UUID) {
+	copy(u[:], b)
+	copy(u[:], guidBytes(u))
+	return u
+}
+
+// Bytes encodes the message for the wire.
+func (m *Message) Bytes() []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, m.Destination)
+	binary.Write(buf, binary.LittleEndian, uint32(m.Type))
+	buf.Write(guidBytes(m.RPID))
+	buf.Write(guidBytes(m.PID))
+	buf.Write(bom)
+	buf.Write(m.Data)
+	return buf.Bytes()
+}
+
+// ParseMessage decodes a message from its wire format.
+func ParseMessage(b []byte) (*Message, error) {
+	if len(b) < 40 {
+		return nil, fmt.Errorf("PSRP message too short: %d bytes", len(b))
+	}
+	return &Message{
+		Destination: binary.LittleEndian.Uint32(b[0:4]),
+		Type:        MessageType(binary.LittleEndian.Uint32(b[4:8])),
+		RPID:        bytesGUID(b[8:24]),
+		PID:         bytesGUID(b[24:40]),
+		Data:        bytes.TrimPrefix(b[40:], bom),
+	}, nil
+}
+
+// Fragment splits msg into fragments with blobs no larger than max
+// bytes, all tagged with objectID.
+func Fragment(objectID uint64, msg []byte, max int) [][]byte {
+	res := [][]byte{}
+	for id := uint64(0); ; id++ {
+		flags := byte(0)
+		if id == 0 {
+			flags |= startFragment
+		}
+		blob := msg
+		if len(blob) > max {
+			blob = blob[:max]
+		} else {
+			flags |= endFragment
+		}
+		msg = msg[len(blob):]
+		frag := make([]byte, fragmentHeader, fragmentHeader+len(blob))
+		binary.BigEndian.PutUint64(frag[0:8], objectID)
+		binary.BigEndian.PutUint64(frag[8:16], id)
+		frag[16] = flags
+		binary.BigEndian.PutUint32(frag[17:21], uint32(len(blob)))
+		res = append(res, append(frag, blob...))
+		if flags&endFragment != 0 {
+			return res
+		}
+	}
+}
+
+// Defragmenter reassembles messages from the fragments the server
+// sends back.
+type Defragmenter struct {
+	partial map[uint64][]byte
+}
+
+// Add feeds data containing any number of whole fragments to the
+// Defragmenter, and returns the messages they completed.
+func (d *Defragmenter) Add(data []byte) ([]*Message, error) {
+	if d.partial == nil {
+		d.partial = map[uint64][]byte{}
+	}
+	res := []*Message{}
+	for len(data) > 0 {
+		if len(data) < fragmentHeader {
+			return res, fmt.Errorf("PSRP fragment header truncated")
+		}
+		objectID := binary.BigEndian.Uint64(data[0:8])
+		flags := data[16]
+		size := int(binary.BigEndian.Uint32(data[17:21]))
+		if len(data) < fragmentHeader+size {
+			return res, fmt.Errorf("PSRP fragment %d truncated", objectID)
+		}
+		blob := data[fragmentHeader : fragmentHeader+size]
+		data = data[fragmentHeader+size:]
+		if flags&startFragment != 0 {
+			d.partial[objectID] = nil
+		}
+		d.partial[objectID] = append(d.partial[objectID], blob...)
+		if flags&endFragment == 0 {
+			continue
+		}
+		msg, err := ParseMessage(d.partial[objectID])
+		delete(d.partial, objectID)
+		if err != nil {
+			return res, err
+		}
+		res = append(res, msg)
+	}
+	return res, nil
+}
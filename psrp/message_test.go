@@ -0,0 +1,134 @@
+package psrp
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+var testRPID = uuid.UUID{
+	0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07,
+	0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f,
+}
+
+func TestMessageBytes(t *testing.T) {
+	msg := &Message{
+		Destination: toServer,
+		Type:        SESSION_CAPABILITY,
+		RPID:        testRPID,
+		Data:        []byte("<Obj />"),
+	}
+	b := msg.Bytes()
+	want := []byte{
+		0x02, 0x00, 0x00, 0x00,
+		0x02, 0x00, 0x01, 0x00,
+		// The first three fields of the GUID are little endian.
+		0x03, 0x02, 0x01, 0x00, 0x05, 0x04, 0x07, 0x06,
+		0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f,
+	}
+	if !bytes.Equal(b[:24], want) {
+		t.Errorf("Header is % x, wanted % x", b[:24], want)
+	}
+	if !bytes.Equal(b[24:40], make([]byte, 16)) {
+		t.Errorf("PID is % x, wanted all zeros", b[24:40])
+	}
+	if !bytes.Equal(b[40:], append(append([]byte{}, bom...), msg.Data...)) {
+		t.Errorf("Data is %q", b[40:])
+	}
+	got, err := ParseMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, msg) {
+		t.Errorf("Round trip got %+v, wanted %+v", got, msg)
+	}
+	if _, err := ParseMessage(b[:39]); err == nil {
+		t.Error("A truncated message should not parse")
+	}
+}
+
+func TestFragment(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	frags := Fragment(7, data, 30)
+	if len(frags) != 4 {
+		t.Fatalf("Got %d fragments, wanted 4", len(frags))
+	}
+	for i, frag := range frags {
+		want := []byte{0, 0, 0, 0, 0, 0, 0, 7, 0, 0, 0, 0, 0, 0, 0, byte(i)}
+		if !bytes.Equal(frag[:16], want) {
+			t.Errorf("Fragment %d has IDs % x", i, frag[:16])
+		}
+		flags := byte(0)
+		switch i {
+		case 0:
+			flags = startFragment
+		case 3:
+			flags = endFragment
+		}
+		if frag[16] != flags {
+			t.Errorf("Fragment %d has flags %x, wanted %x", i, frag[16], flags)
+		}
+	}
+	if size := frags[3][17:21]; !bytes.Equal(size, []byte{0, 0, 0, 10}) {
+		t.Errorf("Last fragment has size % x, wanted 10", size)
+	}
+	if one := Fragment(1, data, len(data)); len(one) != 1 || one[0][16] != startFragment|endFragment {
+		t.Errorf("A message that fits should be a single fragment")
+	}
+}
+
+func TestDefragmenter(t *testing.T) {
+	first := &Message{Destination: toServer, Type: PIPELINE_OUTPUT, RPID: testRPID, PID: uuid.NewV4(),
+		Data: bytes.Repeat([]byte("a"), 100)}
+	second := &Message{Destination: toServer, Type: PIPELINE_STATE, RPID: testRPID, PID: first.PID,
+		Data: bytes.Repeat([]byte("b"), 50)}
+	a := Fragment(1, first.Bytes(), 40)
+	b := Fragment(2, second.Bytes(), 40)
+
+	// Fragments of different messages can be interleaved, and split
+	// across calls.
+	d := &Defragmenter{}
+	msgs, err := d.Add(append(append([]byte{}, a[0]...), b[0]...))
+	if err != nil || len(msgs) != 0 {
+		t.Fatalf("Got %d messages and %v from partial fragments", len(msgs), err)
+	}
+	rest := []byte{}
+	for _, frag := range append(a[1:], b[1:]...) {
+		rest = append(rest, frag...)
+	}
+	msgs, err = d.Add(rest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("Got %d messages, wanted 2", len(msgs))
+	}
+	if !reflect.DeepEqual(msgs[0], first) || !reflect.DeepEqual(msgs[1], second) {
+		t.Errorf("Reassembled messages do not match what was sent")
+	}
+
+	if _, err := d.Add(a[0][:10]); err == nil {
+		t.Error("A truncated fragment header should fail")
+	}
+	if _, err := d.Add(a[0][:30]); err == nil {
+		t.Error("A truncated fragment should fail")
+	}
+}
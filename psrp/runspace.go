@@ -0,0 +1,259 @@
+// Package psrp implements enough of the PowerShell Remoting Protocol
+// (MS-PSRP) on top of wsman.Shell to run PowerShell scripts on
+// Windows hosts and get their output back as Go values.
+package psrp
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/wsman"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// The resource URI of the default PowerShell remoting endpoint
+	SHELL_URI = "http://schemas.microsoft.com/powershell/Microsoft.PowerShell"
+
+	// Stops a running pipeline
+	SIGNAL_CTRL_C = "http://schemas.microsoft.com/powershell/signal/crtl_c"
+
+	NS_POWERSHELL = "http://schemas.microsoft.com/powershell"
+)
+
+// RunspacePool states, from MS-PSRP section 2.2.3.4
+const (
+	RunspaceBeforeOpen = iota
+	RunspaceOpening
+	RunspaceOpened
+	RunspaceClosed
+	RunspaceClosing
+	RunspaceBroken
+	RunspaceNegotiationSent
+	RunspaceNegotiationSucceeded
+	RunspaceConnecting
+	RunspaceDisconnected
+)
+
+// Pipeline states, from MS-PSRP section 2.2.3.5
+const (
+	PipelineNotStarted = iota
+	PipelineRunning
+	PipelineStopping
+	PipelineStopped
+	PipelineCompleted
+	PipelineFailed
+	PipelineDisconnected
+)
+
+// RunspacePool is an open PowerShell runspace pool on a remote host.
+type RunspacePool struct {
+	ID    uuid.UUID
+	shell *wsman.Shell
+	// MaxFragment is the largest fragment blob we will send.
+	MaxFragment int
+	objectID    uint64
+	defrag      Defragmenter
+}
+
+// Result is everything a pipeline produced.
+type Result struct {
+	// Output has the deserialized objects the pipeline wrote
+	// to its output.
+	Output []interface{}
+	// Errors, Warnings, and Verbose have the records written to the
+	// matching PowerShell streams.
+	Errors, Warnings, Verbose []*Object
+	State                     int
+}
+
+func guidString(u uuid.UUID) string {
+	return strings.ToUpper(u.String())
+}
+
+func (r *RunspacePool) fragment(msg *Message) [][]byte {
+	r.objectID++
+	return Fragment(r.objectID, msg.Bytes(), r.MaxFragment)
+}
+
+func (r *RunspacePool) fragments(messages ...*Message) []byte {
+	res := []byte{}
+	for _, msg := range messages {
+		for _, frag := range r.fragment(msg) {
+			res = append(res, frag...)
+		}
+	}
+	return res
+}
+
+func (r *RunspacePool) message(kind MessageType, pid uuid.UUID, data []byte) *Message {
+	return &Message{
+		Destination: toServer,
+		Type:        kind,
+		RPID:        r.ID,
+		PID:         pid,
+		Data:        data,
+	}
+}
+
+// receive fetches messages from the shell, or from the pipeline
+// commandID if it is not empty.  done is set when the endpoint says
+// the command has finished.
+func (r *RunspacePool) receive(commandID string) (msgs []*Message, done bool, err error) {
+	res, err := r.shell.Receive(commandID, "stdout")
+	if err != nil {
+		return nil, false, err
+	}
+	for _, stream := range res.Streams {
+		got, err := r.defrag.Add(stream.Data)
+		if err != nil {
+			return nil, false, err
+		}
+		msgs = append(msgs, got...)
+	}
+	return msgs, res.Done, nil
+}
+
+func intProperty(obj *Object, name string) int {
+	if val, ok := obj.Properties[name].(int32); ok {
+		return int(val)
+	}
+	return -1
+}
+
+// Open creates a runspace pool on the endpoint that client talks to,
+// and waits for it to finish opening.
+func Open(client *wsman.Client) (*RunspacePool, error) {
+	r := &RunspacePool{ID: uuid.NewV4(), MaxFragment: 32 * 1024}
+	r.shell = client.NewShell(SHELL_URI)
+	r.shell.ID = guidString(r.ID)
+	r.shell.InputStreams = "stdin pr"
+	r.shell.OutputStreams = "stdout"
+	r.shell.Options = []*dom.Element{
+		dom.ElemC("Option", wsman.NS_WSMAN, "2.3").
+			Attr("Name", "", "protocolversion").
+			Attr("MustComply", "", "true"),
+	}
+	creation := r.fragments(
+		r.message(SESSION_CAPABILITY, uuid.UUID{}, sessionCapability()),
+		r.message(INIT_RUNSPACEPOOL, uuid.UUID{}, initRunspacePool(1, 1)))
+	r.shell.Extra = []*dom.Element{
+		dom.ElemC("creationXml", NS_POWERSHELL, base64.StdEncoding.EncodeToString(creation)),
+	}
+	if err := r.shell.Open(); err != nil {
+		return nil, err
+	}
+	for {
+		msgs, _, err := r.receive("")
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		for _, msg := range msgs {
+			if msg.Type != RUNSPACEPOOL_STATE {
+				continue
+			}
+			state, err := deserializeObject(msg.Data)
+			if err != nil {
+				r.Close()
+				return nil, err
+			}
+			switch intProperty(state, "RunspaceState") {
+			case RunspaceOpened:
+				return r, nil
+			case RunspaceClosed, RunspaceBroken:
+				r.Close()
+				return nil, fmt.Errorf("Runspace pool failed to open: %v", state.Properties["ExceptionAsErrorRecord"])
+			}
+		}
+	}
+}
+
+// Close closes the runspace pool and deletes its shell.
+func (r *RunspacePool) Close() error {
+	return r.shell.Close()
+}
+
+// Run runs script in the runspace pool and collects everything it
+// produces.  An error is returned if the pipeline could not be run or
+// failed, but errors the script wrote are only recorded in the Result.
+func (r *RunspacePool) Run(script string) (*Result, error) {
+	pid := uuid.NewV4()
+	frags := r.fragment(r.message(CREATE_PIPELINE, pid, createPipeline(script)))
+	msg := r.shell.NewMessage(wsman.COMMAND)
+	line := dom.Elem("CommandLine", wsman.NS_SHELL).Attr("CommandId", "", guidString(pid))
+	line.AddChildren(
+		dom.Elem("Command", wsman.NS_SHELL),
+		dom.ElemC("Arguments", wsman.NS_SHELL, base64.StdEncoding.EncodeToString(frags[0])))
+	msg.SetBody(line)
+	cmd, err := r.shell.Start(msg)
+	if err != nil {
+		return nil, err
+	}
+	for i, frag := range frags[1:] {
+		if err := r.shell.Send(cmd.ID, "stdin", frag, i == len(frags)-2); err != nil {
+			return nil, err
+		}
+	}
+	res := &Result{State: PipelineRunning}
+	for res.State == PipelineRunning || res.State == PipelineStopping {
+		msgs, done, err := r.receive(cmd.ID)
+		if err != nil {
+			return res, err
+		}
+		for _, msg := range msgs {
+			if msg.Type == PIPELINE_OUTPUT {
+				val, err := Deserialize(msg.Data)
+				if err != nil {
+					return res, err
+				}
+				res.Output = append(res.Output, val)
+				continue
+			}
+			obj, err := deserializeObject(msg.Data)
+			if err != nil {
+				return res, err
+			}
+			switch msg.Type {
+			case ERROR_RECORD:
+				res.Errors = append(res.Errors, obj)
+			case WARNING_RECORD:
+				res.Warnings = append(res.Warnings, obj)
+			case VERBOSE_RECORD:
+				res.Verbose = append(res.Verbose, obj)
+			case PIPELINE_STATE:
+				res.State = intProperty(obj, "PipelineState")
+				if res.State == PipelineFailed {
+					err = fmt.Errorf("Pipeline failed: %v", obj.Properties["ExceptionAsErrorRecord"])
+				}
+			}
+			if err != nil {
+				cmd.Signal(SIGNAL_CTRL_C)
+				return res, err
+			}
+		}
+		if done && res.State == PipelineRunning {
+			res.State = PipelineCompleted
+		}
+	}
+	cmd.Signal(SIGNAL_CTRL_C)
+	return res, nil
+}
@@ -0,0 +1,177 @@
+package wsman
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/VictorLowther/soap"
+	uuid "github.com/satori/go.uuid"
+)
+
+// IDEMPOTENT are the actions a RetryPolicy retries unless told
+// otherwise.  Sending any of them twice does no harm.
+var IDEMPOTENT = map[string]bool{
+	GET:       true,
+	ENUMERATE: true,
+	PULL:      true,
+	RELEASE:   true,
+}
+
+// RetryPolicy says when Send should try a request again after a
+// failure that might not happen the next time: timeouts, refused,
+// reset, or dropped connections, HTTP 429, 502, 503, and 504, and
+// wsman:TimedOut faults.
+type RetryPolicy struct {
+	// MaxAttempts is the most times a request is sent, counting the
+	// first time.
+	MaxAttempts int
+	// The wait before the first retry is InitialBackoff, and doubles
+	// for each one after that up to MaxBackoff, or without limit if
+	// MaxBackoff is 0.  Each wait is randomly cut by up to half, so
+	// that many clients do not all retry at once.
+	InitialBackoff, MaxBackoff time.Duration
+	// Budget is the most time to spend on a request, retries
+	// included.  0 means no limit.
+	Budget time.Duration
+	// Idempotent are the actions that may be retried.  nil means
+	// IDEMPOTENT.
+	Idempotent map[string]bool
+	// OnRetry, if set, is called before every retry with the action,
+	// the number of the attempt that failed, why it failed, and how
+	// long it will wait.
+	OnRetry func(action string, attempt int, err error, wait time.Duration)
+}
+
+// DefaultRetryPolicy returns a RetryPolicy that tries a request up to
+// 4 times over no more than 2 minutes.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+		Budget:         2 * time.Minute,
+	}
+}
+
+// transient returns why a request failed if trying it again might
+// help, and nil if it would not.
+func transient(res *soap.Message, err error) error {
+	switch e := err.(type) {
+	case nil:
+	case *url.Error:
+		if transientNet(e.Err) {
+			return err
+		}
+		return nil
+	case *HTTPError:
+		switch e.StatusCode {
+		case 429, 502, 503, 504:
+			return err
+		}
+		if res == nil {
+			return nil
+		}
+	default:
+		return nil
+	}
+	reply := &Message{Message: res}
+	if res.Fault() != nil && reply.FaultSubcode() == "TimedOut" {
+		return fmt.Errorf("SOAP Fault: %s %s", reply.FaultSubcode(), reply.FaultReason())
+	}
+	return nil
+}
+
+// transientNet reports whether err is a network error that might not
+// happen again.  Certificate errors, bad URLs, and the like will.
+func transientNet(err error) bool {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.InitialBackoff
+	for i := 1; i < attempt && (p.MaxBackoff == 0 || wait < p.MaxBackoff); i++ {
+		wait *= 2
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	if wait <= 1 {
+		return wait
+	}
+	return wait - time.Duration(rand.Int63n(int64(wait/2)))
+}
+
+// post sends the message, retrying as the Client's RetryPolicy says.
+func (m *Message) post() (*soap.Message, error) {
+	ctx := m.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	policy := m.client.Retry
+	if policy == nil {
+		return m.client.post(ctx, m.Message)
+	}
+	action, _ := m.GHC("Action")
+	idempotent := policy.Idempotent
+	if idempotent == nil {
+		idempotent = IDEMPOTENT
+	}
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		res, err := m.client.post(ctx, m.Message)
+		why := transient(res, err)
+		if why == nil || !idempotent[action] || attempt >= policy.MaxAttempts {
+			return res, err
+		}
+		wait := policy.backoff(attempt)
+		if policy.Budget > 0 && time.Since(start)+wait > policy.Budget {
+			return res, err
+		}
+		if policy.OnRetry != nil {
+			policy.OnRetry(action, attempt, why, wait)
+		}
+		select {
+		case <-ctx.Done():
+			return res, err
+		case <-time.After(wait):
+		}
+		m.newMessageID()
+	}
+}
+
+// newMessageID gives the message a new MessageID, so that the endpoint
+// treats sending it again as a new request.
+func (m *Message) newMessageID() {
+	m.SetHeader(soap.MuElemC("MessageID", NS_WSA, fmt.Sprintf("uuid:%s", uuid.NewV4())))
+}
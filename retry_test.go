@@ -0,0 +1,201 @@
+package wsman_test
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/wsman"
+	"github.com/VictorLowther/wsman/wsmantest"
+)
+
+// retries is a RetryPolicy that records the retries it is asked for.
+type retries struct {
+	policy *wsman.RetryPolicy
+	waits  []time.Duration
+}
+
+func newRetries(attempts int) *retries {
+	res := &retries{policy: &wsman.RetryPolicy{
+		MaxAttempts:    attempts,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+	}}
+	res.policy.OnRetry = func(action string, attempt int, err error, wait time.Duration) {
+		res.waits = append(res.waits, wait)
+	}
+	return res
+}
+
+var busy = wsmantest.SendFault(&wsmantest.Fault{
+	Code:    "Receiver",
+	Subcode: "wsman:InternalError",
+	Reason:  "Busy",
+	Status:  http.StatusServiceUnavailable,
+})
+
+func TestRetryTransient(t *testing.T) {
+	for _, failure := range []struct {
+		name    string
+		failure wsmantest.Failure
+	}{
+		{"TimedOut", wsmantest.SendFault(wsmantest.TimedOut())},
+		{"503", busy},
+		{"reset", wsmantest.ResetConnection},
+	} {
+		s := wsmantest.NewServer()
+		s.HandleGet(fanURI, fan)
+		s.Inject("", wsman.GET, 2, failure.failure)
+		client := s.NewClient()
+		r := newRetries(3)
+		client.Retry = r.policy
+		getFan(t, client, "Fan.1")
+		if len(r.waits) != 2 {
+			t.Errorf("%s: retried %d times, wanted 2", failure.name, len(r.waits))
+		}
+		reqs := s.Requests()
+		if len(reqs) != 3 {
+			t.Fatalf("%s: sent %d requests, wanted 3", failure.name, len(reqs))
+		}
+		if reqs[0].MessageID == reqs[1].MessageID || reqs[1].MessageID == reqs[2].MessageID {
+			t.Errorf("%s: retries reused a MessageID", failure.name)
+		}
+		s.Close()
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	s := wsmantest.NewServer()
+	defer s.Close()
+	s.HandleGet(fanURI, fan)
+	s.Inject("", wsman.GET, 0, wsmantest.SendFault(wsmantest.TimedOut()))
+	client := s.NewClient()
+	r := newRetries(3)
+	client.Retry = r.policy
+	reply, err := client.Get(fanURI).Selectors("DeviceID", "Fan.1").Send()
+	if err == nil || reply == nil || reply.FaultSubcode() != "TimedOut" {
+		t.Errorf("Got %v, wanted the last TimedOut fault", err)
+	}
+	if n := len(s.Requests()); n != 3 {
+		t.Errorf("Sent %d requests, wanted 3", n)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	s := wsmantest.NewServer()
+	defer s.Close()
+	s.HandleGet(fanURI, fan)
+	s.Inject("", wsman.GET, 0, busy)
+	client := s.NewClient()
+	r := newRetries(100)
+	r.policy.InitialBackoff = 20 * time.Millisecond
+	r.policy.MaxBackoff = 20 * time.Millisecond
+	r.policy.Budget = 50 * time.Millisecond
+	client.Retry = r.policy
+	start := time.Now()
+	if _, err := client.Get(fanURI).Selectors("DeviceID", "Fan.1").Send(); err == nil {
+		t.Fatal("Get should have failed")
+	}
+	// The budget is checked before each wait, so only the last
+	// request itself can run over it.
+	if elapsed := time.Since(start); elapsed > 80*time.Millisecond {
+		t.Errorf("Took %v, well over the budget", elapsed)
+	}
+	if n := len(s.Requests()); n < 2 || n > 6 {
+		t.Errorf("Sent %d requests in the budget", n)
+	}
+}
+
+func TestRetryOnlyIdempotent(t *testing.T) {
+	s := wsmantest.NewServer()
+	defer s.Close()
+	s.HandleInvoke(fanURI, "Reset", func(req *wsmantest.Request) (*dom.Element, error) {
+		return dom.Elem("Reset_OUTPUT", fanURI).AddChild(dom.ElemC("ReturnValue", fanURI, "0")), nil
+	})
+	s.Inject("", "", 1, busy)
+	client := s.NewClient()
+	r := newRetries(3)
+	client.Retry = r.policy
+	if _, err := client.Invoke(fanURI, "Reset").Send(); err == nil {
+		t.Error("Invoke should not have been retried")
+	}
+	if len(r.waits) != 0 || len(s.Requests()) != 1 {
+		t.Errorf("Invoke was retried")
+	}
+
+	r.policy.Idempotent = map[string]bool{fanURI + "/Reset": true}
+	s.Inject("", "", 1, busy)
+	if _, err := client.Invoke(fanURI, "Reset").Send(); err != nil {
+		t.Errorf("Invoke marked idempotent should have been retried: %v", err)
+	}
+}
+
+func TestRetryNotTransient(t *testing.T) {
+	// A certificate that does not check out will not check out the
+	// next time either.
+	s := wsmantest.NewTLSServer()
+	defer s.Close()
+	s.HandleGet(fanURI, fan)
+	client, err := wsman.ConnectWith(s.Endpoint(), "", "", false, &http.Transport{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newRetries(3)
+	client.Retry = r.policy
+	if _, err := client.Get(fanURI).Selectors("DeviceID", "Fan.1").Send(); err == nil {
+		t.Fatal("Get should have failed to verify the certificate")
+	}
+	if len(r.waits) != 0 {
+		t.Errorf("Retried a certificate error %d times", len(r.waits))
+	}
+
+	// Nor will a fault that is not TimedOut.
+	client = s.NewClient()
+	client.Retry = r.policy
+	if _, err := client.Get(fanURI).Send(); err == nil {
+		t.Fatal("Get without selectors should have faulted")
+	}
+	if len(r.waits) != 0 {
+		t.Errorf("Retried an InvalidSelectors fault %d times", len(r.waits))
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	client, err := wsman.Connect("http://127.0.0.1:1/wsman", "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newRetries(5)
+	// No MaxBackoff means the waits keep doubling.
+	r.policy.MaxBackoff = 0
+	client.Retry = r.policy
+	if _, err := client.Get(fanURI).Send(); err == nil {
+		t.Fatal("Get of a closed port should fail")
+	}
+	if len(r.waits) != 4 {
+		t.Fatalf("Retried a refused connection %d times, wanted 4", len(r.waits))
+	}
+	for i, wait := range r.waits {
+		max := time.Millisecond << uint(i)
+		if wait < max/2 || wait > max {
+			t.Errorf("Wait %d was %v, wanted between %v and %v", i, wait, max/2, max)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package wsman
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/search"
+)
+
+// Shell is a remote shell managed with the Windows Remote Shell
+// extensions to WSMAN.  See
+// https://msdn.microsoft.com/en-us/library/cc251526.aspx for the gory
+// details.
+type Shell struct {
+	client *Client
+	// ResourceURI is the type of shell to create.
+	ResourceURI string
+	// ID is the ShellId of the shell.  The endpoint assigns it when
+	// the shell is opened unless it was set beforehand.
+	ID string
+	// InputStreams and OutputStreams are the space-seperated names of
+	// the streams the shell will accept and produce.
+	InputStreams, OutputStreams string
+	// Options will be added to the OptionSet of the Create message.
+	Options []*dom.Element
+	// Extra will be added to the Shell element of the Create message.
+	Extra []*dom.Element
+	// ReceiveTimeout is how long the endpoint may wait for output
+	// before answering a Receive.  If it is zero, half of the Client
+	// timeout is used.
+	ReceiveTimeout time.Duration
+}
+
+// Stream is a chunk of output received from a Shell.
+type Stream struct {
+	Name, CommandID string
+	Data            []byte
+	End             bool
+}
+
+// Received is everything a single Receive returned.
+type Received struct {
+	Streams []Stream
+	// Done is set when the command has finished, in which case
+	// ExitCode has its exit code.
+	Done     bool
+	ExitCode int
+}
+
+// Command is a command running in a Shell.
+type Command struct {
+	Shell    *Shell
+	ID       string
+	Done     bool
+	ExitCode int
+}
+
+func attrValue(e *dom.Element, name string) string {
+	for _, attr := range e.Attributes {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// NewShell makes a new Shell of the passed type.  It will not be
+// created on the endpoint until Open is called.
+func (c *Client) NewShell(resource string) *Shell {
+	return &Shell{
+		client:        c,
+		ResourceURI:   resource,
+		InputStreams:  "stdin",
+		OutputStreams: "stdout stderr",
+	}
+}
+
+// NewCmdShell makes a new cmd.exe Shell.
+func (c *Client) NewCmdShell() *Shell {
+	res := c.NewShell(CMD_SHELL)
+	res.Options = []*dom.Element{
+		dom.ElemC("Option", NS_WSMAN, "FALSE").Attr("Name", "", "WINRS_NOPROFILE"),
+		dom.ElemC("Option", NS_WSMAN, "437").Attr("Name", "", "WINRS_CODEPAGE"),
+	}
+	return res
+}
+
+// NewMessage creates a Message with the passed action that is
+// addressed to the shell.
+func (s *Shell) NewMessage(action string) *Message {
+	msg := s.client.NewMessage(action).ResourceURI(s.ResourceURI)
+	if s.ID != "" {
+		msg.Selectors("ShellId", s.ID)
+	}
+	return msg
+}
+
+// Open creates the shell on the endpoint.
+func (s *Shell) Open() error {
+	msg := s.client.NewMessage(CREATE).ResourceURI(s.ResourceURI)
+	if len(s.Options) > 0 {
+		msg.AddOption(s.Options...)
+	}
+	body := dom.Elem("Shell", NS_SHELL)
+	if s.ID != "" {
+		body.Attr("ShellId", "", s.ID)
+	}
+	body.AddChildren(
+		dom.ElemC("InputStreams", NS_SHELL, s.InputStreams),
+		dom.ElemC("OutputStreams", NS_SHELL, s.OutputStreams))
+	body.AddChildren(s.Extra...)
+	msg.SetBody(body)
+	res, err := msg.Send()
+	if err != nil {
+		return err
+	}
+	if id := search.First(search.Tag("ShellId", NS_SHELL), res.AllBodyElements()); id != nil {
+		s.ID = string(id.Content)
+		return nil
+	}
+	// Older endpoints only hand back the ResourceCreated EPR.
+	selset := search.First(search.Tag("SelectorSet", NS_WSMAN), res.AllBodyElements())
+	if selset != nil {
+		for _, sel := range selset.Children() {
+			if attrValue(sel, "Name") == "ShellId" {
+				s.ID = string(sel.Content)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("No ShellId in reply to shell creation")
+}
+
+// Close deletes the shell from the endpoint.
+func (s *Shell) Close() error {
+	_, err := s.NewMessage(DELETE).Send()
+	return err
+}
+
+// Receive retrieves pending output from streams (a space seperated
+// list of stream names).  If commandID is empty, output from the
+// shell itself will be retrieved.  If the endpoint times out waiting
+// for output, Receive returns an empty Received and no error.
+func (s *Shell) Receive(commandID, streams string) (*Received, error) {
+	timeout := s.ReceiveTimeout
+	if timeout == 0 {
+		timeout = s.client.Timeout / 2
+	}
+	msg := s.NewMessage(RECEIVE)
+	if timeout > 0 {
+		msg.OperationTimeout(timeout)
+	}
+	desired := dom.ElemC("DesiredStream", NS_SHELL, streams)
+	if commandID != "" {
+		desired.Attr("CommandId", "", commandID)
+	}
+	msg.SetBody(dom.Elem("Receive", NS_SHELL).AddChild(desired))
+	res, err := msg.Send()
+	if err != nil {
+		if res != nil && res.FaultSubcode() == "TimedOut" {
+			return &Received{}, nil
+		}
+		return nil, err
+	}
+	resp := search.First(search.Tag("ReceiveResponse", NS_SHELL), res.Body())
+	if resp == nil {
+		return nil, fmt.Errorf("No ReceiveResponse in reply to Receive")
+	}
+	received := &Received{}
+	for _, elem := range resp.Children() {
+		switch elem.Name.Local {
+		case "Stream":
+			data, err := base64.StdEncoding.DecodeString(string(elem.Content))
+			if err != nil {
+				return nil, fmt.Errorf("Stream %s is not base64 encoded: %v", attrValue(elem, "Name"), err)
+			}
+			received.Streams = append(received.Streams, Stream{
+				Name:      attrValue(elem, "Name"),
+				CommandID: attrValue(elem, "CommandId"),
+				Data:      data,
+				End:       attrValue(elem, "End") == "true",
+			})
+		case "CommandState":
+			if !strings.HasSuffix(attrValue(elem, "State"), "/Done") {
+				continue
+			}
+			received.Done = true
+			code := search.First(search.Tag("ExitCode", NS_SHELL), elem.Children())
+			if code != nil {
+				received.ExitCode, _ = strconv.Atoi(strings.TrimSpace(string(code.Content)))
+			}
+		}
+	}
+	return received, nil
+}
+
+// Send feeds data to the stream of the command.  If end is true, the
+// command will be told that there will be no more input on stream.
+func (s *Shell) Send(commandID, stream string, data []byte, end bool) error {
+	elem := dom.ElemC("Stream", NS_SHELL, base64.StdEncoding.EncodeToString(data))
+	elem.Attr("Name", "", stream).Attr("CommandId", "", commandID)
+	if end {
+		elem.Attr("End", "", "true")
+	}
+	msg := s.NewMessage(SEND)
+	msg.SetBody(dom.Elem("Send", NS_SHELL).AddChild(elem))
+	_, err := msg.Send()
+	return err
+}
+
+// Signal sends a signal such as SIGNAL_CTRL_C or SIGNAL_TERMINATE
+// to the command.
+func (s *Shell) Signal(commandID, code string) error {
+	body := dom.Elem("Signal", NS_SHELL).Attr("CommandId", "", commandID)
+	body.AddChild(dom.ElemC("Code", NS_SHELL, code))
+	msg := s.NewMessage(SIGNAL)
+	msg.SetBody(body)
+	_, err := msg.Send()
+	return err
+}
+
+// Command starts command with args in the shell.  The arguments are
+// passed as-is, so any quoting they need must already be present.
+func (s *Shell) Command(command string, args ...string) (*Command, error) {
+	msg := s.NewMessage(COMMAND).Options(
+		"WINRS_CONSOLEMODE_STDIN", "TRUE",
+		"WINRS_SKIP_CMD_SHELL", "FALSE")
+	line := dom.Elem("CommandLine", NS_SHELL)
+	line.AddChild(dom.ElemC("Command", NS_SHELL, command))
+	for _, arg := range args {
+		line.AddChild(dom.ElemC("Arguments", NS_SHELL, arg))
+	}
+	msg.SetBody(line)
+	return s.Start(msg)
+}
+
+// Start sends a Command message made with s.NewMessage(COMMAND), and
+// returns the Command it started.  Use it when Command does not build
+// the CommandLine you need.
+func (s *Shell) Start(msg *Message) (*Command, error) {
+	res, err := msg.Send()
+	if err != nil {
+		return nil, err
+	}
+	id := search.First(search.Tag("CommandId", NS_SHELL), res.AllBodyElements())
+	if id == nil {
+		return nil, fmt.Errorf("No CommandId in reply to Command")
+	}
+	return &Command{Shell: s, ID: string(id.Content)}, nil
+}
+
+// Receive retrieves pending stdout and stderr output from the command.
+func (c *Command) Receive() (*Received, error) {
+	res, err := c.Shell.Receive(c.ID, "stdout stderr")
+	if err != nil {
+		return nil, err
+	}
+	if res.Done {
+		c.Done = true
+		c.ExitCode = res.ExitCode
+	}
+	return res, nil
+}
+
+// Send feeds data to the stdin of the command.
+func (c *Command) Send(data []byte, end bool) error {
+	return c.Shell.Send(c.ID, "stdin", data, end)
+}
+
+// Signal sends a signal to the command.
+func (c *Command) Signal(code string) error {
+	return c.Shell.Signal(c.ID, code)
+}
+
+// Terminate forcibly stops the command.
+func (c *Command) Terminate() error {
+	return c.Signal(SIGNAL_TERMINATE)
+}
+
+func (c *Command) copyIn(stdin io.Reader) error {
+	buf := make([]byte, 4096)
+	for {
+		n, err := stdin.Read(buf)
+		if n > 0 {
+			if serr := c.Send(buf[:n], false); serr != nil {
+				return serr
+			}
+		}
+		if err == io.EOF {
+			return c.Send(nil, true)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Run feeds stdin to the command and copies its output to stdout and
+// stderr as it arrives until the command exits.  Any of stdin,
+// stdout, and stderr may be nil.  It returns the exit code of the
+// command.
+func (c *Command) Run(stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	inErr := make(chan error, 1)
+	if stdin != nil {
+		go func() { inErr <- c.copyIn(stdin) }()
+	}
+	// Input that fails to send is only an error if the command is
+	// still running without it, since a command that has exited
+	// rejects input it no longer wants.
+	var inputErr error
+	for !c.Done {
+		res, err := c.Receive()
+		if err != nil {
+			return -1, err
+		}
+		if inputErr != nil && !c.Done && len(res.Streams) == 0 {
+			return -1, inputErr
+		}
+		for _, stream := range res.Streams {
+			out := stdout
+			if stream.Name == "stderr" {
+				out = stderr
+			}
+			if out == nil {
+				continue
+			}
+			if _, err := out.Write(stream.Data); err != nil {
+				return -1, err
+			}
+		}
+		select {
+		case err := <-inErr:
+			inputErr = err
+		default:
+		}
+	}
+	// The endpoint keeps finished commands around until told otherwise.
+	c.Terminate()
+	return c.ExitCode, nil
+}
@@ -0,0 +1,231 @@
+package wsman_test
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VictorLowther/wsman"
+	"github.com/VictorLowther/wsman/wsmantest"
+)
+
+// echo copies its input to stdout and its arguments to stderr.
+func echo(cmd *wsmantest.ShellCommand) ([]byte, []byte, int) {
+	return cmd.Input(), []byte(strings.Join(cmd.Arguments, " ")), 3
+}
+
+func openShell(t *testing.T, h wsmantest.CommandHandler) (*wsmantest.Server, *wsman.Shell) {
+	s := wsmantest.NewServer()
+	s.HandleShell(wsman.CMD_SHELL, h)
+	shell := s.NewClient().NewCmdShell()
+	if err := shell.Open(); err != nil {
+		s.Close()
+		t.Fatalf("Error opening shell: %v", err)
+	}
+	if shell.ID == "" {
+		s.Close()
+		t.Fatalf("Shell has no ID")
+	}
+	return s, shell
+}
+
+func TestShellRun(t *testing.T) {
+	s, shell := openShell(t, echo)
+	defer s.Close()
+	cmd, err := shell.Command("findstr", "/v", `"x y"`)
+	if err != nil {
+		t.Fatalf("Error starting command: %v", err)
+	}
+	var stdout, stderr bytes.Buffer
+	code, err := cmd.Run(strings.NewReader("hello\n"), &stdout, &stderr)
+	if err != nil {
+		t.Fatalf("Error running command: %v", err)
+	}
+	if code != 3 || !cmd.Done || cmd.ExitCode != 3 {
+		t.Errorf("Got exit code %d (done %v), wanted 3", code, cmd.Done)
+	}
+	if stdout.String() != "hello\n" {
+		t.Errorf("Got stdout %q", stdout.String())
+	}
+	if stderr.String() != `/v "x y"` {
+		t.Errorf("Got stderr %q", stderr.String())
+	}
+	cmds := s.Commands()
+	if len(cmds) != 1 || cmds[0].ID != cmd.ID || cmds[0].ShellID != shell.ID || cmds[0].Command != "findstr" {
+		t.Fatalf("Server ran %#v", cmds)
+	}
+	// Run cleans up after the command.
+	if sigs := cmds[0].Signals(); len(sigs) != 1 || sigs[0] != wsman.SIGNAL_TERMINATE {
+		t.Errorf("Got signals %v", sigs)
+	}
+	if err := shell.Close(); err != nil {
+		t.Errorf("Error closing shell: %v", err)
+	}
+	if _, err := shell.Command("dir"); err == nil {
+		t.Errorf("Started a command in a closed shell")
+	}
+}
+
+func TestShellReceive(t *testing.T) {
+	s, shell := openShell(t, func(cmd *wsmantest.ShellCommand) ([]byte, []byte, int) {
+		return []byte("out"), nil, 0
+	})
+	defer s.Close()
+	defer shell.Close()
+	// The shell itself has nothing to say, which is not an error.
+	res, err := shell.Receive("", "stdout")
+	if err != nil || len(res.Streams) != 0 || res.Done {
+		t.Errorf("Got %#v, %v receiving from the shell", res, err)
+	}
+	cmd, err := shell.Command("dir")
+	if err != nil {
+		t.Fatalf("Error starting command: %v", err)
+	}
+	res, err = cmd.Receive()
+	if err != nil {
+		t.Fatalf("Error receiving: %v", err)
+	}
+	if res.Done || cmd.Done {
+		t.Errorf("Command finished before saying so")
+	}
+	if len(res.Streams) != 2 || res.Streams[0].Name != "stdout" || string(res.Streams[0].Data) != "out" ||
+		res.Streams[0].CommandID != cmd.ID || !res.Streams[0].End {
+		t.Errorf("Got streams %#v", res.Streams)
+	}
+	if err := cmd.Send([]byte("late"), true); err == nil {
+		t.Errorf("Sent input to a command that has exited")
+	}
+	if res, err = cmd.Receive(); err != nil || !res.Done || !cmd.Done || cmd.ExitCode != 0 {
+		t.Errorf("Got %#v, %v after the command exited", res, err)
+	}
+}
+
+func TestShellSignal(t *testing.T) {
+	s, shell := openShell(t, echo)
+	defer s.Close()
+	defer shell.Close()
+	cmd, err := shell.Command("more")
+	if err != nil {
+		t.Fatalf("Error starting command: %v", err)
+	}
+	if err := cmd.Signal(wsman.SIGNAL_CTRL_C); err != nil {
+		t.Errorf("Error sending ctrl-c: %v", err)
+	}
+	if err := cmd.Terminate(); err != nil {
+		t.Errorf("Error terminating: %v", err)
+	}
+	if err := cmd.Send([]byte("x"), false); err == nil {
+		t.Errorf("Sent input to a terminated command")
+	}
+	sigs := s.Commands()[0].Signals()
+	if len(sigs) != 2 || sigs[0] != wsman.SIGNAL_CTRL_C || sigs[1] != wsman.SIGNAL_TERMINATE {
+		t.Errorf("Got signals %v", sigs)
+	}
+	if err := shell.Signal("NoSuchCommand", wsman.SIGNAL_CTRL_C); err == nil {
+		t.Errorf("Signalled a command that does not exist")
+	}
+}
+
+// lateInput is stdin that only has something to say once the command
+// has exited.
+type lateInput struct {
+	exited <-chan struct{}
+	read   chan struct{}
+}
+
+func (r *lateInput) Read(buf []byte) (int, error) {
+	if r.read == nil {
+		return 0, io.EOF
+	}
+	<-r.exited
+	close(r.read)
+	r.read = nil
+	return copy(buf, "too late"), nil
+}
+
+// slowOutput holds up Run until the input has been sent.
+type slowOutput struct{ read <-chan struct{} }
+
+func (w slowOutput) Write(buf []byte) (int, error) {
+	<-w.read
+	time.Sleep(50 * time.Millisecond)
+	return len(buf), nil
+}
+
+func TestShellRunInputRace(t *testing.T) {
+	exited := make(chan struct{})
+	s, shell := openShell(t, func(cmd *wsmantest.ShellCommand) ([]byte, []byte, int) {
+		defer close(exited)
+		return []byte("done"), nil, 7
+	})
+	defer s.Close()
+	defer shell.Close()
+	cmd, err := shell.Command("ver")
+	if err != nil {
+		t.Fatalf("Error starting command: %v", err)
+	}
+	in := &lateInput{exited: exited, read: make(chan struct{})}
+	code, err := cmd.Run(in, slowOutput{in.read}, nil)
+	if err != nil || code != 7 {
+		t.Errorf("Got %d, %v from a command that exited before its input arrived", code, err)
+	}
+}
+
+func TestShellRunInputFailure(t *testing.T) {
+	s, shell := openShell(t, echo)
+	defer s.Close()
+	defer shell.Close()
+	cmd, err := shell.Command("more")
+	if err != nil {
+		t.Fatalf("Error starting command: %v", err)
+	}
+	// Input for a command that is still waiting for it is an error.
+	s.Inject(wsman.CMD_SHELL, wsman.SEND, 0, wsmantest.SendFault(wsmantest.InternalError("Pipe broken")))
+	shell.ReceiveTimeout = 100 * time.Millisecond
+	done := make(chan error, 1)
+	go func() {
+		_, err := cmd.Run(strings.NewReader("x"), nil, nil)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "Pipe broken") {
+			t.Errorf("Got %v, wanted the failure to send input", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Run waited forever for input that failed to send")
+	}
+}
+
+func TestPostFault(t *testing.T) {
+	s := wsmantest.NewServer()
+	defer s.Close()
+	s.HandleGet(fanURI, fan)
+	client := s.NewClient()
+	res, err := client.Post(client.Get(fanURI).Message)
+	herr, ok := err.(*wsman.HTTPError)
+	if !ok || herr.StatusCode != 500 {
+		t.Fatalf("Got error %#v, wanted an HTTPError", err)
+	}
+	if res == nil || res.Fault() == nil {
+		t.Fatalf("The fault was not returned")
+	}
+}
@@ -36,7 +36,8 @@ func (m *Message) InvokeResponse() (*dom.Element, string, error) {
 	if err != nil {
 		return nil, "", err
 	}
-	method, resource := path.Split(strings.TrimSuffix(action, "Response"))
+	resource, method := path.Split(strings.TrimSuffix(action, "Response"))
+	resource = strings.TrimSuffix(resource, "/")
 	retbody := search.First(search.Tag(method+"_OUTPUT", resource), m.Body())
 	if retbody == nil {
 		return nil, "", fmt.Errorf("No %s_OUTPUT section in response", method)
@@ -94,3 +95,47 @@ func (c *Client) Create(resource string) *Message {
 func (c *Client) Delete(resource string) *Message {
 	return c.NewMessage(DELETE).ResourceURI(resource)
 }
+
+// ResourceCreated parses the EndpointReference of the new instance
+// from the reply to a Create message.
+func (m *Message) ResourceCreated() (*EndpointReference, error) {
+	created := search.First(search.Tag("ResourceCreated", NS_WSMT), m.Body())
+	if created == nil {
+		return nil, fmt.Errorf("No ResourceCreated in response")
+	}
+	return ParseEPR(created)
+}
+
+// CreateInstance creates a new instance of resource from v, marshaled
+// the way Marshal does, and returns the EndpointReference of the new
+// instance.
+func (c *Client) CreateInstance(resource string, v interface{}) (*EndpointReference, error) {
+	msg := c.Create(resource)
+	if err := msg.Marshal(v); err != nil {
+		return nil, err
+	}
+	reply, err := msg.Send()
+	if err != nil {
+		return nil, err
+	}
+	return reply.ResourceCreated()
+}
+
+// GetEPR creates a wsman.Message that will get the instance epr
+// refers to.
+func (c *Client) GetEPR(epr *EndpointReference) *Message {
+	return c.NewMessage(GET).Target(epr)
+}
+
+// PutEPR creates a wsman.Message that will update the instance epr
+// refers to.  The updated instance should be the only element in the
+// Body of the message.
+func (c *Client) PutEPR(epr *EndpointReference) *Message {
+	return c.NewMessage(PUT).Target(epr)
+}
+
+// DeleteEPR creates a wsman.Message that will delete the instance epr
+// refers to.
+func (c *Client) DeleteEPR(epr *EndpointReference) *Message {
+	return c.NewMessage(DELETE).Target(epr)
+}
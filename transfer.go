@@ -0,0 +1,246 @@
+package wsman
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// DefaultMaxEnvelopeSize is the largest envelope that every
+	// version of WinRM will accept.  Send never asks for more than
+	// MaxEnvelopeSizeCeiling when raising it.
+	DefaultMaxEnvelopeSize = 153600
+
+	// envelopeOverhead is how much of an envelope we set aside for
+	// everything that is not file data.
+	envelopeOverhead = 4096
+
+	// maxCommandLine is the longest command line cmd.exe will run,
+	// less some slop for the command we wrap around the data.
+	maxCommandLine = 8191 - 256
+)
+
+// FileCopier copies files to and from Windows hosts using a cmd.exe
+// Shell.  Data is moved in base64 encoded chunks small enough to fit
+// in a single command line and envelope, and a SHA256 checksum of the
+// whole file is compared once all the chunks have been moved.
+type FileCopier struct {
+	client *Client
+	// MaxEnvelopeSize is the largest envelope the endpoint will
+	// accept.  It defaults to DefaultMaxEnvelopeSize.
+	MaxEnvelopeSize int
+	// Progress, if set, is called after every chunk with the number
+	// of bytes copied so far and the size of the file.
+	Progress func(done, total int64)
+}
+
+// NewFileCopier makes a FileCopier that uses c.
+func (c *Client) NewFileCopier() *FileCopier {
+	return &FileCopier{client: c, MaxEnvelopeSize: DefaultMaxEnvelopeSize}
+}
+
+// psQuote quotes s as a PowerShell literal string.
+func psQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// run runs a command to completion and returns its stdout.  It is an
+// error for the command to exit non-zero.
+func (s *Shell) run(command string, args ...string) (string, error) {
+	cmd, err := s.Command(command, args...)
+	if err != nil {
+		return "", err
+	}
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code, err := cmd.Run(nil, stdout, stderr)
+	if err != nil {
+		return "", err
+	}
+	if code != 0 {
+		return "", fmt.Errorf("%s exited with %d: %s", command, code, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// powershell runs script with powershell.exe and returns its stdout.
+func (s *Shell) powershell(script string) (string, error) {
+	script = "$ProgressPreference = 'SilentlyContinue'; $ErrorActionPreference = 'Stop'; " + script
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, utf16.Encode([]rune(script)))
+	return s.run("powershell", "-NoProfile", "-NonInteractive", "-EncodedCommand",
+		base64.StdEncoding.EncodeToString(buf.Bytes()))
+}
+
+func (f *FileCopier) chunkSize(limit int) int {
+	size := f.MaxEnvelopeSize
+	if size == 0 {
+		size = DefaultMaxEnvelopeSize
+	}
+	size -= envelopeOverhead
+	if limit > 0 && size > limit {
+		size = limit
+	}
+	// Keep chunks a multiple of 3 bytes so that the base64 encoded
+	// chunks can be glued back together.
+	return size / 4 * 3
+}
+
+func (f *FileCopier) progress(done, total int64) {
+	if f.Progress != nil {
+		f.Progress(done, total)
+	}
+}
+
+func (f *FileCopier) open() (*Shell, error) {
+	shell := f.client.NewCmdShell()
+	if err := shell.Open(); err != nil {
+		return nil, err
+	}
+	return shell, nil
+}
+
+func checksum(remote string) string {
+	return fmt.Sprintf("$s = [IO.File]::OpenRead(%s); "+
+		"$h = [Security.Cryptography.SHA256]::Create().ComputeHash($s); $s.Close(); "+
+		"[BitConverter]::ToString($h).Replace('-', '')", psQuote(remote))
+}
+
+// Upload copies the local file to remote on the Windows host,
+// replacing whatever was there.
+func (f *FileCopier) Upload(local, remote string) error {
+	src, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	shell, err := f.open()
+	if err != nil {
+		return err
+	}
+	defer shell.Close()
+	tmp := fmt.Sprintf(`%%TEMP%%\wsman-%s.b64`, uuid.NewV4())
+	if _, err := shell.run(fmt.Sprintf(`type NUL > "%s"`, tmp)); err != nil {
+		return err
+	}
+	sum := sha256.New()
+	buf := make([]byte, f.chunkSize(maxCommandLine-len(tmp)))
+	done := int64(0)
+	for {
+		n, err := io.ReadFull(src, buf)
+		if n > 0 {
+			sum.Write(buf[:n])
+			// The space before >> keeps a trailing digit from
+			// being taken as a file descriptor.
+			cmd := fmt.Sprintf(`echo %s >> "%s"`, base64.StdEncoding.EncodeToString(buf[:n]), tmp)
+			if _, err := shell.run(cmd); err != nil {
+				return err
+			}
+			done += int64(n)
+			f.progress(done, info.Size())
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	remoteSum, err := shell.powershell(fmt.Sprintf(
+		"$tmp = [Environment]::ExpandEnvironmentVariables(%s); "+
+			"$b = [Convert]::FromBase64String([IO.File]::ReadAllText($tmp) -replace '\\s', ''); "+
+			"[IO.File]::WriteAllBytes(%s, $b); Remove-Item -LiteralPath $tmp; %s",
+		psQuote(tmp), psQuote(remote), checksum(remote)))
+	if err != nil {
+		return err
+	}
+	if localSum := fmt.Sprintf("%x", sum.Sum(nil)); !strings.EqualFold(localSum, remoteSum) {
+		return fmt.Errorf("Checksum mismatch uploading %s: local %s, remote %s", local, localSum, remoteSum)
+	}
+	return nil
+}
+
+// Download copies remote on the Windows host to the local file,
+// replacing whatever was there.
+func (f *FileCopier) Download(remote, local string) error {
+	shell, err := f.open()
+	if err != nil {
+		return err
+	}
+	defer shell.Close()
+	out, err := shell.powershell(fmt.Sprintf("(Get-Item -LiteralPath %s).Length", psQuote(remote)))
+	if err != nil {
+		return err
+	}
+	total, err := strconv.ParseInt(out, 10, 64)
+	if err != nil {
+		return fmt.Errorf("Could not get the size of %s: %v", remote, err)
+	}
+	dest, err := os.Create(local)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+	sum := sha256.New()
+	size := int64(f.chunkSize(0))
+	for done := int64(0); done < total; {
+		out, err := shell.powershell(fmt.Sprintf(
+			"$f = [IO.File]::OpenRead(%s); [void]$f.Seek(%d, 0); "+
+				"$b = New-Object byte[] %d; $n = $f.Read($b, 0, %d); $f.Close(); "+
+				"[Convert]::ToBase64String($b, 0, $n)",
+			psQuote(remote), done, size, size))
+		if err != nil {
+			return err
+		}
+		chunk, err := base64.StdEncoding.DecodeString(out)
+		if err != nil {
+			return err
+		}
+		if len(chunk) == 0 {
+			return fmt.Errorf("%s shrank while it was being downloaded", remote)
+		}
+		if _, err := dest.Write(chunk); err != nil {
+			return err
+		}
+		sum.Write(chunk)
+		done += int64(len(chunk))
+		f.progress(done, total)
+	}
+	remoteSum, err := shell.powershell(checksum(remote))
+	if err != nil {
+		return err
+	}
+	if localSum := fmt.Sprintf("%x", sum.Sum(nil)); !strings.EqualFold(localSum, remoteSum) {
+		return fmt.Errorf("Checksum mismatch downloading %s: local %s, remote %s", remote, localSum, remoteSum)
+	}
+	return dest.Close()
+}
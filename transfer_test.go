@@ -0,0 +1,189 @@
+package wsman_test
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/wsman"
+	"github.com/VictorLowther/wsman/wsmantest"
+)
+
+// fileHost serves a Windows host with files to copy.
+func fileHost() (*wsmantest.Server, *wsmantest.Files) {
+	files := wsmantest.NewFiles()
+	s := wsmantest.NewServer()
+	s.HandleShell(wsman.CMD_SHELL, files.Run)
+	return s, files
+}
+
+// testData makes n bytes that are not all the same.
+func testData(n int) []byte {
+	res := make([]byte, n)
+	for i := range res {
+		res[i] = byte(i * 7)
+	}
+	return res
+}
+
+func TestUploadDownload(t *testing.T) {
+	s, files := fileHost()
+	defer s.Close()
+	dir, err := ioutil.TempDir("", "wsman")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, remote := range []string{
+		`C:\Temp\plain.bin`,
+		`C:\Program Files\My App\setup.exe`,
+		`C:\Users\Bob\Bob's "report".txt`,
+		`C:\Temp\'';$x='`,
+	} {
+		for _, size := range []int{0, 1, 299, 300, 1000} {
+			data := testData(size)
+			local := filepath.Join(dir, "up")
+			if err := ioutil.WriteFile(local, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+			copier := s.NewClient().NewFileCopier()
+			// Leave room for 300 bytes of data a chunk.
+			copier.MaxEnvelopeSize = 4096 + 400
+			if err := copier.Upload(local, remote); err != nil {
+				t.Fatalf("Uploading %d bytes to %s: %v", size, remote, err)
+			}
+			if got, ok := files.Get(remote); !ok || !bytes.Equal(got, data) {
+				t.Errorf("Uploaded %d bytes to %s, got %d", size, remote, len(got))
+			}
+			back := filepath.Join(dir, "down")
+			if err := copier.Download(remote, back); err != nil {
+				t.Fatalf("Downloading %d bytes from %s: %v", size, remote, err)
+			}
+			if got, _ := ioutil.ReadFile(back); !bytes.Equal(got, data) {
+				t.Errorf("Downloaded %d bytes from %s, got %d", size, remote, len(got))
+			}
+		}
+	}
+}
+
+func TestUploadChunks(t *testing.T) {
+	s, files := fileHost()
+	defer s.Close()
+	f, err := ioutil.TempFile("", "wsman")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	data := testData(1000)
+	f.Write(data)
+	f.Close()
+
+	copier := s.NewClient().NewFileCopier()
+	copier.MaxEnvelopeSize = 4096 + 400
+	progress := []string{}
+	copier.Progress = func(done, total int64) {
+		progress = append(progress, fmt.Sprintf("%d/%d", done, total))
+	}
+	if err := copier.Upload(f.Name(), `C:\x.bin`); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"300/1000", "600/1000", "900/1000", "1000/1000"}; !reflect.DeepEqual(progress, want) {
+		t.Errorf("Got progress %v, wanted %v", progress, want)
+	}
+	echoes := 0
+	for _, cmd := range s.Commands() {
+		if strings.HasPrefix(cmd.Command, "echo ") {
+			echoes++
+			if len(cmd.Command) > 8191 {
+				t.Errorf("Command line is %d long", len(cmd.Command))
+			}
+		}
+	}
+	if echoes != 4 {
+		t.Errorf("Sent the data in %d chunks, wanted 4", echoes)
+	}
+	for _, req := range s.Requests() {
+		if n := len(req.Message.String()); n > copier.MaxEnvelopeSize {
+			t.Errorf("%s envelope is %d bytes, more than %d", req.Action, n, copier.MaxEnvelopeSize)
+		}
+	}
+
+	// Chunks are cut to fit the command line as well as the envelope.
+	copier.MaxEnvelopeSize = wsman.DefaultMaxEnvelopeSize
+	data = testData(20000)
+	if err := ioutil.WriteFile(f.Name(), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	progress = progress[:0]
+	if err := copier.Upload(f.Name(), `C:\x.bin`); err != nil {
+		t.Fatal(err)
+	}
+	if len(progress) < 4 {
+		t.Errorf("Sent 20000 bytes in %d chunks", len(progress))
+	}
+	for _, cmd := range s.Commands() {
+		if len(cmd.Command) > 8191 {
+			t.Errorf("Command line is %d long", len(cmd.Command))
+		}
+	}
+	if got, _ := files.Get(`C:\x.bin`); !bytes.Equal(got, data) {
+		t.Errorf("Uploaded 20000 bytes, got %d", len(got))
+	}
+}
+
+func TestCopyChecksumMismatch(t *testing.T) {
+	s, files := fileHost()
+	defer s.Close()
+	files.BadChecksums = true
+	files.Put(`C:\remote.bin`, testData(10))
+	dir, err := ioutil.TempDir("", "wsman")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	local := filepath.Join(dir, "local.bin")
+	copier := s.NewClient().NewFileCopier()
+	err = copier.Download(`C:\remote.bin`, local)
+	if err == nil || !strings.Contains(err.Error(), "Checksum mismatch downloading") {
+		t.Errorf("Got %v, wanted a checksum mismatch", err)
+	}
+	err = copier.Upload(local, `C:\other.bin`)
+	if err == nil || !strings.Contains(err.Error(), "Checksum mismatch uploading") {
+		t.Errorf("Got %v, wanted a checksum mismatch", err)
+	}
+}
+
+func TestDownloadMissing(t *testing.T) {
+	s, _ := fileHost()
+	defer s.Close()
+	dir, err := ioutil.TempDir("", "wsman")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = s.NewClient().NewFileCopier().Download(`C:\nothing.bin`, filepath.Join(dir, "x"))
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("Got %v downloading a missing file", err)
+	}
+}
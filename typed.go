@@ -0,0 +1,68 @@
+package wsman
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"github.com/VictorLowther/wsman/cim"
+)
+
+// These work like Options, Selectors, Parameters, and Values, except
+// that they take typed values from the cim package, which are encoded
+// the way DSP0230 says they should be:
+//
+//    msg.TypedParameter("RequestedState", cim.Uint16(2)).
+//        TypedParameter("TimeoutPeriod", cim.Interval(5*time.Minute)).
+//        TypedParameter("Targets", cim.Array{cim.Ref{Reference: epr1}, cim.Ref{Reference: epr2}})
+//
+// Passing a nil cim.Value sends xsi:nil.
+
+// TypedOption adds an option with a typed value.
+func (m *Message) TypedOption(name string, v cim.Value) *Message {
+	if _, ok := v.(cim.Array); ok {
+		panic("Options cannot be arrays")
+	}
+	return m.AddOption(cim.Encode(m.MakeOption(name), v))
+}
+
+// TypedSelector adds a selector with a typed value.  References are
+// wrapped in a wsa:EndpointReference, as DSP0226 wants.
+func (m *Message) TypedSelector(name string, v cim.Value) *Message {
+	sel := m.MakeSelector(name)
+	switch val := v.(type) {
+	case cim.Array:
+		panic("Selectors cannot be arrays")
+	case cim.Ref:
+		sel.AddChild(val.Element("EndpointReference", NS_WSA))
+	default:
+		cim.Encode(sel, v)
+	}
+	return m.AddSelector(sel)
+}
+
+// TypedParameter adds a typed parameter to an Invoke message.  Arrays
+// add one element for each of their values.
+func (m *Message) TypedParameter(name string, v cim.Value) *Message {
+	p := m.MakeParameter(name)
+	return m.AddParameter(cim.Elements(p.Name.Local, p.Name.Space, v)...)
+}
+
+// TypedValue adds a typed property value to a Put message.  Arrays add
+// one element for each of their values.
+func (m *Message) TypedValue(name string, v cim.Value) *Message {
+	p := m.MakeValue(name)
+	return m.AddValue(cim.Elements(p.Name.Local, p.Name.Space, v)...)
+}
@@ -0,0 +1,262 @@
+package wsman
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/search"
+	"github.com/VictorLowther/soap"
+)
+
+// XPATH_DIALECT is the XPath 1.0 dialect, for fragment transfer.
+const XPATH_DIALECT = "http://www.w3.org/TR/1999/REC-xpath-19991116"
+
+// Fragment sets the FragmentTransfer header of the message, so that a
+// Get or Put works on just the part of the instance that the XPath
+// expression path picks out, such as a single property name.  The
+// body of a fragment Put must be a wsman:XmlFragment.
+func (m *Message) Fragment(path string) *Message {
+	m.SetHeader(soap.MuElemC("FragmentTransfer", NS_WSMAN, path).Attr("Dialect", "", XPATH_DIALECT))
+	return m
+}
+
+// fragmentUnsupported reports whether a fault says the endpoint does
+// not do fragment transfer.
+func fragmentUnsupported(reply *Message) bool {
+	switch reply.FaultSubcode() {
+	case "UnsupportedFeature", "FragmentDialectNotSupported", "CannotProcessFilter":
+		return true
+	}
+	code := reply.faultPart("Code", "Value")
+	return code != nil && strings.HasSuffix(strings.TrimSpace(string(code.Content)), "MustUnderstand")
+}
+
+// SetProperty sets the named property of instance to value, adding the
+// property if the instance does not have it.  Use it from the function
+// passed to Update.
+func SetProperty(instance *dom.Element, name, value string) {
+	prop := search.First(search.Tag(name, instance.Name.Space), instance.Children())
+	if prop == nil {
+		instance.AddChild(dom.ElemC(name, instance.Name.Space, value))
+		return
+	}
+	attrs := prop.Attributes[:0]
+	for _, attr := range prop.Attributes {
+		if attr.Name.Local != "nil" || attr.Name.Space != NS_XSI {
+			attrs = append(attrs, attr)
+		}
+	}
+	prop.Attributes = attrs
+	prop.Content = []byte(value)
+}
+
+// UpdateError is returned by Update and UpdateProperties when the
+// endpoint took the Put, but replied with properties that do not have
+// the values that were sent.  Applied and Ignored list the changed
+// properties by name.
+type UpdateError struct {
+	Applied, Ignored []string
+}
+
+func (e *UpdateError) Error() string {
+	return fmt.Sprintf("Endpoint did not change %s", strings.Join(e.Ignored, ", "))
+}
+
+// propertyValues returns the content of each of props that is a
+// value of the named property.
+func propertyValues(props []*dom.Element, name string) []string {
+	res := []string{}
+	for _, prop := range props {
+		if prop.Name.Local == name {
+			res = append(res, strings.TrimSpace(string(prop.Content)))
+		}
+	}
+	return res
+}
+
+// checkApplied compares the named properties in sent with the ones
+// in the reply to a Put, if it has any.
+func checkApplied(names []string, sent []*dom.Element, reply *Message) error {
+	body := reply.Body()
+	if len(body) == 0 {
+		return nil
+	}
+	got := body[0].Children()
+	res := &UpdateError{}
+	for _, name := range names {
+		if reflect.DeepEqual(propertyValues(sent, name), propertyValues(got, name)) {
+			res.Applied = append(res.Applied, name)
+		} else {
+			res.Ignored = append(res.Ignored, name)
+		}
+	}
+	if len(res.Ignored) == 0 {
+		return nil
+	}
+	return res
+}
+
+// putFragment Puts props, which are the values of the named
+// properties, in a single fragment Put.
+func (c *Client) putFragment(resource string, selectors []string, names []string, props []*dom.Element) (*Message, error) {
+	put := c.Put(resource).Selectors(selectors...).Fragment(strings.Join(names, " | "))
+	frag := dom.Elem("XmlFragment", NS_WSMAN)
+	frag.AddChildren(props...)
+	put.SetBody(frag)
+	return put.Send()
+}
+
+// properties returns the rendering of each property of instance by
+// name, to find out which ones have changed.
+func properties(instance *dom.Element) map[string]string {
+	res := map[string]string{}
+	for _, prop := range instance.Children() {
+		res[prop.Name.Local] += prop.String()
+	}
+	return res
+}
+
+// Update changes a single instance of resource, picked by selectors
+// (name/value pairs like Message.Selectors takes).  It Gets the
+// instance and calls fn to change it.  If the endpoint supports
+// fragment transfer, the changed properties are then sent in a single
+// fragment Put.  Otherwise the whole instance is Put back, so that
+// endpoints which null out properties missing from a Put leave the
+// rest of the instance alone.  Either way the change is made by one
+// Put, and nothing is sent if fn changed nothing.  It returns the
+// instance as changed, or as the endpoint replied to a full Put with
+// it.  If the reply shows that some changes were not made, the error
+// is an *UpdateError.
+func (c *Client) Update(resource string, selectors []string, fn func(instance *dom.Element) error) (*dom.Element, error) {
+	return c.update(resource, selectors, fn, true)
+}
+
+// update is Update, with fragment transfer only tried if fragments is
+// true.
+func (c *Client) update(resource string, selectors []string, fn func(instance *dom.Element) error, fragments bool) (*dom.Element, error) {
+	reply, err := c.Get(resource).Selectors(selectors...).Send()
+	if err != nil {
+		return nil, err
+	}
+	instance, err := reply.GetItem()
+	if err != nil {
+		return nil, err
+	}
+	before := properties(instance)
+	if err := fn(instance); err != nil {
+		return nil, err
+	}
+	after := properties(instance)
+	names := []string{}
+	removed := false
+	for name, prop := range before {
+		if after[name] != prop {
+			names = append(names, name)
+			removed = removed || after[name] == ""
+		}
+	}
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return instance, nil
+	}
+	sort.Strings(names)
+	changed := []*dom.Element{}
+	for _, prop := range instance.Children() {
+		for _, name := range names {
+			if prop.Name.Local == name {
+				changed = append(changed, prop)
+			}
+		}
+	}
+	// A fragment cannot say that a property is gone.
+	if fragments && !removed {
+		frag := make([]*dom.Element, len(changed))
+		for i, prop := range changed {
+			frag[i] = copyElement(prop)
+		}
+		reply, err = c.putFragment(resource, selectors, names, frag)
+		if err == nil {
+			return instance, checkApplied(names, changed, reply)
+		}
+		if reply == nil || !fragmentUnsupported(reply) {
+			return nil, err
+		}
+	}
+	put := c.Put(resource).Selectors(selectors...)
+	put.SetBody(instance)
+	if reply, err = put.Send(); err != nil {
+		return nil, err
+	}
+	err = checkApplied(names, changed, reply)
+	if body := reply.Body(); len(body) > 0 {
+		return body[0], err
+	}
+	return instance, err
+}
+
+// copyElement makes a deep copy of e, so that it can go in another
+// message without being taken out of e's parent.
+func copyElement(e *dom.Element) *dom.Element {
+	res := dom.Elem(e.Name.Local, e.Name.Space)
+	res.Attributes = append(res.Attributes, e.Attributes...)
+	res.Content = append([]byte{}, e.Content...)
+	for _, child := range e.Children() {
+		res.AddChild(copyElement(child))
+	}
+	return res
+}
+
+// UpdateProperties sets properties of a single instance of resource.
+// If the endpoint supports fragment transfer, they are all sent in a
+// single fragment Put, and the rest of the instance is never sent.
+// Otherwise it falls back to Update.  Either way the properties are
+// changed by one Put, so a fault means that none of them were.  If the
+// reply shows that only some were, the error is an *UpdateError.
+func (c *Client) UpdateProperties(resource string, selectors []string, props map[string]string) error {
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	elems := make([]*dom.Element, len(names))
+	for i, name := range names {
+		elems[i] = dom.ElemC(name, resource, props[name])
+	}
+	reply, err := c.putFragment(resource, selectors, names, elems)
+	if err == nil {
+		return checkApplied(names, elems, reply)
+	}
+	if reply == nil || !fragmentUnsupported(reply) {
+		return err
+	}
+	_, err = c.update(resource, selectors, func(instance *dom.Element) error {
+		for _, name := range names {
+			SetProperty(instance, name, props[name])
+		}
+		return nil
+	}, false)
+	return err
+}
@@ -0,0 +1,228 @@
+package wsman_test
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/search"
+	"github.com/VictorLowther/wsman"
+	"github.com/VictorLowther/wsman/wsmantest"
+)
+
+// fanRepository serves a single fan from a Repository.
+func fanRepository(t *testing.T, fragments bool) (*wsmantest.Server, *wsmantest.Repository) {
+	repo := wsmantest.NewRepository()
+	repo.SetKeys(fanURI, "DeviceID")
+	repo.NoFragmentTransfer = !fragments
+	_, err := repo.Add(fanURI, dom.Elem("CIM_Fan", fanURI).AddChild(
+		dom.ElemC("Caption", fanURI, "Cooling")).AddChild(
+		dom.ElemC("DesiredSpeed", fanURI, "100")).AddChild(
+		dom.ElemC("DeviceID", fanURI, "Fan.1")).AddChild(
+		dom.ElemC("ElementName", fanURI, "Fan 1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := wsmantest.NewServer()
+	repo.Serve(s)
+	return s, repo
+}
+
+// fanProperties renders the stored fan as name=value pairs.
+func fanProperties(repo *wsmantest.Repository) string {
+	props := []string{}
+	for _, prop := range repo.Instances(fanURI)[0].Element.Children() {
+		props = append(props, fmt.Sprintf("%s=%s", prop.Name.Local, prop.Content))
+	}
+	return strings.Join(props, " ")
+}
+
+// sent lists the action of each request s got, with the fragment of
+// the ones that used fragment transfer.
+func sent(s *wsmantest.Server) []string {
+	res := []string{}
+	for _, req := range s.Requests() {
+		action := req.Action[strings.LastIndex(req.Action, "/")+1:]
+		if frag := search.First(search.Tag("FragmentTransfer", wsman.NS_WSMAN), req.AllHeaderElements()); frag != nil {
+			action += "(" + string(frag.Content) + ")"
+		}
+		res = append(res, action)
+	}
+	return res
+}
+
+func TestUpdatePropertiesFragment(t *testing.T) {
+	s, repo := fanRepository(t, true)
+	defer s.Close()
+	err := s.NewClient().UpdateProperties(fanURI, []string{"DeviceID", "Fan.1"},
+		map[string]string{"ElementName": "Front", "DesiredSpeed": "200"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sent(s), []string{"Put(DesiredSpeed | ElementName)"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Sent %v, wanted %v", got, want)
+	}
+	if got, want := fanProperties(repo), "Caption=Cooling DesiredSpeed=200 DeviceID=Fan.1 ElementName=Front"; got != want {
+		t.Errorf("Fan is %s, wanted %s", got, want)
+	}
+}
+
+func TestUpdatePropertiesFallback(t *testing.T) {
+	s, repo := fanRepository(t, false)
+	defer s.Close()
+	err := s.NewClient().UpdateProperties(fanURI, []string{"DeviceID", "Fan.1"},
+		map[string]string{"ElementName": "Front", "OtherIdentifyingInfo": "Left"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sent(s), []string{"Put(ElementName | OtherIdentifyingInfo)", "Get", "Put"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Sent %v, wanted %v", got, want)
+	}
+	if got, want := fanProperties(repo), "Caption=Cooling DesiredSpeed=100 DeviceID=Fan.1 ElementName=Front OtherIdentifyingInfo=Left"; got != want {
+		t.Errorf("Fan is %s, wanted %s", got, want)
+	}
+}
+
+func TestUpdatePropertiesFault(t *testing.T) {
+	s, repo := fanRepository(t, true)
+	defer s.Close()
+	s.Inject("", wsman.PUT, 1, wsmantest.SendFault(wsmantest.InternalError("Fan is busy")))
+	err := s.NewClient().UpdateProperties(fanURI, []string{"DeviceID", "Fan.1"},
+		map[string]string{"ElementName": "Front", "DesiredSpeed": "200"})
+	if err == nil || !strings.Contains(err.Error(), "Fan is busy") {
+		t.Errorf("Got %v, wanted the fault", err)
+	}
+	// Nothing was changed, so there is nothing to fall back from.
+	if got := sent(s); len(got) != 1 {
+		t.Errorf("Sent %v after the fault", got)
+	}
+	if got, want := fanProperties(repo), "Caption=Cooling DesiredSpeed=100 DeviceID=Fan.1 ElementName=Fan 1"; got != want {
+		t.Errorf("Fan is %s, wanted %s", got, want)
+	}
+}
+
+func TestUpdatePartial(t *testing.T) {
+	s := wsmantest.NewServer()
+	defer s.Close()
+	// This fan cannot change speed, and says so by replying with
+	// the speed it still has.
+	s.HandlePut(fanURI, func(req *wsmantest.Request) (*dom.Element, error) {
+		frag := dom.Elem("XmlFragment", wsman.NS_WSMAN)
+		frag.AddChild(dom.ElemC("DesiredSpeed", fanURI, "100"))
+		frag.AddChild(dom.ElemC("ElementName", fanURI, "Front"))
+		return frag, nil
+	})
+	err := s.NewClient().UpdateProperties(fanURI, []string{"DeviceID", "Fan.1"},
+		map[string]string{"ElementName": "Front", "DesiredSpeed": "200"})
+	uerr, ok := err.(*wsman.UpdateError)
+	if !ok {
+		t.Fatalf("Got %v, wanted an UpdateError", err)
+	}
+	if !reflect.DeepEqual(uerr.Applied, []string{"ElementName"}) || !reflect.DeepEqual(uerr.Ignored, []string{"DesiredSpeed"}) {
+		t.Errorf("Got applied %v, ignored %v", uerr.Applied, uerr.Ignored)
+	}
+	if !strings.Contains(err.Error(), "DesiredSpeed") {
+		t.Errorf("Error %q does not say what was not changed", err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	for _, fragments := range []bool{true, false} {
+		s, repo := fanRepository(t, fragments)
+		client := s.NewClient()
+		inst, err := client.Update(fanURI, []string{"DeviceID", "Fan.1"}, func(instance *dom.Element) error {
+			wsman.SetProperty(instance, "ElementName", "Front")
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"Get", "Put(ElementName)"}
+		if !fragments {
+			want = []string{"Get", "Put(ElementName)", "Put"}
+		}
+		if got := sent(s); !reflect.DeepEqual(got, want) {
+			t.Errorf("Sent %v, wanted %v", got, want)
+		}
+		if got, want := fanProperties(repo), "Caption=Cooling DesiredSpeed=100 DeviceID=Fan.1 ElementName=Front"; got != want {
+			t.Errorf("Fan is %s, wanted %s", got, want)
+		}
+		if got := search.First(search.Tag("ElementName", fanURI), inst.Children()); got == nil || string(got.Content) != "Front" {
+			t.Errorf("Update returned %v", inst)
+		}
+		s.Close()
+	}
+}
+
+func TestUpdateUnchanged(t *testing.T) {
+	s, _ := fanRepository(t, true)
+	defer s.Close()
+	_, err := s.NewClient().Update(fanURI, []string{"DeviceID", "Fan.1"}, func(instance *dom.Element) error {
+		wsman.SetProperty(instance, "ElementName", "Fan 1")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sent(s); !reflect.DeepEqual(got, []string{"Get"}) {
+		t.Errorf("Sent %v for no change", got)
+	}
+}
+
+func TestUpdateRemove(t *testing.T) {
+	s, repo := fanRepository(t, true)
+	defer s.Close()
+	_, err := s.NewClient().Update(fanURI, []string{"DeviceID", "Fan.1"}, func(instance *dom.Element) error {
+		keep := []*dom.Element{}
+		for _, prop := range instance.Children() {
+			if prop.Name.Local != "Caption" {
+				keep = append(keep, prop)
+			}
+		}
+		*instance = *dom.Elem(instance.Name.Local, instance.Name.Space)
+		instance.AddChildren(keep...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A fragment cannot remove a property.
+	if got := sent(s); !reflect.DeepEqual(got, []string{"Get", "Put"}) {
+		t.Errorf("Sent %v, wanted a full Put", got)
+	}
+	if got, want := fanProperties(repo), "DesiredSpeed=100 DeviceID=Fan.1 ElementName=Fan 1"; got != want {
+		t.Errorf("Fan is %s, wanted %s", got, want)
+	}
+}
+
+func TestUpdateFnError(t *testing.T) {
+	s, _ := fanRepository(t, true)
+	defer s.Close()
+	_, err := s.NewClient().Update(fanURI, []string{"DeviceID", "Fan.1"}, func(instance *dom.Element) error {
+		return fmt.Errorf("Changed my mind")
+	})
+	if err == nil || err.Error() != "Changed my mind" {
+		t.Errorf("Got %v", err)
+	}
+	if got := sent(s); !reflect.DeepEqual(got, []string{"Get"}) {
+		t.Errorf("Sent %v after fn failed", got)
+	}
+}
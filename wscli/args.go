@@ -0,0 +1,151 @@
+package main
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/wsman"
+	"github.com/VictorLowther/wsman/cim"
+)
+
+// arg is a single Name=value from -s, -o, or -x.  Values are checked
+// against their type when one is given, and are strings otherwise.
+type arg struct {
+	Name  string
+	Value cim.Value
+}
+
+// argList collects every use of a repeatable flag.  Nothing is parsed
+// until parse is called, so that bad arguments exit with argError.
+type argList []string
+
+func (a *argList) String() string {
+	return strings.Join(*a, " ")
+}
+
+func (a *argList) Set(s string) error {
+	*a = append(*a, s)
+	return nil
+}
+
+// typedArg matches Name=value and Name:type=value.
+var typedArg = regexp.MustCompile(`^\s*([A-Za-z_][\w.-]*)(?::(\w+))?=(.*)$`)
+
+// argTypes are the types Name:type=value understands, on top of the
+// CIM ones.
+var argTypes = map[string]bool{"nil": true, "epr": true}
+
+func init() {
+	for _, typ := range cim.Types {
+		argTypes[typ] = true
+	}
+}
+
+// readEPR reads an endpoint reference from an XML file.
+func readEPR(name string) (*wsman.EndpointReference, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	doc, err := dom.Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %v", name, err)
+	}
+	return wsman.ParseEPR(doc.Root())
+}
+
+// parseArg parses Name=value, Name:type=value, or Name:epr=file.xml for
+// an EPR read from a file, which may also be given as @file.xml.
+// Untyped values that start with @ are just strings.  Values may be
+// quoted Go style to include characters the shell or this parser would
+// otherwise eat.
+func parseArg(s string, m []string) (arg, error) {
+	name, typ, val := m[1], m[2], strings.TrimSpace(m[3])
+	res := arg{Name: name}
+	if strings.HasPrefix(val, `"`) {
+		unquoted, err := strconv.Unquote(val)
+		if err != nil {
+			return res, fmt.Errorf("Bad quoting in %s: %v", s, err)
+		}
+		val = unquoted
+	}
+	switch {
+	case typ == "nil":
+		res.Value = cim.Null("")
+	case typ == "epr":
+		epr, err := readEPR(strings.TrimPrefix(val, "@"))
+		if err != nil {
+			return res, err
+		}
+		res.Value = cim.Ref{Reference: epr}
+	case typ == "":
+		res.Value = cim.String(val)
+	default:
+		value, err := cim.Parse(typ, val)
+		if err != nil {
+			return res, fmt.Errorf("%s: %v", name, err)
+		}
+		res.Value = value
+	}
+	return res, nil
+}
+
+// legacyArgs parses the old comma separated list of name:value pairs.
+func legacyArgs(s string) ([]arg, error) {
+	res := []arg{}
+	for _, segment := range strings.Split(s, ",") {
+		parts := strings.SplitN(segment, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Segment %s does not have 2 : seperated elements!", segment)
+		}
+		res = append(res, arg{Name: strings.TrimSpace(parts[0]), Value: cim.String(strings.TrimSpace(parts[1]))})
+	}
+	return res, nil
+}
+
+// parse parses every use of the flag.  Each one is either a single
+// Name=value, or the old "name: value, name: value" syntax.
+// Repeating a name makes an array.
+func (a argList) parse() ([]arg, error) {
+	res := []arg{}
+	for _, s := range a {
+		if s == "" {
+			continue
+		}
+		if m := typedArg.FindStringSubmatch(s); m != nil && (m[2] == "" || argTypes[m[2]]) {
+			parsed, err := parseArg(s, m)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, parsed)
+			continue
+		}
+		legacy, err := legacyArgs(s)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, legacy...)
+	}
+	return res, nil
+}
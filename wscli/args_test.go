@@ -0,0 +1,126 @@
+package main
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/wsman"
+	"github.com/VictorLowther/wsman/cim"
+)
+
+func TestTypedArg(t *testing.T) {
+	for _, test := range []struct {
+		s    string
+		want []string
+	}{
+		{"Name=value", []string{"Name", "", "value"}},
+		{" Name=a=b", []string{"Name", "", "a=b"}},
+		{"Name:uint16=4", []string{"Name", "uint16", "4"}},
+		{"Name:nil=", []string{"Name", "nil", ""}},
+		{"_x.y-z=", []string{"_x.y-z", "", ""}},
+		{"Name: value", nil},
+		{"Name:value, Other:x", nil},
+		{"1Name=x", nil},
+		{"=x", nil},
+	} {
+		m := typedArg.FindStringSubmatch(test.s)
+		if test.want == nil {
+			if m != nil {
+				t.Errorf("%q matched as %q", test.s, m[1:])
+			}
+			continue
+		}
+		if m == nil || !reflect.DeepEqual(m[1:], test.want) {
+			t.Errorf("%q matched as %q, wanted %q", test.s, m, test.want)
+		}
+	}
+}
+
+// eprFile writes an EPR to a file, and returns its name.
+func eprFile(t *testing.T, epr *wsman.EndpointReference) string {
+	f, err := ioutil.TempFile("", "epr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(epr.Element("EndpointReference", wsman.NS_WSA).String()); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestParseArgs(t *testing.T) {
+	epr := &wsman.EndpointReference{
+		Address:     wsman.ANONYMOUS,
+		ResourceURI: "http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_Fan",
+		Selectors:   []wsman.Selector{{Name: "DeviceID", Value: "Fan.1"}},
+	}
+	file := eprFile(t, epr)
+	defer os.Remove(file)
+	for _, test := range []struct {
+		args argList
+		want []arg
+	}{
+		{argList{"Name=value", "", "Speed:uint16=4"}, []arg{{"Name", cim.String("value")}, {"Speed", cim.Uint16(4)}}},
+		{argList{"On:boolean=TRUE"}, []arg{{"On", cim.Boolean(true)}}},
+		{argList{`Path="C:\\a, b: c"`}, []arg{{"Path", cim.String(`C:\a, b: c`)}}},
+		{argList{"Name:nil="}, []arg{{"Name", cim.Null("")}}},
+		{argList{"Name:nil=ignored"}, []arg{{"Name", cim.Null("")}}},
+		// Only :epr reads files.
+		{argList{"Mail=@home"}, []arg{{"Mail", cim.String("@home")}}},
+		{argList{"Target:epr=" + file}, []arg{{"Target", cim.Ref{Reference: epr}}}},
+		{argList{"Target:epr=@" + file}, []arg{{"Target", cim.Ref{Reference: epr}}}},
+		// Anything else is the old comma separated form.
+		{argList{"Name: pwrmgtsvc:1, PowerState: 2"}, []arg{{"Name", cim.String("pwrmgtsvc:1")}, {"PowerState", cim.String("2")}}},
+		{argList{"Name:bogus=1"}, []arg{{"Name", cim.String("bogus=1")}}},
+		{argList{"A=1", "B: 2"}, []arg{{"A", cim.String("1")}, {"B", cim.String("2")}}},
+	} {
+		got, err := test.args.parse()
+		if err != nil {
+			t.Errorf("%q: %v", test.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q parsed as %#v, wanted %#v", test.args, got, test.want)
+		}
+	}
+}
+
+func TestParseArgErrors(t *testing.T) {
+	for _, test := range []struct {
+		arg, want string
+	}{
+		{"Speed:uint8=256", "Speed"},
+		{"Speed:sint8=x", "Speed"},
+		{"On:boolean=maybe", "On"},
+		{"When:datetime=P", "When"},
+		{`Name="unterminated`, "Bad quoting"},
+		{"Target:epr=/no/such/file.xml", "no such file"},
+		{"novalue", "does not have 2 : seperated elements"},
+		{"A: 1, novalue", "novalue"},
+	} {
+		_, err := argList{test.arg}.parse()
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%q: got %v, wanted an error about %s", test.arg, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// TLSConfig is how a profile wants the endpoint's certificate checked.
+type TLSConfig struct {
+	// Insecure skips checking the certificate altogether.
+	Insecure bool
+	// CAFile is a PEM file of the CAs to trust instead of the system
+	// ones.
+	CAFile string
+	// CertFile and KeyFile are a client certificate to present.
+	CertFile, KeyFile string
+	// ServerName overrides the name checked against the certificate.
+	ServerName string
+}
+
+// Profile holds the settings for a single host.  Passwords are never
+// kept in the config file, only where to get them from.
+type Profile struct {
+	Endpoint, Username string
+	Digest             bool
+	// PasswordEnv is an environment variable holding the password.
+	PasswordEnv string
+	// PasswordFile is a file holding the password.
+	PasswordFile string
+	// PasswordCommand is run with sh -c, and prints the password.
+	// WSCLI_ENDPOINT and WSCLI_USERNAME are set in its environment.
+	PasswordCommand string
+	// PromptPassword asks for the password on the terminal when none
+	// of the above have it.  Otherwise no password is sent.
+	PromptPassword bool
+	TLS            *TLSConfig
+}
+
+// Config is the wscli config file.
+type Config struct {
+	Profiles map[string]*Profile
+}
+
+var configFile, profileName string
+var profile = &Profile{}
+
+func init() {
+	flag.StringVar(&configFile, "config", defaultConfigFile(), "The config file holding profiles")
+	flag.StringVar(&profileName, "profile", "", "The profile in the config file to take settings from")
+	flag.StringVar(&profile.PasswordEnv, "password-env", "", "The environment variable to read the password from")
+	flag.StringVar(&profile.PasswordFile, "password-file", "", "The file to read the password from")
+	flag.StringVar(&profile.PasswordCommand, "password-command", "", "A command that prints the password")
+	flag.BoolVar(&profile.PromptPassword, "prompt-password", false, "Ask for the password on the terminal if no other source has it")
+}
+
+func defaultConfigFile() string {
+	home := os.Getenv("HOME")
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".wscli.json")
+}
+
+// flagsSet returns the names of the flags given on the command line.
+func flagsSet() map[string]bool {
+	res := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { res[f.Name] = true })
+	return res
+}
+
+// findProfile looks up the named profile in the config file.
+func findProfile(name string) (*Profile, error) {
+	buf, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal(buf, cfg); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %v", configFile, err)
+	}
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("No profile named %s in %s", name, configFile)
+	}
+	return p, nil
+}
+
+// loadProfile fills in the settings from the profile chosen with
+// -profile that were not given on the command line.
+func loadProfile() error {
+	if profileName == "" {
+		return nil
+	}
+	p, err := findProfile(profileName)
+	if err != nil {
+		return err
+	}
+	set := flagsSet()
+	if !set["e"] {
+		Endpoint = p.Endpoint
+	}
+	if !set["u"] {
+		Username = p.Username
+	}
+	if !set["d"] {
+		useDigest = p.Digest
+	}
+	if !set["password-env"] {
+		profile.PasswordEnv = p.PasswordEnv
+	}
+	if !set["password-file"] {
+		profile.PasswordFile = p.PasswordFile
+	}
+	if !set["password-command"] {
+		profile.PasswordCommand = p.PasswordCommand
+	}
+	if !set["prompt-password"] {
+		profile.PromptPassword = p.PromptPassword
+	}
+	profile.TLS = p.TLS
+	return nil
+}
+
+// promptPassword asks for the password on the terminal without
+// echoing it.
+func promptPassword() (string, error) {
+	if !isTerminal(os.Stdin) {
+		return "", fmt.Errorf("No password given for %s, and stdin is not a terminal to ask for one", Username)
+	}
+	fmt.Fprintf(os.Stderr, "Password for %s at %s: ", Username, Endpoint)
+	saved, err := stty("-g")
+	if err != nil {
+		return "", err
+	}
+	stty("-echo")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	stty(saved)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// lookupPassword gets the password from the environment, file, or
+// command p names, in that order.  It returns an empty password if p
+// names none of them.
+func lookupPassword(p *Profile, endpoint, username string) (string, error) {
+	switch {
+	case p.PasswordEnv != "":
+		password := os.Getenv(p.PasswordEnv)
+		if password == "" {
+			return "", fmt.Errorf("%s is not set", p.PasswordEnv)
+		}
+		return password, nil
+	case p.PasswordFile != "":
+		buf, err := ioutil.ReadFile(p.PasswordFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(buf), "\r\n"), nil
+	case p.PasswordCommand != "":
+		cmd := exec.Command("sh", "-c", p.PasswordCommand)
+		cmd.Env = append(os.Environ(), "WSCLI_ENDPOINT="+endpoint, "WSCLI_USERNAME="+username)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("Password command failed: %v", err)
+		}
+		return strings.TrimRight(string(out), "\r\n"), nil
+	}
+	return "", nil
+}
+
+// loadPassword finds the password when it was not given with -p,
+// prompting for it if nothing else has it and the profile asks for a
+// prompt.  -dry-run needs none.
+func loadPassword() error {
+	if Password != "" || Username == "" || dryRun {
+		return nil
+	}
+	var err error
+	if Password, err = lookupPassword(profile, Endpoint, Username); err != nil || Password != "" {
+		return err
+	}
+	if !profile.PromptPassword {
+		return nil
+	}
+	Password, err = promptPassword()
+	return err
+}
+
+// configure applies the chosen profile and finds the password.
+func configure() error {
+	if err := loadProfile(); err != nil {
+		return err
+	}
+	return loadPassword()
+}
+
+// transport makes the http.Transport for TLS settings from a profile,
+// or returns nil if there are none.
+func transport(t *TLSConfig) (*http.Transport, error) {
+	if t == nil {
+		return nil, nil
+	}
+	cfg := &tls.Config{
+		InsecureSkipVerify: t.Insecure,
+		ServerName:         t.ServerName,
+	}
+	if t.CAFile != "" {
+		pem, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %s", t.CAFile)
+		}
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return &http.Transport{TLSClientConfig: cfg}, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfig = `{"Profiles": {
+  "idrac": {"Endpoint": "https://idrac/wsman", "Username": "root", "Digest": true,
+            "PasswordFile": "PASSWORD_FILE", "TLS": {"Insecure": true}},
+  "winrm": {"Endpoint": "http://win:5985/wsman", "Username": "Administrator",
+            "PasswordEnv": "WSCLI_TEST_PASSWORD"}
+}}`
+
+// commandLine parses args as the command line, with a config file in
+// dir, and returns a func that puts every setting back.
+func commandLine(t *testing.T, dir string, args ...string) func() {
+	config := filepath.Join(dir, "wscli.json")
+	pwFile := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(config, []byte(strings.Replace(testConfig, "PASSWORD_FILE", pwFile, 1)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(pwFile, []byte("calvin\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	oldFlags := flag.CommandLine
+	oldEndpoint, oldUsername, oldPassword, oldDigest := Endpoint, Username, Password, useDigest
+	oldConfig, oldProfileName, oldProfile := configFile, profileName, *profile
+	// flag has no way to forget that a flag was set, so parse into a
+	// fresh FlagSet holding the same flags.
+	fs := flag.NewFlagSet("wscli", flag.ContinueOnError)
+	oldFlags.VisitAll(func(f *flag.Flag) { fs.Var(f.Value, f.Name, f.Usage) })
+	flag.CommandLine = fs
+	restore := func() {
+		flag.CommandLine = oldFlags
+		Endpoint, Username, Password, useDigest = oldEndpoint, oldUsername, oldPassword, oldDigest
+		configFile, profileName, *profile = oldConfig, oldProfileName, oldProfile
+	}
+	if err := fs.Parse(append([]string{"-config", config}, args...)); err != nil {
+		restore()
+		t.Fatal(err)
+	}
+	return restore
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "wscli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestProfile(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	defer commandLine(t, dir, "-profile", "idrac")()
+	if err := configure(); err != nil {
+		t.Fatal(err)
+	}
+	if Endpoint != "https://idrac/wsman" || Username != "root" || !useDigest {
+		t.Errorf("Got %s as %s, digest %v", Endpoint, Username, useDigest)
+	}
+	if Password != "calvin" {
+		t.Errorf("Got password %q from the password file", Password)
+	}
+	if profile.TLS == nil || !profile.TLS.Insecure {
+		t.Errorf("Got TLS settings %+v", profile.TLS)
+	}
+}
+
+func TestProfileFlagsWin(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	other := filepath.Join(dir, "other")
+	ioutil.WriteFile(other, []byte("hunter2"), 0600)
+	defer commandLine(t, dir, "-profile", "idrac", "-e", "https://other/wsman", "-u", "admin",
+		"-d=false", "-password-file", other)()
+	if err := configure(); err != nil {
+		t.Fatal(err)
+	}
+	if Endpoint != "https://other/wsman" || Username != "admin" || useDigest {
+		t.Errorf("Got %s as %s, digest %v", Endpoint, Username, useDigest)
+	}
+	if Password != "hunter2" {
+		t.Errorf("Got password %q, wanted the one from -password-file", Password)
+	}
+}
+
+func TestProfileMissing(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	defer commandLine(t, dir, "-profile", "ilo")()
+	err := configure()
+	if err == nil || !strings.Contains(err.Error(), "No profile named ilo in "+configFile) {
+		t.Errorf("Got %v, wanted a missing profile error", err)
+	}
+
+	configFile = filepath.Join(dir, "nothing.json")
+	if err := configure(); err == nil || !os.IsNotExist(err) {
+		t.Errorf("Got %v for a missing config file", err)
+	}
+	configFile = filepath.Join(dir, "password")
+	if err := configure(); err == nil || !strings.Contains(err.Error(), "Failed to parse") {
+		t.Errorf("Got %v for a config file that is not JSON", err)
+	}
+}
+
+func TestNoProfile(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	defer commandLine(t, dir, "-e", "https://bmc/wsman", "-u", "root", "-p", "secret")()
+	if err := configure(); err != nil {
+		t.Fatal(err)
+	}
+	if Endpoint != "https://bmc/wsman" || Username != "root" || Password != "secret" {
+		t.Errorf("Got %s as %s/%s", Endpoint, Username, Password)
+	}
+}
+
+func TestLookupPassword(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	pwFile := filepath.Join(dir, "password")
+	ioutil.WriteFile(pwFile, []byte("from file\r\n"), 0600)
+	oldEnv, hadEnv := os.LookupEnv("WSCLI_TEST_PASSWORD")
+	defer func() {
+		if hadEnv {
+			os.Setenv("WSCLI_TEST_PASSWORD", oldEnv)
+		} else {
+			os.Unsetenv("WSCLI_TEST_PASSWORD")
+		}
+	}()
+	os.Setenv("WSCLI_TEST_PASSWORD", "from env")
+
+	for _, test := range []struct {
+		p    Profile
+		want string
+	}{
+		{Profile{}, ""},
+		{Profile{PasswordEnv: "WSCLI_TEST_PASSWORD"}, "from env"},
+		{Profile{PasswordFile: pwFile}, "from file"},
+		{Profile{PasswordCommand: `echo "$WSCLI_USERNAME@$WSCLI_ENDPOINT"`}, "root@https://bmc/wsman"},
+		// The environment comes first, then the file.
+		{Profile{PasswordEnv: "WSCLI_TEST_PASSWORD", PasswordFile: pwFile, PasswordCommand: "echo x"}, "from env"},
+		{Profile{PasswordFile: pwFile, PasswordCommand: "echo x"}, "from file"},
+	} {
+		got, err := lookupPassword(&test.p, "https://bmc/wsman", "root")
+		if err != nil || got != test.want {
+			t.Errorf("%+v: got %q, %v, wanted %q", test.p, got, err, test.want)
+		}
+	}
+
+	for _, test := range []struct {
+		p    Profile
+		want string
+	}{
+		{Profile{PasswordEnv: "WSCLI_TEST_NO_SUCH_VARIABLE"}, "WSCLI_TEST_NO_SUCH_VARIABLE is not set"},
+		{Profile{PasswordFile: filepath.Join(dir, "missing")}, "no such file"},
+		{Profile{PasswordCommand: "exit 3"}, "Password command failed"},
+	} {
+		if got, err := lookupPassword(&test.p, "https://bmc/wsman", "root"); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%+v: got %q, %v, wanted an error about %s", test.p, got, err, test.want)
+		}
+	}
+}
+
+func TestLoadPasswordSkipped(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	// The profile's variable is not set, so looking would fail.
+	defer commandLine(t, dir, "-profile", "winrm", "-password-env", "WSCLI_TEST_NO_SUCH_VARIABLE")()
+	if err := loadProfile(); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadPassword(); err == nil {
+		t.Errorf("Looked up a password from a variable that is not set")
+	}
+	Password = "given"
+	if err := loadPassword(); err != nil || Password != "given" {
+		t.Errorf("Got %q, %v with the password given", Password, err)
+	}
+	Password, dryRun = "", true
+	defer func() { dryRun = false }()
+	if err := loadPassword(); err != nil || Password != "" {
+		t.Errorf("Got %q, %v for a dry run", Password, err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// remotePrefix marks which argument to wscli cp is on the Windows host.
+const remotePrefix = "remote:"
+
+func init() {
+	subcommands["cp"] = cpCommand
+}
+
+// cpCommand copies a file to or from a Windows host.  Exactly one of
+// its two arguments must start with remote:
+func cpCommand(args []string) int {
+	if len(args) != 2 {
+		log.Printf("cp takes a source and a destination")
+		return argError
+	}
+	src, dst := args[0], args[1]
+	upload := strings.HasPrefix(dst, remotePrefix)
+	if upload == strings.HasPrefix(src, remotePrefix) {
+		log.Printf("Exactly one of the source and destination must start with %s", remotePrefix)
+		return argError
+	}
+	copier := makeClient().NewFileCopier()
+	copier.Progress = func(done, total int64) {
+		fmt.Fprintf(os.Stderr, "\r%d/%d bytes", done, total)
+	}
+	var err error
+	if upload {
+		err = copier.Upload(src, strings.TrimPrefix(dst, remotePrefix))
+	} else {
+		err = copier.Download(strings.TrimPrefix(src, remotePrefix), dst)
+	}
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		log.Println(err.Error())
+		return transportError
+	}
+	return 0
+}
@@ -0,0 +1,76 @@
+package main
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/VictorLowther/wsman"
+	"github.com/VictorLowther/wsman/wsmantest"
+)
+
+func TestCp(t *testing.T) {
+	files := wsmantest.NewFiles()
+	s := wsmantest.NewServer()
+	defer s.Close()
+	s.HandleShell(wsman.CMD_SHELL, files.Run)
+	oldEndpoint := Endpoint
+	defer func() { Endpoint = oldEndpoint }()
+	Endpoint = s.Endpoint()
+	dir, err := ioutil.TempDir("", "wscli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	local := filepath.Join(dir, "setup log.txt")
+	data := []byte("line 1\r\nline 2\r\n")
+	if err := ioutil.WriteFile(local, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	remote := `C:\Program Files\It's "here".txt`
+	if code := cpCommand([]string{local, "remote:" + remote}); code != 0 {
+		t.Fatalf("Upload exited %d", code)
+	}
+	if got, _ := files.Get(remote); !bytes.Equal(got, data) {
+		t.Errorf("Uploaded %q", got)
+	}
+	back := filepath.Join(dir, "back.txt")
+	if code := cpCommand([]string{"remote:" + remote, back}); code != 0 {
+		t.Fatalf("Download exited %d", code)
+	}
+	if got, _ := ioutil.ReadFile(back); !bytes.Equal(got, data) {
+		t.Errorf("Downloaded %q", got)
+	}
+	if code := cpCommand([]string{`remote:C:\missing.txt`, back}); code != transportError {
+		t.Errorf("Downloading a missing file exited %d", code)
+	}
+
+	for _, args := range [][]string{
+		{local},
+		{local, back},
+		{"remote:a", "remote:b"},
+	} {
+		if code := cpCommand(args); code != argError {
+			t.Errorf("cp %v exited %d, wanted %d", args, code, argError)
+		}
+	}
+}
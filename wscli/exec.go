@@ -0,0 +1,78 @@
+package main
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+func init() {
+	subcommands["exec"] = execCommand
+}
+
+// quoteArg quotes arguments the way cmd.exe expects, since the
+// command line is reassembled on the remote side.
+func quoteArg(arg string) string {
+	if arg == "" || strings.ContainsAny(arg, " \t") {
+		return `"` + strings.Replace(arg, `"`, `\"`, -1) + `"`
+	}
+	return arg
+}
+
+// execCommand runs a command in a remote cmd shell, wiring it up to
+// our stdin, stdout, and stderr.  It returns the exit code of the
+// remote command.
+func execCommand(args []string) int {
+	if len(args) == 0 {
+		log.Printf("exec requires a command to run after --")
+		return argError
+	}
+	for i := range args {
+		args[i] = quoteArg(args[i])
+	}
+	client := makeClient()
+	shell := client.NewCmdShell()
+	if err := shell.Open(); err != nil {
+		log.Printf("Failed to open remote shell: %v", err)
+		return transportError
+	}
+	cmd, err := shell.Command(args[0], args[1:]...)
+	if err != nil {
+		log.Printf("Failed to start %s: %v", args[0], err)
+		shell.Close()
+		return transportError
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		cmd.Terminate()
+		shell.Close()
+		os.Exit(interrupted)
+	}()
+	code, err := cmd.Run(os.Stdin, os.Stdout, os.Stderr)
+	shell.Close()
+	if err != nil {
+		log.Println(err.Error())
+		return transportError
+	}
+	return code
+}
@@ -0,0 +1,86 @@
+package main
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/wsman"
+	"github.com/VictorLowther/wsman/wsmantest"
+)
+
+func TestQuoteArg(t *testing.T) {
+	for _, test := range []struct{ arg, want string }{
+		{`dir`, `dir`},
+		{`C:\x`, `C:\x`},
+		{``, `""`},
+		{`a b`, `"a b"`},
+		{"a\tb", "\"a\tb\""},
+		{`say "hi" there`, `"say \"hi\" there"`},
+	} {
+		if got := quoteArg(test.arg); got != test.want {
+			t.Errorf("quoteArg(%q) = %q, wanted %q", test.arg, got, test.want)
+		}
+	}
+}
+
+// tempFile makes a file holding content, opened for reading.
+func tempFile(t *testing.T, content string) *os.File {
+	f, err := ioutil.TempFile("", "wscli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(f.Name())
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	f.Seek(0, 0)
+	return f
+}
+
+func TestExec(t *testing.T) {
+	s := wsmantest.NewServer()
+	defer s.Close()
+	s.HandleShell(wsman.CMD_SHELL, func(cmd *wsmantest.ShellCommand) ([]byte, []byte, int) {
+		return append([]byte(strings.Join(cmd.Arguments, "|")+":"), cmd.Input()...), nil, 2
+	})
+	oldEndpoint, oldStdin, oldStdout := Endpoint, os.Stdin, os.Stdout
+	defer func() { Endpoint, os.Stdin, os.Stdout = oldEndpoint, oldStdin, oldStdout }()
+	Endpoint = s.Endpoint()
+	os.Stdin = tempFile(t, "input")
+	out := tempFile(t, "")
+	os.Stdout = out
+
+	if code := execCommand([]string{"findstr", "a b", `"q"`}); code != 2 {
+		t.Errorf("Got exit code %d, wanted the remote one", code)
+	}
+	cmds := s.Commands()
+	if len(cmds) != 1 || cmds[0].Command != "findstr" {
+		t.Fatalf("Ran %#v", cmds)
+	}
+	out.Seek(0, 0)
+	got, _ := ioutil.ReadAll(out)
+	if want := `"a b"|"q":input`; string(got) != want {
+		t.Errorf("Got output %q, wanted %q", got, want)
+	}
+	if code := execCommand(nil); code != argError {
+		t.Errorf("Got %d running nothing, wanted %d", code, argError)
+	}
+}
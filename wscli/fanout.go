@@ -0,0 +1,293 @@
+package main
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hostPlaceholder is replaced with each host name in -e when running
+// against many hosts.
+const hostPlaceholder = "{host}"
+
+// maxHosts limits how big a -cidr range can be.
+const maxHosts = 65536
+
+var hostsFile, cidrRange string
+var parallel int
+var hostTimeout time.Duration
+
+func init() {
+	flag.StringVar(&hostsFile, "hosts", "", "A file of hosts to run the action on, one per line, each optionally followed by a profile name")
+	flag.StringVar(&cidrRange, "cidr", "", "A CIDR range of hosts to run the action on")
+	flag.IntVar(&parallel, "parallel", 10, "How many hosts to talk to at once with -hosts or -cidr")
+	flag.DurationVar(&hostTimeout, "host-timeout", 0, "How long to wait for each host with -hosts or -cidr.  0 waits as long as the action takes")
+}
+
+// host is a single host to run the action on.
+type host struct {
+	Name, Profile string
+}
+
+// hostResult is the outcome of running the action on a single host.
+type hostResult struct {
+	Host     string
+	Endpoint string
+	// Status is one of ok, fault, error, or timeout.
+	Status string
+	Error  string      `json:",omitempty"`
+	Result interface{} `json:",omitempty"`
+}
+
+func (r *hostResult) exitCode() int {
+	switch r.Status {
+	case "ok":
+		return 0
+	case "fault":
+		return soapFault
+	}
+	return transportError
+}
+
+func readHosts(name string) ([]host, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	res := []host{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		h := host{Name: fields[0]}
+		if len(fields) > 1 {
+			h.Profile = fields[1]
+		}
+		res = append(res, h)
+	}
+	return res, scanner.Err()
+}
+
+// cidrHosts lists the addresses in cidr.  The network and broadcast
+// addresses of IPv4 ranges are left out.
+func cidrHosts(cidr string) ([]host, error) {
+	ip, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	ones, bits := ipnet.Mask.Size()
+	if bits-ones > 16 {
+		return nil, fmt.Errorf("%s has more than %d addresses", cidr, maxHosts)
+	}
+	res := []host{}
+	for ip = ip.Mask(ipnet.Mask); ipnet.Contains(ip); {
+		res = append(res, host{Name: ip.String()})
+		next := make(net.IP, len(ip))
+		copy(next, ip)
+		for i := len(next) - 1; i >= 0; i-- {
+			if next[i]++; next[i] != 0 {
+				break
+			}
+		}
+		ip = next
+	}
+	if ipnet.IP.To4() != nil && bits-ones > 1 {
+		res = res[1 : len(res)-1]
+	}
+	for i := range res {
+		if strings.Contains(res[i].Name, ":") {
+			res[i].Name = "[" + res[i].Name + "]"
+		}
+	}
+	return res, nil
+}
+
+// runHost runs the action on a single host, with the settings from its
+// profile if it has one.  Every request to the host fails once
+// deadline passes, unless it is zero.
+func runHost(h host, deadline time.Time) *hostResult {
+	template, username, password, digest, tlsConfig := Endpoint, Username, Password, useDigest, profile.TLS
+	res := &hostResult{Host: h.Name, Status: "error"}
+	var p *Profile
+	if h.Profile != "" {
+		var err error
+		if p, err = findProfile(h.Profile); err != nil {
+			res.Error = err.Error()
+			return res
+		}
+		if p.Endpoint != "" {
+			template = p.Endpoint
+		}
+		if p.Username != "" {
+			username = p.Username
+		}
+		digest = p.Digest
+		if p.TLS != nil {
+			tlsConfig = p.TLS
+		}
+	}
+	res.Endpoint = strings.Replace(template, hostPlaceholder, h.Name, -1)
+	if p != nil {
+		pw, err := lookupPassword(p, res.Endpoint, username)
+		if err != nil {
+			res.Error = err.Error()
+			return res
+		}
+		if pw != "" {
+			password = pw
+		}
+	}
+	client, err := newClient(res.Endpoint, username, password, digest, tlsConfig, deadline)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	reply, err := runAction(client)
+	switch {
+	case reply != nil && reply.Fault() != nil:
+		res.Status = "fault"
+		res.Result = toValues(reply)
+	case err != nil:
+		res.Error = err.Error()
+	default:
+		res.Status = "ok"
+		res.Result = toValues(reply)
+	}
+	return res
+}
+
+// deadlineTransport fails requests that are still running at its
+// deadline.
+type deadlineTransport struct {
+	next     http.RoundTripper
+	deadline time.Time
+}
+
+func (t *deadlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithDeadline(req.Context(), t.deadline)
+	res, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	res.Body = &cancelBody{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+// cancelBody cancels the context of its request when it is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// runHostWithTimeout gives up on the host after -host-timeout.  The
+// deadline applies to every request runHost makes, so it returns soon
+// after and the host does not hold on to its -parallel slot.
+func runHostWithTimeout(h host) *hostResult {
+	if hostTimeout <= 0 {
+		return runHost(h, time.Time{})
+	}
+	deadline := time.Now().Add(hostTimeout)
+	res := runHost(h, deadline)
+	if res.Status != "ok" && !time.Now().Before(deadline) {
+		res.Status = "timeout"
+		res.Error = fmt.Sprintf("No result after %v: %s", hostTimeout, res.Error)
+	}
+	return res
+}
+
+// fanOut runs the action on every host from -hosts or -cidr, prints
+// the results of all of them as JSON, and returns the worst exit code
+// of any of them.
+func fanOut() int {
+	if dryRun {
+		log.Printf("-dry-run cannot be used with -hosts or -cidr")
+		return argError
+	}
+	hosts := []host{}
+	if hostsFile != "" {
+		found, err := readHosts(hostsFile)
+		if err != nil {
+			log.Println(err.Error())
+			return argError
+		}
+		hosts = append(hosts, found...)
+	}
+	if cidrRange != "" {
+		found, err := cidrHosts(cidrRange)
+		if err != nil {
+			log.Println(err.Error())
+			return argError
+		}
+		hosts = append(hosts, found...)
+	}
+	if Endpoint == "" {
+		Endpoint = "https://" + hostPlaceholder + "/wsman"
+	}
+	if !strings.Contains(Endpoint, hostPlaceholder) && len(hosts) > 1 {
+		log.Printf("-e must contain %s to run against many hosts", hostPlaceholder)
+		return argError
+	}
+	if parallel < 1 {
+		parallel = 1
+	}
+	results := make([]*hostResult, len(hosts))
+	sem := make(chan struct{}, parallel)
+	wg := &sync.WaitGroup{}
+	for i := range hosts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = runHostWithTimeout(hosts[i])
+		}(i)
+	}
+	wg.Wait()
+	writeJSON(os.Stdout, results)
+	counts := map[string]int{}
+	code := 0
+	for _, res := range results {
+		counts[res.Status]++
+		if c := res.exitCode(); c > code {
+			code = c
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d hosts: %d ok, %d faults, %d errors, %d timed out\n",
+		len(results), counts["ok"], counts["fault"], counts["error"], counts["timeout"])
+	return code
+}
@@ -0,0 +1,49 @@
+package main
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"testing"
+	"time"
+
+	"github.com/VictorLowther/wsman/wsmantest"
+)
+
+func TestHostTimeout(t *testing.T) {
+	s := wsmantest.NewServer()
+	defer s.Close()
+	oldEndpoint, oldAction, oldTimeout := Endpoint, Action, hostTimeout
+	defer func() { Endpoint, Action, hostTimeout = oldEndpoint, oldAction, oldTimeout }()
+	Endpoint, Action, hostTimeout = s.Endpoint(), "Identify", time.Second
+
+	if res := runHostWithTimeout(host{Name: "fast"}); res.Status != "ok" {
+		t.Errorf("Got %s: %s, wanted ok", res.Status, res.Error)
+	}
+
+	// runHost itself has to give up at the deadline, rather than
+	// being left running in the background.
+	hostTimeout = 50 * time.Millisecond
+	s.Inject("", "", 1, wsmantest.Delay(time.Second))
+	start := time.Now()
+	res := runHostWithTimeout(host{Name: "slow"})
+	if res.Status != "timeout" {
+		t.Errorf("Got %s: %s, wanted timeout", res.Status, res.Error)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Took %v to time out", elapsed)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Control characters the line editor understands.
+const (
+	ctrlA     = 1
+	ctrlC     = 3
+	ctrlD     = 4
+	ctrlE     = 5
+	backspace = 8
+	escape    = 27
+	del       = 127
+)
+
+// stty runs stty on the terminal wscli was started from.
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// lineEditor reads lines from the terminal with history and tab
+// completion.  When stdin is not a terminal it just reads lines.
+type lineEditor struct {
+	in      *bufio.Reader
+	out     io.Writer
+	prompt  string
+	history []string
+	// complete returns the candidates for word, given the words
+	// before it on the line.
+	complete func(words []string, word string) []string
+	raw      bool
+	saved    string
+}
+
+func newLineEditor(prompt string) *lineEditor {
+	return &lineEditor{
+		in:     bufio.NewReader(os.Stdin),
+		out:    os.Stdout,
+		prompt: prompt,
+	}
+}
+
+// start puts the terminal into raw mode.
+func (l *lineEditor) start() {
+	if !isTerminal(os.Stdin) {
+		return
+	}
+	saved, err := stty("-g")
+	if err != nil {
+		return
+	}
+	if _, err := stty("-icanon", "-echo", "-isig", "min", "1"); err == nil {
+		l.saved, l.raw = saved, true
+	}
+}
+
+// stop puts the terminal back the way start found it.
+func (l *lineEditor) stop() {
+	if l.raw {
+		stty(l.saved)
+		l.raw = false
+	}
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, word := range words[1:] {
+		for !strings.HasPrefix(word, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// completeAt completes the word that ends at pos.
+func (l *lineEditor) completeAt(buf []rune, pos int) ([]rune, int) {
+	if l.complete == nil {
+		return buf, pos
+	}
+	start := pos
+	for start > 0 && buf[start-1] != ' ' {
+		start--
+	}
+	word := string(buf[start:pos])
+	matches := []string{}
+	for _, candidate := range l.complete(strings.Fields(string(buf[:start])), word) {
+		if strings.HasPrefix(candidate, word) {
+			matches = append(matches, candidate)
+		}
+	}
+	var replacement string
+	switch len(matches) {
+	case 0:
+		fmt.Fprint(l.out, "\a")
+		return buf, pos
+	case 1:
+		replacement = matches[0] + " "
+	default:
+		replacement = commonPrefix(matches)
+		if replacement == word {
+			sort.Strings(matches)
+			fmt.Fprintf(l.out, "\n%s\n", strings.Join(matches, "  "))
+			return buf, pos
+		}
+	}
+	tail := append([]rune(replacement), buf[pos:]...)
+	return append(buf[:start], tail...), start + len([]rune(replacement))
+}
+
+// readLine reads the next line.  It returns io.EOF when the user
+// types Ctrl-D on an empty line.
+func (l *lineEditor) readLine() (string, error) {
+	if !l.raw {
+		fmt.Fprint(l.out, l.prompt)
+		line, err := l.in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	buf, pos := []rune{}, 0
+	hist, pending := len(l.history), ""
+	redraw := func() {
+		fmt.Fprintf(l.out, "\r\x1b[K%s%s", l.prompt, string(buf))
+		if back := len(buf) - pos; back > 0 {
+			fmt.Fprintf(l.out, "\x1b[%dD", back)
+		}
+	}
+	redraw()
+	for {
+		r, _, err := l.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprintln(l.out)
+			line := string(buf)
+			if strings.TrimSpace(line) != "" {
+				l.history = append(l.history, line)
+			}
+			return line, nil
+		case ctrlD:
+			if len(buf) == 0 {
+				fmt.Fprintln(l.out)
+				return "", io.EOF
+			}
+		case ctrlC:
+			fmt.Fprintln(l.out, "^C")
+			buf, pos = buf[:0], 0
+		case ctrlA:
+			pos = 0
+		case ctrlE:
+			pos = len(buf)
+		case backspace, del:
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+		case '\t':
+			buf, pos = l.completeAt(buf, pos)
+		case escape:
+			if b, _ := l.in.ReadByte(); b != '[' {
+				break
+			}
+			switch b, _ := l.in.ReadByte(); b {
+			case 'A':
+				if hist == len(l.history) {
+					pending = string(buf)
+				}
+				if hist > 0 {
+					hist--
+					buf = []rune(l.history[hist])
+					pos = len(buf)
+				}
+			case 'B':
+				if hist < len(l.history) {
+					hist++
+					if hist == len(l.history) {
+						buf = []rune(pending)
+					} else {
+						buf = []rune(l.history[hist])
+					}
+					pos = len(buf)
+				}
+			case 'C':
+				if pos < len(buf) {
+					pos++
+				}
+			case 'D':
+				if pos > 0 {
+					pos--
+				}
+			}
+		default:
+			if unicode.IsPrint(r) {
+				buf = append(buf[:pos], append([]rune{r}, buf[pos:]...)...)
+				pos++
+			}
+		}
+		redraw()
+	}
+}
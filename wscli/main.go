@@ -20,11 +20,12 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/soap"
 	"github.com/VictorLowther/wsman"
 )
 
@@ -35,10 +36,23 @@ const (
 	argError
 )
 
+// interrupted is the exit code used when wscli is killed by a signal.
+const interrupted = 130
+
+// subcommands are run instead of a single WSMAN action when their name
+// is the first argument to wscli.  They are passed the remaining
+// arguments, and return the exit code wscli should exit with.
+var subcommands = map[string]func(args []string) int{}
+
 var Endpoint, Username, Password, Action, Method, ResourceURI string
 var useDigest, debug, optimizeEnum, useStdin bool
-var selStr, optStr, paramStr string
+var selArgs, optArgs, paramArgs argList
 var timeout int64
+var retries int
+
+// limiters is shared by every client wscli makes, so that -max-in-flight
+// and -rps hold per host however many clients talk to it.
+var limiters = &wsman.Limiters{}
 
 func init() {
 	flag.StringVar(&Endpoint, "e", "", "The WSMAN endpoint to communicate with. Right now, only URLs are accepted.")
@@ -57,74 +71,114 @@ func init() {
       Invoke
       Any URL for a custom WSMAN Action`)
 	flag.BoolVar(&optimizeEnum, "q", false, "Optimize returning items from an Emumerate or EnumerateEPR")
-	flag.BoolVar(&useStdin, "i", false, "Read body of request from stdin")
+	flag.BoolVar(&useStdin, "i", false, "Read the body template from stdin instead of -template")
 	flag.StringVar(&ResourceURI, "r", "", "The ResourceURI for the action")
 	flag.StringVar(&Method, "m", "", "The method to invoke if the action is Invoke")
-	flag.StringVar(&selStr, "s", "", "The comma-seperated list of selector:value pairs")
-	flag.StringVar(&optStr, "o", "", "The comma-seperated set of WSMAN option:value pairs")
-	flag.StringVar(&paramStr, "x", "", "The comma-seperated list of parameter:value pairs for Invoke actions")
+	flag.Var(&selArgs, "s", "A selector as Name=value.  Repeat for more selectors.  The old \"name: value, name: value\" form still works")
+	flag.Var(&optArgs, "o", "A WSMAN option as Name=value.  Repeat for more options")
+	flag.Var(&paramArgs, "x", `A parameter for Invoke, or a value for Put, as Name=value.  Repeat for more,
+      or to make an array.  Name:type=value checks the value against a CIM type,
+      Name:nil= sends xsi:nil, and Name:epr=file.xml sends the EPR in file.xml`)
 	flag.Int64Var(&timeout, "t", 60, "The number of seconds to wait for a response from the WSMAN endpoint")
+	flag.IntVar(&limiters.MaxInFlight, "max-in-flight", 0, "The most requests to have outstanding to a single host at once.  0 means no limit")
+	flag.Float64Var(&limiters.RPS, "rps", 0, "The most requests per second to send to a single host.  0 means no limit")
+	flag.IntVar(&retries, "retries", 0, "Retry Get, Enumerate, and Pull up to this many times when the endpoint is busy or unreachable")
 }
 
-func handleSlice(p string) []string {
-	res := []string{}
-	if len(p) == 0 {
-		return res
-	}
-	segments := strings.Split(p, ",")
-	for _, s := range segments {
-		segment := strings.SplitN(s, ":", 2)
-		if len(segment) != 2 {
-			log.Printf("Segment %s does not have 2 : seperated elements!", s)
-			os.Exit(argError)
-		}
-		for _, t := range segment {
-			res = append(res, strings.TrimSpace(t))
+// newClient makes a client for a single endpoint with the settings
+// from the command line.  Requests fail once deadline passes, unless
+// it is zero.
+func newClient(endpoint, username, password string, digest bool, tlsConfig *TLSConfig, deadline time.Time) (*wsman.Client, error) {
+	tr, err := transport(tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	// The profile's TLS settings and the host's limits have to be in
+	// place before Connect fetches the digest challenge.
+	var rt http.RoundTripper
+	if tr != nil {
+		rt = tr
+	}
+	rt = limiters.For(endpoint).Transport(rt)
+	if !deadline.IsZero() {
+		rt = &deadlineTransport{next: rt, deadline: deadline}
+	}
+	client, err := wsman.ConnectWith(endpoint, username, password, digest, rt)
+	if err != nil {
+		return nil, err
+	}
+	client.Debug = debug
+	client.OptimizeEnum = optimizeEnum
+	client.Timeout = (time.Duration(timeout) * time.Second)
+	if retries > 0 {
+		client.Retry = wsman.DefaultRetryPolicy()
+		client.Retry.MaxAttempts = retries + 1
+		client.Retry.OnRetry = func(action string, attempt int, err error, wait time.Duration) {
+			log.Printf("%s: attempt %d failed, retrying in %v: %v", endpoint, attempt, wait, err)
 		}
 	}
-	return res
+	return client, nil
 }
 
-func getStdin() *dom.Element {
-	doc, err := dom.Parse(os.Stdin)
+func makeClient() *wsman.Client {
+	digest := useDigest
+	if dryRun {
+		// Setting up digest auth talks to the endpoint.
+		digest = false
+	}
+	client, err := newClient(Endpoint, Username, Password, digest, profile.TLS, time.Time{})
 	if err != nil {
-		log.Printf("Failed to parse XML doc on stdin: %v\n", err)
-		os.Exit(argError)
+		log.Println(err.Error())
+		os.Exit(transportError)
 	}
-	return doc.Root()
+	return client
 }
 
-func main() {
-	flag.Parse()
-	Selectors := handleSlice(selStr)
-	Options := handleSlice(optStr)
-	Parameters := handleSlice(paramStr)
-	if Endpoint == "" || Action == "" {
-		flag.Usage()
-		os.Exit(argError)
+var Selectors, Options, Parameters []arg
+
+// checkAction checks that the flags describe an action that can be
+// sent.
+func checkAction() error {
+	var err error
+	if Selectors, err = selArgs.parse(); err != nil {
+		return err
 	}
-	if len(flag.Args()) > 0 {
-		fmt.Printf("%v", flag.Args())
-		os.Exit(argError)
+	if Options, err = optArgs.parse(); err != nil {
+		return err
 	}
-	client := wsman.NewClient(Endpoint, Username, Password, useDigest)
-	client.Debug = debug
-	client.OptimizeEnum = optimizeEnum
-	client.Timeout = (time.Duration(timeout) * time.Second)
-	var msg *wsman.Message
-	if Action == "Identify" {
-		reply, err := client.Identify()
-		if err != nil {
-			log.Println(err.Error())
-			os.Exit(transportError)
+	if Parameters, err = paramArgs.parse(); err != nil {
+		return err
+	}
+	if useStdin || templateFile != "" {
+		if err := renderBody(); err != nil {
+			return err
 		}
-		fmt.Println(reply.String())
-		os.Exit(0)
+	}
+	if Action == "Identify" {
+		return nil
 	}
 	if len(ResourceURI) == 0 {
-		log.Printf("%s requires a resource URI passed in with -r\n", Action)
-		os.Exit(argError)
+		return fmt.Errorf("%s requires a resource URI passed in with -r", Action)
+	}
+	if Action == "Invoke" && len(Method) == 0 {
+		return fmt.Errorf("%s requires a method passed in with -m", Action)
+	}
+	return nil
+}
+
+// runAction performs the action the flags describe with client.  Faults
+// are returned as the reply along with an error.
+func runAction(client *wsman.Client) (*soap.Message, error) {
+	if Action == "Identify" {
+		if dryRun {
+			msg := soap.NewMessage()
+			msg.SetBody(dom.Elem("Identify", wsman.NS_WSMID))
+			fmt.Println(msg.String())
+			return nil, nil
+		}
+		return client.Identify()
 	}
+	var msg *wsman.Message
 	switch Action {
 	case "Enumerate":
 		msg = client.Enumerate(ResourceURI)
@@ -139,10 +193,6 @@ func main() {
 	case "Delete":
 		msg = client.Delete(ResourceURI)
 	case "Invoke":
-		if len(Method) == 0 {
-			log.Printf("%s requires a method passed in with -m", Action)
-			os.Exit(argError)
-		}
 		msg = client.Invoke(ResourceURI, Method)
 	default:
 		msg = client.NewMessage(Action)
@@ -150,30 +200,87 @@ func main() {
 			msg.SetHeader(wsman.Resource(ResourceURI))
 		}
 	}
-	if len(Options) > 0 {
-		msg.Options(Options...)
+	for _, opt := range Options {
+		msg.TypedOption(opt.Name, opt.Value)
 	}
-	if len(Selectors) > 0 {
-		msg.Selectors(Selectors...)
+	for _, sel := range Selectors {
+		msg.TypedSelector(sel.Name, sel.Value)
 	}
-	if len(Parameters) > 0 {
+	for _, param := range Parameters {
 		if Action == "Put" {
-			msg.Values(Parameters...)
+			msg.TypedValue(param.Name, param.Value)
 		} else {
-			msg.Parameters(Parameters...)
+			msg.TypedParameter(param.Name, param.Value)
 		}
 	}
-	if useStdin {
-		msg.SetBody(getStdin())
+	if bodyXML != nil {
+		msg.SetBody(body())
+	}
+	if dryRun {
+		fmt.Println(msg.String())
+		return nil, nil
 	}
 	reply, err := msg.Send()
+	if reply != nil {
+		for _, change := range reply.Tuned {
+			log.Printf("%s: reply too big, retried with %s", client.Endpoint(), change)
+		}
+		return reply.Message, err
+	}
+	return nil, err
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		if sub, ok := subcommands[os.Args[1]]; ok {
+			flag.CommandLine.Parse(os.Args[2:])
+			if err := configure(); err != nil {
+				log.Println(err.Error())
+				os.Exit(argError)
+			}
+			if Endpoint == "" {
+				flag.Usage()
+				os.Exit(argError)
+			}
+			os.Exit(sub(flag.Args()))
+		}
+	}
+	flag.Parse()
+	if err := configure(); err != nil {
+		log.Println(err.Error())
+		os.Exit(argError)
+	}
+	if Action == "" || !validOutput() {
+		flag.Usage()
+		os.Exit(argError)
+	}
+	if len(flag.Args()) > 0 {
+		fmt.Printf("%v", flag.Args())
+		os.Exit(argError)
+	}
+	if err := checkAction(); err != nil {
+		log.Println(err.Error())
+		os.Exit(argError)
+	}
+	if hostsFile != "" || cidrRange != "" {
+		os.Exit(fanOut())
+	}
+	if Endpoint == "" {
+		flag.Usage()
+		os.Exit(argError)
+	}
+	reply, err := runAction(makeClient())
+	if dryRun && err == nil {
+		os.Exit(0)
+	}
+	if reply != nil && reply.Fault() != nil {
+		writeReply(os.Stdout, reply)
+		os.Exit(soapFault)
+	}
 	if err != nil {
 		fmt.Println(err.Error())
 		os.Exit(transportError)
 	}
-	fmt.Println(reply.String())
-	if reply.Fault() != nil {
-		os.Exit(soapFault)
-	}
+	writeReply(os.Stdout, reply)
 	os.Exit(0)
 }
@@ -0,0 +1,275 @@
+package main
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/search"
+	"github.com/VictorLowther/soap"
+	"github.com/VictorLowther/wsman"
+)
+
+var outputFormat, columnStr string
+
+func init() {
+	flag.StringVar(&outputFormat, "output", "xml", "How to print the response. Can be one of xml, json, yaml, or table")
+	flag.StringVar(&columnStr, "columns", "", "The comma-seperated list of properties to show with -output table")
+}
+
+var outputFormats = map[string]func(w io.Writer, v interface{}) error{
+	"json":  writeJSON,
+	"yaml":  writeYAML,
+	"table": writeTable,
+}
+
+func validOutput() bool {
+	_, ok := outputFormats[outputFormat]
+	return ok || outputFormat == "xml"
+}
+
+// isNil checks for xsi:nil="true".
+func isNil(e *dom.Element) bool {
+	for _, attr := range e.Attributes {
+		if attr.Name.Local == "nil" && attr.Name.Space == wsman.NS_XSI {
+			return attr.Value == "true"
+		}
+	}
+	return false
+}
+
+// repeated records, by path, the names that appear more than once
+// under an element anywhere below e.
+func repeated(e *dom.Element, path string, lists map[string]bool) {
+	counts := map[string]int{}
+	for _, child := range e.Children() {
+		name := path + "/" + child.Name.Local
+		if counts[name]++; counts[name] > 1 {
+			lists[name] = true
+		}
+		repeated(child, name, lists)
+	}
+}
+
+// toValue converts an element to a string, nil, or, if it has
+// children, a map keyed by their local names.  Children whose path is
+// in lists become arrays, even when there is only one of them.
+func toValue(e *dom.Element, path string, lists map[string]bool) interface{} {
+	if isNil(e) {
+		return nil
+	}
+	children := e.Children()
+	if len(children) == 0 {
+		return strings.TrimSpace(string(e.Content))
+	}
+	res := map[string]interface{}{}
+	for _, child := range children {
+		name := path + "/" + child.Name.Local
+		val := toValue(child, name, lists)
+		if !lists[name] {
+			res[child.Name.Local] = val
+		} else if list, ok := res[child.Name.Local].([]interface{}); ok {
+			res[child.Name.Local] = append(list, val)
+		} else {
+			res[child.Name.Local] = []interface{}{val}
+		}
+	}
+	return res
+}
+
+// toValues converts the body of a response.  Enumerations become a
+// list of their items, anything else becomes the value of the first
+// body element.  A property that repeats anywhere in the response is
+// an array everywhere in it, so every item has the same shape.
+func toValues(msg *soap.Message) interface{} {
+	body := msg.AllBodyElements()
+	lists := map[string]bool{}
+	if items := search.First(search.Tag("Items", "*"), body); items != nil {
+		for _, item := range items.Children() {
+			repeated(item, item.Name.Local, lists)
+		}
+		res := []interface{}{}
+		for _, item := range items.Children() {
+			res = append(res, toValue(item, item.Name.Local, lists))
+		}
+		return res
+	}
+	if top := msg.Body(); len(top) > 0 {
+		repeated(top[0], top[0].Name.Local, lists)
+		return toValue(top[0], top[0].Name.Local, lists)
+	}
+	return nil
+}
+
+// writeReply prints msg in the format chosen with -output.
+func writeReply(w io.Writer, msg *soap.Message) error {
+	format, ok := outputFormats[outputFormat]
+	if !ok {
+		_, err := fmt.Fprintln(w, msg.String())
+		return err
+	}
+	return format(w, toValues(msg))
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(buf))
+	return err
+}
+
+var plainYAML = regexp.MustCompile(`^[A-Za-z0-9_./][A-Za-z0-9_ ./:@()-]*$`)
+
+// yamlResolved matches the plain scalars a YAML 1.1 parser reads as
+// ints (including octal, hex, and base 60), floats, and timestamps.
+var yamlResolved = regexp.MustCompile(`^(` +
+	`[-+]?0b[01_]+|[-+]?0x[0-9A-Fa-f_]+|` +
+	`[-+]?[0-9][0-9_]*(:[0-5]?[0-9])*(\.[0-9_]*)?([eE][-+]?[0-9]+)?|` +
+	`[-+]?\.[0-9][0-9_]*([eE][-+]?[0-9]+)?|[-+]?\.(inf|Inf|INF)|\.(nan|NaN|NAN)|` +
+	`[0-9]{4}-[0-9]{1,2}-[0-9]{1,2}([Tt ].*)?` +
+	`)$`)
+
+// yamlScalar quotes s unless YAML would read it back unchanged.
+func yamlScalar(s string) string {
+	switch strings.ToLower(s) {
+	case "true", "false", "yes", "no", "on", "off", "y", "n", "null", "~":
+		return strconv.Quote(s)
+	}
+	if !plainYAML.MatchString(s) || yamlResolved.MatchString(s) ||
+		strings.HasSuffix(s, " ") || strings.Contains(s, ": ") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func yamlValue(w io.Writer, v interface{}, indent string) {
+	switch val := v.(type) {
+	case nil:
+		fmt.Fprintln(w, " null")
+	case string:
+		fmt.Fprintln(w, " "+yamlScalar(val))
+	case map[string]interface{}:
+		if len(val) == 0 {
+			fmt.Fprintln(w, " {}")
+			return
+		}
+		fmt.Fprintln(w)
+		for _, k := range sortedKeys(val) {
+			fmt.Fprintf(w, "%s%s:", indent, yamlScalar(k))
+			yamlValue(w, val[k], indent+"  ")
+		}
+	case []interface{}:
+		if len(val) == 0 {
+			fmt.Fprintln(w, " []")
+			return
+		}
+		fmt.Fprintln(w)
+		for _, item := range val {
+			fmt.Fprintf(w, "%s-", indent)
+			yamlValue(w, item, indent+"  ")
+		}
+	}
+}
+
+// writeYAML writes v as a YAML document.
+func writeYAML(w io.Writer, v interface{}) error {
+	fmt.Fprint(w, "---")
+	yamlValue(w, v, "")
+	return nil
+}
+
+// cell renders a value for a single table cell.
+func cell(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case []interface{}:
+		parts := make([]string, len(val))
+		for i, item := range val {
+			parts[i] = cell(item)
+		}
+		return strings.Join(parts, ",")
+	default:
+		buf, _ := json.Marshal(val)
+		return string(buf)
+	}
+}
+
+// writeTable writes one row per object, with the columns chosen with
+// -columns or every property the objects have.
+func writeTable(w io.Writer, v interface{}) error {
+	rows := []map[string]interface{}{}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		rows = append(rows, val)
+	case []interface{}:
+		for _, item := range val {
+			if row, ok := item.(map[string]interface{}); ok {
+				rows = append(rows, row)
+			}
+		}
+	default:
+		_, err := fmt.Fprintln(w, cell(val))
+		return err
+	}
+	columns := []string{}
+	if columnStr != "" {
+		for _, col := range strings.Split(columnStr, ",") {
+			columns = append(columns, strings.TrimSpace(col))
+		}
+	} else {
+		seen := map[string]interface{}{}
+		for _, row := range rows {
+			for k := range row {
+				seen[k] = nil
+			}
+		}
+		columns = sortedKeys(seen)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(columns, "\t"))
+	for _, row := range rows {
+		cells := make([]string, len(columns))
+		for i, col := range columns {
+			cells[i] = cell(row[col])
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,85 @@
+package main
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/soap"
+)
+
+func TestYAMLScalar(t *testing.T) {
+	for _, s := range []string{
+		"CIM_Fan", "Fan.1", "6.2.9200", "C:/Windows", "a b", "http://example.com/x",
+	} {
+		if got := yamlScalar(s); got != s {
+			t.Errorf("%q should not be quoted, got %s", s, got)
+		}
+	}
+	for _, s := range []string{
+		"", "true", "No", "y", "null", "~", "-1", "has: colon", "trailing ",
+		"0", "42", "0755", "1_000", "0x1F", "0b101", "12:30",
+		"1.0", "1e3", "1.5E-3", ".5", ".inf", ".NaN",
+		"2001-12-14", "2001-12-14t21:59:43.10-05:00", "2001-12-14 21:59:43.10",
+	} {
+		if got := yamlScalar(s); got[0] != '"' {
+			t.Errorf("%q should be quoted, got %s", s, got)
+		}
+	}
+}
+
+func parseReply(t *testing.T, body string) *soap.Message {
+	msg, err := soap.Parse(strings.NewReader(`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"
+  xmlns:n="http://schemas.xmlsoap.org/ws/2004/09/enumeration"
+  xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd"
+  xmlns:p="urn:nic" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+<s:Header/><s:Body>` + body + `</s:Body></s:Envelope>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestToValues(t *testing.T) {
+	for _, test := range []struct{ body, want string }{
+		// Addresses repeats in the second card, so it is a list in
+		// the first one too.
+		{`<n:EnumerateResponse><w:Items>
+  <p:NIC><p:FQDD>NIC.1</p:FQDD><p:Addresses>a</p:Addresses><p:Speed xsi:nil="true"/></p:NIC>
+  <p:NIC><p:FQDD>NIC.2</p:FQDD><p:Addresses>b</p:Addresses><p:Addresses>c</p:Addresses><p:Speed>10</p:Speed></p:NIC>
+  <p:NIC><p:FQDD>NIC.3</p:FQDD></p:NIC>
+</w:Items></n:EnumerateResponse>`,
+			`[{"Addresses":["a"],"FQDD":"NIC.1","Speed":null},` +
+				`{"Addresses":["b","c"],"FQDD":"NIC.2","Speed":"10"},` +
+				`{"FQDD":"NIC.3"}]`},
+		{`<p:NIC><p:FQDD>NIC.1</p:FQDD><p:Addresses>a</p:Addresses></p:NIC>`,
+			`{"Addresses":"a","FQDD":"NIC.1"}`},
+		{`<p:NIC><p:Port><p:Name>1</p:Name><p:VLAN>2</p:VLAN><p:VLAN>3</p:VLAN></p:Port>` +
+			`<p:Port><p:Name>2</p:Name><p:VLAN>4</p:VLAN></p:Port></p:NIC>`,
+			`{"Port":[{"Name":"1","VLAN":["2","3"]},{"Name":"2","VLAN":["4"]}]}`},
+	} {
+		buf, err := json.Marshal(toValues(parseReply(t, test.body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != test.want {
+			t.Errorf("Got %s\nwanted %s", buf, test.want)
+		}
+	}
+}
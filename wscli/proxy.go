@@ -0,0 +1,114 @@
+package main
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/soap"
+	"github.com/VictorLowther/wsman"
+	"github.com/VictorLowther/wsman/wsmantest"
+)
+
+var listenAddr, recordDir string
+
+func init() {
+	flag.StringVar(&listenAddr, "listen", "127.0.0.1:8080", "The address wscli proxy listens on")
+	flag.StringVar(&recordDir, "record", "", "A directory wscli proxy saves exchanges to for wsmantest.NewReplayer")
+	subcommands["proxy"] = proxyCommand
+}
+
+// proxy forwards every SOAP request it gets to the endpoint of client,
+// and logs both sides of the exchange.
+type proxy struct {
+	client *wsman.Client
+}
+
+func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	req, err := soap.Parse(r.Body)
+	if err != nil {
+		log.Printf("%s sent a request that is not SOAP: %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The request was addressed to us, so readdress it to the endpoint.
+	if to := req.GetHeader(dom.Elem("To", wsman.NS_WSA)); to != nil {
+		to.Content = []byte(p.client.Endpoint())
+	}
+	log.Printf("%s request:\n%s\n", r.RemoteAddr, req.String())
+	res, err := p.client.Post(req)
+	if res == nil {
+		log.Printf("%s error: %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	log.Printf("%s response:\n%s\n", r.RemoteAddr, res.String())
+	w.Header().Set("Content-Type", soap.ContentType)
+	if res.Fault() != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	io.Copy(w, res.Reader())
+}
+
+// proxyCommand runs a WSMAN proxy that forwards requests to the
+// endpoint, using the credentials wscli was given.
+// loopback reports whether addr only listens for connections from
+// this machine.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func proxyCommand(args []string) int {
+	if len(args) != 0 {
+		log.Printf("proxy does not take arguments: %s", strings.Join(args, " "))
+		return argError
+	}
+	client := makeClient()
+	if recordDir != "" {
+		recorder := wsmantest.NewRecorder(recordDir, client.Transport)
+		recorder.Secrets = []string{Password}
+		client.Transport = recorder
+	}
+	if !loopback(listenAddr) {
+		// Anyone who can reach us gets to use our credentials.
+		log.Printf("Warning: %s is reachable from other machines, and requests to it are sent with the credentials for %s", listenAddr, Endpoint)
+	}
+	log.Printf("Proxying %s to %s", listenAddr, Endpoint)
+	if err := http.ListenAndServe(listenAddr, &proxy{client: client}); err != nil {
+		log.Println(err.Error())
+		return transportError
+	}
+	return 0
+}
@@ -0,0 +1,44 @@
+package main
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestProxyListensLocally(t *testing.T) {
+	if def := flag.Lookup("listen").DefValue; !loopback(def) {
+		t.Errorf("proxy listens on %s by default", def)
+	}
+	for _, test := range []struct {
+		addr string
+		want bool
+	}{
+		{"127.0.0.1:8080", true},
+		{"[::1]:8080", true},
+		{"localhost:8080", true},
+		{":8080", false},
+		{"0.0.0.0:8080", false},
+		{"10.1.2.3:8080", false},
+		{"8080", false},
+	} {
+		if got := loopback(test.addr); got != test.want {
+			t.Errorf("loopback(%q) = %v, wanted %v", test.addr, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/VictorLowther/simplexml/search"
+	"github.com/VictorLowther/wsman"
+)
+
+// maxHistory is how many lines of history wscli shell keeps.
+const maxHistory = 500
+
+// repl is an interactive session with a single endpoint.
+type repl struct {
+	client *wsman.Client
+	editor *lineEditor
+	// eprs are the results of the last epr command.
+	eprs []*wsman.EndpointReference
+	// resources are the ResourceURIs seen so far, for completion.
+	resources map[string]bool
+}
+
+type replCommand struct {
+	usage string
+	run   func(r *repl, args []string) error
+}
+
+var replCommands map[string]replCommand
+
+func init() {
+	replCommands = map[string]replCommand{
+		"identify": {"identify", (*repl).identify},
+		"enum":     {"enum <resource>", (*repl).enum},
+		"epr":      {"epr <resource>", (*repl).epr},
+		"eprs":     {"eprs", (*repl).listEPRs},
+		"get":      {"get <target> [Selector=value...]", (*repl).get},
+		"delete":   {"delete <target>", (*repl).delete},
+		"invoke":   {"invoke <target> <method> [Parameter=value...]", (*repl).invoke},
+		"output":   {"output xml|json|yaml|table", (*repl).output},
+		"history":  {"history", (*repl).showHistory},
+		"help":     {"help", (*repl).help},
+	}
+	subcommands["shell"] = shellCommand
+}
+
+// splitWords splits a line into words on spaces, keeping quoted
+// strings together.
+func splitWords(line string) []string {
+	res := []string{}
+	word, quote, inWord := []rune{}, rune(0), false
+	for _, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word = append(word, r)
+		case r == '"' || r == '\'':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				res = append(res, string(word))
+				word, inWord = word[:0], false
+			}
+		default:
+			word, inWord = append(word, r), true
+		}
+	}
+	if inWord {
+		res = append(res, string(word))
+	}
+	return res
+}
+
+// pairs splits Name=value arguments into the key/value pairs Message
+// methods take.
+func pairs(args []string) ([]string, error) {
+	res := []string{}
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s is not Name=value", arg)
+		}
+		res = append(res, parts[0], parts[1])
+	}
+	return res, nil
+}
+
+// target parses #n as the nth EPR from the last epr command, or
+// resource?Name=value&Name=value as a resource and its selectors.
+func (r *repl) target(arg string) (*wsman.EndpointReference, error) {
+	if strings.HasPrefix(arg, "#") {
+		i, err := strconv.Atoi(arg[1:])
+		if err != nil || i < 0 || i >= len(r.eprs) {
+			return nil, fmt.Errorf("No EPR %s, see eprs", arg)
+		}
+		return r.eprs[i], nil
+	}
+	parts := strings.SplitN(arg, "?", 2)
+	res := &wsman.EndpointReference{ResourceURI: parts[0]}
+	if len(parts) == 2 {
+		for _, sel := range strings.Split(parts[1], "&") {
+			kv := strings.SplitN(sel, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("Selector %s is not Name=value", sel)
+			}
+			res.Selectors = append(res.Selectors, wsman.Selector{Name: kv[0], Value: kv[1]})
+		}
+	}
+	return res, nil
+}
+
+// remember notes the ResourceURIs in a response for completion.
+func (r *repl) remember(msg *wsman.Message) {
+	for _, e := range msg.AllBodyElements() {
+		if e.Name.Local == "ResourceURI" && e.Name.Space == wsman.NS_WSMAN {
+			r.resources[strings.TrimSpace(string(e.Content))] = true
+		} else if strings.Contains(e.Name.Space, "/wbem/wscim/") {
+			r.resources[e.Name.Space] = true
+		}
+	}
+}
+
+// send sends msg.  Faults are printed rather than returned, so the
+// reply and error are both nil for them.
+func (r *repl) send(msg *wsman.Message) (*wsman.Message, error) {
+	reply, err := msg.Send()
+	if reply != nil {
+		r.remember(reply)
+		if reply.Fault() != nil {
+			writeReply(os.Stdout, reply.Message)
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (r *repl) identify(args []string) error {
+	reply, err := r.client.Identify()
+	if err != nil {
+		return err
+	}
+	return writeReply(os.Stdout, reply)
+}
+
+func (r *repl) enum(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("enum takes a resource")
+	}
+	reply, err := r.send(r.client.Enumerate(args[0]))
+	if reply == nil {
+		return err
+	}
+	r.resources[args[0]] = true
+	return writeReply(os.Stdout, reply.Message)
+}
+
+func (r *repl) epr(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("epr takes a resource")
+	}
+	reply, err := r.send(r.client.EnumerateEPR(args[0]))
+	if reply == nil {
+		return err
+	}
+	r.resources[args[0]] = true
+	r.eprs = nil
+	if items := search.First(search.Tag("Items", "*"), reply.AllBodyElements()); items != nil {
+		for _, item := range items.Children() {
+			epr, err := wsman.ParseEPR(item)
+			if err != nil {
+				return err
+			}
+			r.eprs = append(r.eprs, epr)
+		}
+	}
+	return r.listEPRs(nil)
+}
+
+func (r *repl) listEPRs(args []string) error {
+	for i, epr := range r.eprs {
+		fmt.Printf("#%d %s\n", i, epr)
+	}
+	return nil
+}
+
+func (r *repl) delete(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("delete takes a target")
+	}
+	epr, err := r.target(args[0])
+	if err != nil {
+		return err
+	}
+	reply, err := r.send(r.client.DeleteEPR(epr))
+	if reply == nil {
+		return err
+	}
+	fmt.Printf("Deleted %s\n", epr)
+	return nil
+}
+
+func (r *repl) get(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("get takes a target")
+	}
+	epr, err := r.target(args[0])
+	if err != nil {
+		return err
+	}
+	selectors, err := pairs(args[1:])
+	if err != nil {
+		return err
+	}
+	msg := r.client.GetEPR(epr)
+	if len(selectors) > 0 {
+		msg.Selectors(selectors...)
+	}
+	reply, err := r.send(msg)
+	if reply == nil {
+		return err
+	}
+	return writeReply(os.Stdout, reply.Message)
+}
+
+func (r *repl) invoke(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("invoke takes a target and a method")
+	}
+	epr, err := r.target(args[0])
+	if err != nil {
+		return err
+	}
+	params, err := pairs(args[2:])
+	if err != nil {
+		return err
+	}
+	msg := r.client.Invoke(epr.ResourceURI, args[1]).Target(epr)
+	if len(params) > 0 {
+		msg.Parameters(params...)
+	}
+	reply, err := r.send(msg)
+	if reply == nil {
+		return err
+	}
+	return writeReply(os.Stdout, reply.Message)
+}
+
+func (r *repl) output(args []string) error {
+	if len(args) != 1 {
+		fmt.Println(outputFormat)
+		return nil
+	}
+	old := outputFormat
+	if outputFormat = args[0]; !validOutput() {
+		outputFormat = old
+		return fmt.Errorf("Unknown output format %s", args[0])
+	}
+	return nil
+}
+
+func (r *repl) showHistory(args []string) error {
+	for i, line := range r.editor.history {
+		fmt.Printf("%5d  %s\n", i+1, line)
+	}
+	return nil
+}
+
+func (r *repl) help(args []string) error {
+	usages := []string{}
+	for _, cmd := range replCommands {
+		usages = append(usages, cmd.usage)
+	}
+	sort.Strings(usages)
+	fmt.Println(strings.Join(usages, "\n"))
+	fmt.Println("A target is a resource, resource?Name=value&Name=value, or #n for an EPR listed by eprs.")
+	return nil
+}
+
+// knownResources returns the ResourceURIs seen in responses, and
+// those typed as targets in earlier lines of history.
+func (r *repl) knownResources() map[string]bool {
+	res := map[string]bool{}
+	for resource := range r.resources {
+		res[resource] = true
+	}
+	for _, line := range r.editor.history {
+		words := splitWords(line)
+		if len(words) < 2 || strings.HasPrefix(words[1], "#") {
+			continue
+		}
+		switch words[0] {
+		case "enum", "epr", "get", "delete", "invoke":
+			res[strings.SplitN(words[1], "?", 2)[0]] = true
+		}
+	}
+	return res
+}
+
+// complete completes command names, then resources and EPRs.
+func (r *repl) complete(words []string, word string) []string {
+	res := []string{}
+	switch {
+	case len(words) == 0:
+		for name := range replCommands {
+			res = append(res, name)
+		}
+		res = append(res, "quit")
+	case len(words) == 1 && words[0] == "output":
+		res = append(res, "xml", "json", "yaml", "table")
+	case len(words) == 1:
+		for resource := range r.knownResources() {
+			res = append(res, resource)
+		}
+		if words[0] == "get" || words[0] == "invoke" {
+			for i := range r.eprs {
+				res = append(res, fmt.Sprintf("#%d", i))
+			}
+		}
+	}
+	return res
+}
+
+func historyFile() string {
+	home := os.Getenv("HOME")
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".wscli_history")
+}
+
+func (r *repl) loadHistory() {
+	if buf, err := ioutil.ReadFile(historyFile()); err == nil {
+		r.editor.history = strings.Split(strings.TrimRight(string(buf), "\n"), "\n")
+	}
+}
+
+func (r *repl) saveHistory() {
+	if historyFile() == "" {
+		return
+	}
+	lines := r.editor.history
+	if len(lines) > maxHistory {
+		lines = lines[len(lines)-maxHistory:]
+	}
+	ioutil.WriteFile(historyFile(), []byte(strings.Join(lines, "\n")+"\n"), 0600)
+}
+
+// shellCommand runs an interactive session against the endpoint.
+func shellCommand(args []string) int {
+	if len(args) != 0 {
+		log.Printf("shell does not take arguments: %s", strings.Join(args, " "))
+		return argError
+	}
+	r := &repl{
+		client:    makeClient(),
+		editor:    newLineEditor("wscli> "),
+		resources: map[string]bool{},
+	}
+	r.editor.complete = r.complete
+	r.loadHistory()
+	r.editor.start()
+	defer r.editor.stop()
+	defer r.saveHistory()
+	for {
+		line, err := r.editor.readLine()
+		if err == io.EOF {
+			return 0
+		}
+		if err != nil {
+			log.Println(err.Error())
+			return transportError
+		}
+		words := splitWords(line)
+		if len(words) == 0 {
+			continue
+		}
+		if words[0] == "quit" || words[0] == "exit" {
+			return 0
+		}
+		cmd, ok := replCommands[words[0]]
+		if !ok {
+			fmt.Printf("Unknown command %s, try help\n", words[0])
+			continue
+		}
+		if err := cmd.run(r, words[1:]); err != nil {
+			fmt.Println(err.Error())
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCompleteFromHistory(t *testing.T) {
+	r := &repl{
+		editor:    newLineEditor("> "),
+		resources: map[string]bool{"http://example.com/CIM_Seen": true},
+	}
+	r.editor.history = []string{
+		"enum http://example.com/CIM_Fan",
+		"get 'http://example.com/CIM_Fan?DeviceID=Fan 1'",
+		"invoke http://example.com/CIM_Power?Name=pwr RequestPowerStateChange",
+		"get #0",
+		"output json",
+		"identify",
+	}
+	got := r.complete([]string{"get"}, "")
+	sort.Strings(got)
+	want := []string{
+		"http://example.com/CIM_Fan",
+		"http://example.com/CIM_Power",
+		"http://example.com/CIM_Seen",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Completed %v, wanted %v", got, want)
+	}
+
+	out := &bytes.Buffer{}
+	r.editor.out = out
+	r.editor.complete = r.complete
+	buf := []rune("delete http://example.com/CIM_F")
+	buf, _ = r.editor.completeAt(buf, len(buf))
+	if string(buf) != "delete http://example.com/CIM_Fan " {
+		t.Errorf("Tab completed to %q", string(buf))
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+var templateFile string
+var templateVars argList
+var dryRun bool
+
+func init() {
+	flag.StringVar(&templateFile, "template", "", "A file holding a text/template for the body of the request")
+	flag.Var(&templateVars, "var", "A variable for -template as name=value.  Repeat for more variables")
+	flag.BoolVar(&dryRun, "dry-run", false, "Print the request instead of sending it")
+}
+
+// bodyXML is the rendered body template, parsed again for every
+// request so that the same body can be sent to many hosts.
+var bodyXML []byte
+
+// xmlEscape escapes s for use in XML content and attributes.
+func xmlEscape(s string) string {
+	buf := &bytes.Buffer{}
+	xml.EscapeText(buf, []byte(s))
+	return buf.String()
+}
+
+// renderBody renders the template from -template, or from stdin with
+// -i.  Variables are available as {{.name}}, and must all be given.
+// The xml function escapes values, and env reads the environment.
+func renderBody() error {
+	var src []byte
+	var err error
+	name := templateFile
+	if useStdin {
+		name = "stdin"
+		src, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		src, err = ioutil.ReadFile(templateFile)
+	}
+	if err != nil {
+		return err
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(template.FuncMap{
+		"xml": xmlEscape,
+		"env": os.Getenv,
+	}).Parse(string(src))
+	if err != nil {
+		return err
+	}
+	vars := map[string]string{}
+	for _, v := range templateVars {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("-var %s is not name=value", v)
+		}
+		vars[parts[0]] = parts[1]
+	}
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, vars); err != nil {
+		return err
+	}
+	bodyXML = buf.Bytes()
+	if _, err := dom.Parse(bytes.NewReader(bodyXML)); err != nil {
+		return fmt.Errorf("Failed to parse the body from %s: %v", name, err)
+	}
+	return nil
+}
+
+// body parses the rendered body template.
+func body() *dom.Element {
+	doc, _ := dom.Parse(bytes.NewReader(bodyXML))
+	return doc.Root()
+}
@@ -0,0 +1,127 @@
+package main
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/wsman/wsmantest"
+)
+
+const fanTemplateURI = "http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_Fan"
+
+const fanTemplate = `<p:CIM_Fan xmlns:p="` + fanTemplateURI + `">` +
+	`<p:ElementName>{{xml .name}}</p:ElementName><p:DesiredSpeed>{{.speed}}</p:DesiredSpeed></p:CIM_Fan>`
+
+// useTemplate points -template at a file holding src, and returns a
+// func that puts the flags back.
+func useTemplate(t *testing.T, src string, vars ...string) func() {
+	f, err := ioutil.TempFile("", "template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(src)
+	f.Close()
+	oldFile, oldVars, oldBody := templateFile, templateVars, bodyXML
+	templateFile, templateVars, bodyXML = f.Name(), argList(vars), nil
+	return func() {
+		os.Remove(f.Name())
+		templateFile, templateVars, bodyXML = oldFile, oldVars, oldBody
+	}
+}
+
+func TestRenderBody(t *testing.T) {
+	defer useTemplate(t, fanTemplate, "name=Front <left>", "speed=4=four")()
+	if err := renderBody(); err != nil {
+		t.Fatal(err)
+	}
+	props := body().Children()
+	if len(props) != 2 || string(props[0].Content) != "Front <left>" || string(props[1].Content) != "4=four" {
+		t.Errorf("Rendered %s", bodyXML)
+	}
+	// Every request gets a body of its own.
+	if body() == body() {
+		t.Errorf("body() hands out the same element twice")
+	}
+}
+
+func TestRenderBodyErrors(t *testing.T) {
+	for _, test := range []struct {
+		src  string
+		vars []string
+		want string
+	}{
+		{fanTemplate, []string{"name=x"}, "speed"},
+		{fanTemplate, nil, "name"},
+		{fanTemplate, []string{"name=x", "speed"}, "-var speed is not name=value"},
+		{"<p:X>{{.name}}</p:Y>", []string{"name=x"}, "Failed to parse the body"},
+		{"{{.name", nil, "unclosed action"},
+	} {
+		restore := useTemplate(t, test.src, test.vars...)
+		err := renderBody()
+		restore()
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%q with %v: got %v, wanted an error about %s", test.src, test.vars, err, test.want)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	defer useTemplate(t, fanTemplate, "name=Front", "speed=4")()
+	// Nothing is listening, so any attempt to talk to the endpoint
+	// fails, including fetching a digest challenge.
+	s := wsmantest.NewServer()
+	s.Close()
+	oldEndpoint, oldAction, oldResource, oldDigest, oldDryRun, oldStdout, oldSelArgs :=
+		Endpoint, Action, ResourceURI, useDigest, dryRun, os.Stdout, selArgs
+	defer func() {
+		Endpoint, Action, ResourceURI, useDigest, dryRun, os.Stdout, selArgs =
+			oldEndpoint, oldAction, oldResource, oldDigest, oldDryRun, oldStdout, oldSelArgs
+	}()
+	Endpoint, ResourceURI, useDigest, dryRun = s.Endpoint(), fanTemplateURI, true, true
+	selArgs = argList{"DeviceID=Fan.1"}
+
+	for _, action := range []string{"Put", "Identify"} {
+		Action = action
+		out := tempFile(t, "")
+		os.Stdout = out
+		if err := checkAction(); err != nil {
+			t.Fatal(err)
+		}
+		reply, err := runAction(makeClient())
+		os.Stdout = oldStdout
+		if err != nil || reply != nil {
+			t.Errorf("%s dry run got %v, %v", action, reply, err)
+		}
+		out.Seek(0, 0)
+		printed, _ := ioutil.ReadAll(out)
+		for _, want := range []string{"Envelope", action, "Front"} {
+			if action == "Identify" && want == "Front" {
+				continue
+			}
+			if !strings.Contains(string(printed), want) {
+				t.Errorf("%s dry run printed %s, which has no %s", action, printed, want)
+			}
+		}
+		if action == "Put" && !strings.Contains(string(printed), "Fan.1") {
+			t.Errorf("Dry run printed no selectors: %s", printed)
+		}
+	}
+}
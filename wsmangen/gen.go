@@ -0,0 +1,395 @@
+package main
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// class is a CIM class, as read from either a MOF or an XSD file.
+type class struct {
+	Name, Super, Description, ResourceURI string
+	Properties                            []*property
+	Methods                               []*method
+}
+
+// property is a property of a class or a parameter of a method.
+type property struct {
+	Name, Description string
+	// Type is the CIM type, or the class referred to by references.
+	Type                     string
+	Ref, Array, Key, In, Out bool
+	OctetString              bool
+	Embedded                 string
+	ValueMap, Values         []string
+}
+
+// method is an extrinsic method of a class.  Type is the type of its
+// ReturnValue, if it has one.
+type method struct {
+	Name, Type, Description string
+	ValueMap, Values        []string
+	Params                  []*property
+}
+
+// cimTypes maps CIM types to the Go types used for them.
+var cimTypes = map[string]string{
+	"boolean":  "bool",
+	"string":   "string",
+	"char16":   "string",
+	"datetime": "time.Time",
+	"uint8":    "uint8",
+	"uint16":   "uint16",
+	"uint32":   "uint32",
+	"uint64":   "uint64",
+	"sint8":    "int8",
+	"sint16":   "int16",
+	"sint32":   "int32",
+	"sint64":   "int64",
+	"real32":   "float32",
+	"real64":   "float64",
+}
+
+// intervalName guesses which datetime properties hold intervals, since
+// nothing in the schema says so.
+var intervalName = regexp.MustCompile(`(Interval|Period|Duration|Timeout|ElapsedTime)$`)
+
+// uriPrefixes are the ResourceURI prefixes of the schemas classes are
+// commonly found in, by the prefix of the class name.
+var uriPrefixes = map[string]string{
+	"CIM":  "http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/",
+	"DCIM": "http://schemas.dell.com/wbem/wscim/1/cim-schema/2/",
+	"AMT":  "http://intel.com/wbem/wscim/1/amt-schema/1/",
+	"IPS":  "http://intel.com/wbem/wscim/1/ips-schema/1/",
+}
+
+// resourceURI works out the ResourceURI of a class from a MOF file.
+func resourceURI(name string) string {
+	if uriPrefix != "" {
+		return strings.TrimSuffix(uriPrefix, "/") + "/" + name
+	}
+	if prefix, ok := uriPrefixes[strings.SplitN(name, "_", 2)[0]]; ok {
+		return prefix + name
+	}
+	return uriPrefixes["CIM"] + name
+}
+
+func first(vals []string) string {
+	if len(vals) == 0 {
+		return ""
+	}
+	return vals[0]
+}
+
+// newProperty makes a property from the qualifiers that matter here.
+func newProperty(quals map[string][]string) *property {
+	return &property{
+		Description: first(quals["description"]),
+		Key:         first(quals["key"]) == "true",
+		OctetString: first(quals["octetstring"]) == "true",
+		Embedded:    first(quals["embeddedinstance"]),
+		ValueMap:    quals["valuemap"],
+		Values:      quals["values"],
+	}
+}
+
+type byName []*class
+
+func (b byName) Len() int           { return len(b) }
+func (b byName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byName) Less(i, j int) bool { return b[i].Name < b[j].Name }
+
+type generator struct {
+	buf     bytes.Buffer
+	classes map[string]*class
+	// imports are the packages the generated code uses.
+	imports map[string]bool
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// comment writes the first sentence of a description as a comment.
+func (g *generator) comment(prefix, desc string) {
+	desc = strings.Join(strings.Fields(desc), " ")
+	if idx := strings.Index(desc, ". "); idx != -1 {
+		desc = desc[:idx+1]
+	}
+	line := "//"
+	for _, word := range strings.Fields(prefix + desc) {
+		if len(line)+len(word) > 72 {
+			g.printf("%s\n", line)
+			line = "//"
+		}
+		line += " " + word
+	}
+	g.printf("%s\n", line)
+}
+
+// exported makes name usable as an exported Go identifier.
+func exported(name string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	res := ""
+	for _, word := range words {
+		res += strings.ToUpper(word[:1]) + word[1:]
+	}
+	if res == "" || unicode.IsDigit(rune(res[0])) {
+		res = "V" + res
+	}
+	return res
+}
+
+// goType is the Go type for a property.  Input parameters are pointers
+// so that they can be left out.
+func (g *generator) goType(p *property, input bool) string {
+	var typ string
+	switch {
+	case p.Ref:
+		return "*wsman.EndpointReference"
+	case p.Embedded != "" && g.classes[p.Embedded] != nil:
+		typ = p.Embedded
+		if !p.Array {
+			return "*" + typ
+		}
+	case p.OctetString:
+		typ = "string"
+	case p.Type == "datetime" && intervalName.MatchString(p.Name):
+		typ = "time.Duration"
+	default:
+		typ = cimTypes[p.Type]
+	}
+	if p.Array {
+		return "[]" + typ
+	}
+	if input {
+		return "*" + typ
+	}
+	return typ
+}
+
+func (g *generator) fields(props []*property, input bool) {
+	for _, p := range props {
+		name := exported(p.Name)
+		tag := ""
+		if name != p.Name {
+			tag = p.Name
+		}
+		if input {
+			tag += ",omitempty"
+		}
+		if tag != "" {
+			tag = fmt.Sprintf(" `wsman:\"%s\"`", tag)
+		}
+		if p.Description != "" {
+			g.comment("", p.Description)
+		}
+		typ := g.goType(p, input)
+		if strings.Contains(typ, "time.") {
+			g.imports["time"] = true
+		}
+		if strings.Contains(typ, "wsman.") {
+			g.imports["github.com/VictorLowther/wsman"] = true
+		}
+		g.printf("%s %s%s\n", name, typ, tag)
+	}
+}
+
+// constants writes the ValueMap of a property or method as constants.
+// Ranges and values that do not fit the type are left out.
+func (g *generator) constants(prefix, what, cimType string, valueMap, values []string) {
+	typ := cimTypes[cimType]
+	if len(valueMap) == 0 || typ == "" || typ == "bool" || strings.HasPrefix(typ, "time.") {
+		return
+	}
+	numeric := typ != "string"
+	seen := map[string]bool{}
+	lines := []string{}
+	for i, val := range valueMap {
+		if strings.Contains(val, "..") {
+			continue
+		}
+		if numeric && strings.IndexFunc(val, func(r rune) bool { return !unicode.IsDigit(r) && r != '-' }) != -1 {
+			continue
+		}
+		name := val
+		if i < len(values) {
+			name = values[i]
+		}
+		name = prefix + "_" + exported(name)
+		if seen[name] {
+			name += "_" + exported(val)
+		}
+		seen[name] = true
+		if !numeric {
+			val = fmt.Sprintf("%q", val)
+		}
+		lines = append(lines, fmt.Sprintf("%s %s = %s\n", name, typ, val))
+	}
+	if len(lines) == 0 {
+		return
+	}
+	g.printf("\n// Values for %s.\nconst (\n%s)\n", what, strings.Join(lines, ""))
+}
+
+// inherited lists the names of the properties c gets from its
+// superclasses.
+func (g *generator) inherited(c *class) map[string]bool {
+	res := map[string]bool{}
+	for super := g.classes[c.Super]; super != nil; super = g.classes[super.Super] {
+		for _, p := range super.Properties {
+			res[p.Name] = true
+		}
+	}
+	return res
+}
+
+// selectors writes a Selectors method that returns the key properties
+// of an instance, to pass to the method wrappers.  Keys that subclasses
+// declare again are only sent once.
+func (g *generator) selectors(c *class) {
+	keys := []string{}
+	seen := map[string]bool{}
+	for k := c; k != nil; k = g.classes[k.Super] {
+		for _, p := range k.Properties {
+			if !p.Key || p.Ref || p.Array || seen[p.Name] {
+				continue
+			}
+			seen[p.Name] = true
+			val := "x." + exported(p.Name)
+			if g.goType(p, false) != "string" {
+				val = "fmt.Sprint(" + val + ")"
+				g.imports["fmt"] = true
+			}
+			keys = append(keys, fmt.Sprintf("%q, %s", p.Name, val))
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	g.printf("\n// Selectors returns the keys of the instance as name/value pairs.\n")
+	g.printf("func (x *%s) Selectors() []string {\nreturn []string{%s}\n}\n", c.Name, strings.Join(keys, ", "))
+}
+
+func (g *generator) class(c *class) {
+	g.printf("\n// %s_ResourceURI is the ResourceURI of %s.\n", c.Name, c.Name)
+	g.printf("const %s_ResourceURI = %q\n\n", c.Name, c.ResourceURI)
+	g.comment(c.Name+" is an instance of the "+c.Name+" class. ", c.Description)
+	g.printf("type %s struct {\n", c.Name)
+	if g.classes[c.Super] != nil {
+		g.printf("%s\n", c.Super)
+	}
+	inherited := g.inherited(c)
+	props := []*property{}
+	for _, p := range c.Properties {
+		if !inherited[p.Name] {
+			props = append(props, p)
+		}
+	}
+	g.fields(props, false)
+	g.printf("}\n\n")
+	g.printf("// ResourceURI is the ResourceURI of %s.\n", c.Name)
+	g.printf("func (%s) ResourceURI() string { return %s_ResourceURI }\n", c.Name, c.Name)
+	g.selectors(c)
+	for _, p := range c.Properties {
+		g.constants(c.Name+"_"+exported(p.Name), c.Name+"."+p.Name, p.Type, p.ValueMap, p.Values)
+	}
+	for _, m := range c.Methods {
+		g.method(c, m)
+	}
+}
+
+func (g *generator) method(c *class, m *method) {
+	prefix := c.Name + "_" + exported(m.Name)
+	g.imports["github.com/VictorLowther/wsman"] = true
+	in, out := []*property{}, []*property{}
+	for _, p := range m.Params {
+		if p.In {
+			in = append(in, p)
+		}
+		if p.Out {
+			out = append(out, p)
+		}
+	}
+	g.printf("\n// %s_INPUT holds the parameters for %s.%s.\n", prefix, c.Name, m.Name)
+	g.printf("type %s_INPUT struct {\n", prefix)
+	g.fields(in, true)
+	g.printf("}\n\n")
+	g.printf("// %s_OUTPUT holds the results of %s.%s.\n", prefix, c.Name, m.Name)
+	g.printf("type %s_OUTPUT struct {\n", prefix)
+	if m.Type != "" {
+		g.printf("ReturnValue %s\n", cimTypes[m.Type])
+	}
+	g.fields(out, false)
+	g.printf("}\n")
+	g.constants(prefix+"_ReturnValue", c.Name+"."+m.Name+" ReturnValue", m.Type, m.ValueMap, m.Values)
+	for _, p := range m.Params {
+		g.constants(prefix+"_"+exported(p.Name), c.Name+"."+m.Name+" "+p.Name, p.Type, p.ValueMap, p.Values)
+	}
+	g.printf("\n")
+	g.comment(prefix+" invokes "+m.Name+" on the instance of "+c.Name+
+		" picked by selectors, which are name/value pairs. ", m.Description)
+	g.printf("func %s(c *wsman.Client, in *%s_INPUT, selectors ...string) (*%s_OUTPUT, error) {\n", prefix, prefix, prefix)
+	g.printf("msg := c.Invoke(%s_ResourceURI, %q).Selectors(selectors...)\n", c.Name, m.Name)
+	g.printf("if err := msg.MarshalParameters(in); err != nil {\nreturn nil, err\n}\n")
+	g.printf("reply, err := msg.Send()\nif err != nil {\nreturn nil, err\n}\n")
+	g.printf("out := &%s_OUTPUT{}\n", prefix)
+	g.printf("return out, reply.UnmarshalOutput(out)\n}\n")
+}
+
+// generate writes the Go bindings for classes as package pkg.  If the
+// result cannot be formatted, it is returned unformatted along with the
+// error, to make it easier to see what went wrong.
+func generate(pkg string, classes []*class) ([]byte, error) {
+	g := &generator{classes: map[string]*class{}, imports: map[string]bool{}}
+	for _, c := range classes {
+		if g.classes[c.Name] != nil {
+			return nil, fmt.Errorf("Class %s is defined more than once", c.Name)
+		}
+		g.classes[c.Name] = c
+	}
+	sort.Sort(byName(classes))
+	for _, c := range classes {
+		g.class(c)
+	}
+	body := g.buf.String()
+	head := &bytes.Buffer{}
+	fmt.Fprintf(head, "// Code generated by wsmangen. DO NOT EDIT.\n\npackage %s\n\nimport (\n", pkg)
+	for _, imp := range []string{"fmt", "time"} {
+		if g.imports[imp] {
+			fmt.Fprintf(head, "%q\n", imp)
+		}
+	}
+	if wsman := "github.com/VictorLowther/wsman"; g.imports[wsman] {
+		fmt.Fprintf(head, "\n%q\n", wsman)
+	}
+	fmt.Fprintf(head, ")\n")
+	src := append(head.Bytes(), body...)
+	res, err := format.Source(src)
+	if err != nil {
+		return src, err
+	}
+	return res, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const keysMOF = `
+class CIM_ManagedElement {
+      [Key, Description ("An opaque, unique ID.")]
+   string InstanceID;
+   string ElementName;
+};
+
+class DCIM_Fan : CIM_ManagedElement {
+      [Key, Override ("InstanceID")]
+   string InstanceID;
+      [Key]
+   uint16 Index;
+};
+`
+
+func TestSelectorsRedeclaredKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wsmangen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "fan.mof")
+	if err := ioutil.WriteFile(name, []byte(keysMOF), 0644); err != nil {
+		t.Fatal(err)
+	}
+	classes, err := parseMOF(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := generate("classes", classes)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, src)
+	}
+	for _, line := range strings.Split(string(src), "\n") {
+		if !strings.Contains(line, "[]string{") {
+			continue
+		}
+		if n := strings.Count(line, `"InstanceID"`); n != 1 {
+			t.Errorf("InstanceID is in the selectors %d times: %s", n, line)
+		}
+	}
+	if !strings.Contains(string(src), `{"InstanceID", x.InstanceID, "Index", fmt.Sprint(x.Index)}`) {
+		t.Errorf("DCIM_Fan selectors are wrong:\n%s", src)
+	}
+}
+
+func TestGeneratedFanUpToDate(t *testing.T) {
+	classes, err := parseMOF("internal/fan/fan.mof")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := generate("fan", classes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkedIn, err := ioutil.ReadFile("internal/fan/fan.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, checkedIn) {
+		t.Error("internal/fan/fan.go is out of date, run go generate in internal/fan")
+	}
+}
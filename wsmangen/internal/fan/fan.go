@@ -0,0 +1,65 @@
+// Code generated by wsmangen. DO NOT EDIT.
+
+package fan
+
+import (
+	"time"
+
+	"github.com/VictorLowther/wsman"
+)
+
+// CIM_Fan_ResourceURI is the ResourceURI of CIM_Fan.
+const CIM_Fan_ResourceURI = "http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_Fan"
+
+// CIM_Fan is an instance of the CIM_Fan class. A fan.
+type CIM_Fan struct {
+	// The ID of the fan.
+	DeviceID     string
+	DesiredSpeed uint64
+	// How long the fan has been running.
+	RunningInterval time.Duration
+}
+
+// ResourceURI is the ResourceURI of CIM_Fan.
+func (CIM_Fan) ResourceURI() string { return CIM_Fan_ResourceURI }
+
+// Selectors returns the keys of the instance as name/value pairs.
+func (x *CIM_Fan) Selectors() []string {
+	return []string{"DeviceID", x.DeviceID}
+}
+
+// CIM_Fan_SetSpeed_INPUT holds the parameters for CIM_Fan.SetSpeed.
+type CIM_Fan_SetSpeed_INPUT struct {
+	DesiredSpeed *uint64 `wsman:",omitempty"`
+	Force        *bool   `wsman:",omitempty"`
+}
+
+// CIM_Fan_SetSpeed_OUTPUT holds the results of CIM_Fan.SetSpeed.
+type CIM_Fan_SetSpeed_OUTPUT struct {
+	ReturnValue uint32
+	Force       bool
+	ActualSpeed uint64
+	Messages    []string
+}
+
+// Values for CIM_Fan.SetSpeed ReturnValue.
+const (
+	CIM_Fan_SetSpeed_ReturnValue_Completed  uint32 = 0
+	CIM_Fan_SetSpeed_ReturnValue_Failed     uint32 = 1
+	CIM_Fan_SetSpeed_ReturnValue_JobStarted uint32 = 4096
+)
+
+// CIM_Fan_SetSpeed invokes SetSpeed on the instance of CIM_Fan picked by
+// selectors, which are name/value pairs. Sets the speed of the fan.
+func CIM_Fan_SetSpeed(c *wsman.Client, in *CIM_Fan_SetSpeed_INPUT, selectors ...string) (*CIM_Fan_SetSpeed_OUTPUT, error) {
+	msg := c.Invoke(CIM_Fan_ResourceURI, "SetSpeed").Selectors(selectors...)
+	if err := msg.MarshalParameters(in); err != nil {
+		return nil, err
+	}
+	reply, err := msg.Send()
+	if err != nil {
+		return nil, err
+	}
+	out := &CIM_Fan_SetSpeed_OUTPUT{}
+	return out, reply.UnmarshalOutput(out)
+}
@@ -0,0 +1,75 @@
+package fan_test
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/search"
+	"github.com/VictorLowther/wsman/wsmangen/internal/fan"
+	"github.com/VictorLowther/wsman/wsmantest"
+)
+
+const uri = fan.CIM_Fan_ResourceURI
+
+func param(req *wsmantest.Request, name string) string {
+	e := search.First(search.Tag(name, uri), req.AllBodyElements())
+	if e == nil {
+		return ""
+	}
+	return strings.TrimSpace(string(e.Content))
+}
+
+func TestSetSpeed(t *testing.T) {
+	s := wsmantest.NewServer()
+	defer s.Close()
+	s.HandleInvoke(uri, "SetSpeed", func(req *wsmantest.Request) (*dom.Element, error) {
+		if req.Selectors["DeviceID"] != "Fan.1" {
+			return nil, wsmantest.InvalidSelectors("No such fan")
+		}
+		return dom.Elem("SetSpeed_OUTPUT", uri).AddChild(
+			dom.ElemC("ReturnValue", uri, "0")).AddChild(
+			dom.ElemC("Force", uri, param(req, "Force"))).AddChild(
+			dom.ElemC("ActualSpeed", uri, param(req, "DesiredSpeed"))).AddChild(
+			dom.ElemC("Messages", uri, "Set")).AddChild(
+			dom.ElemC("Messages", uri, "Done")), nil
+	})
+	speed, force := uint64(4200), true
+	f := &fan.CIM_Fan{DeviceID: "Fan.1"}
+	out, err := fan.CIM_Fan_SetSpeed(s.NewClient(),
+		&fan.CIM_Fan_SetSpeed_INPUT{DesiredSpeed: &speed, Force: &force},
+		f.Selectors()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &fan.CIM_Fan_SetSpeed_OUTPUT{
+		ReturnValue: fan.CIM_Fan_SetSpeed_ReturnValue_Completed,
+		Force:       true,
+		ActualSpeed: 4200,
+		Messages:    []string{"Set", "Done"},
+	}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("Got %+v, wanted %+v", out, want)
+	}
+
+	if _, err := fan.CIM_Fan_SetSpeed(s.NewClient(), nil, "DeviceID", "Fan.2"); err == nil {
+		t.Error("Invoking on a missing fan should fail")
+	}
+}
@@ -0,0 +1,86 @@
+// wsmangen generates Go bindings for CIM classes from the MOF or class
+// XSD files that describe them.
+//
+// Each class gets a struct that works with wsman.Marshal and
+// wsman.Unmarshal, constants for the values in its ValueMaps, and a
+// function for each of its methods that sends the method's _INPUT
+// struct as parameters and decodes the reply into its _OUTPUT struct.
+package main
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var pkgName, outFile, uriPrefix string
+
+func init() {
+	flag.StringVar(&pkgName, "package", "classes", "The package name for the generated code")
+	flag.StringVar(&outFile, "o", "", "The file to write the generated code to.  Defaults to stdout")
+	flag.StringVar(&uriPrefix, "uri", "", `The ResourceURI prefix for classes read from MOF files.
+      Defaults to the usual one for the class name, such as the Dell one for DCIM_ classes`)
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] file.mof|file.xsd...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	classes := []*class{}
+	for _, name := range flag.Args() {
+		var found []*class
+		var err error
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".mof":
+			found, err = parseMOF(name)
+		case ".xsd":
+			found, err = parseXSD(name)
+		default:
+			err = fmt.Errorf("Do not know how to read %s, expected a .mof or .xsd file", name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		classes = append(classes, found...)
+	}
+	src, err := generate(pkgName, classes)
+	if err != nil {
+		if src != nil {
+			os.Stdout.Write(src)
+		}
+		log.Fatal(err)
+	}
+	if outFile == "" {
+		os.Stdout.Write(src)
+		return
+	}
+	if err := ioutil.WriteFile(outFile, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
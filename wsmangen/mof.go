@@ -0,0 +1,408 @@
+package main
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// This is a parser for just enough of the MOF syntax from DSP0004 to
+// pull class declarations out of the schemas vendors publish.
+// Qualifier declarations, instances, and pragmas are skipped.
+
+const (
+	tEOF = iota
+	tIdent
+	tString
+	tNumber
+	tPunct
+)
+
+type token struct {
+	kind int
+	text string
+	line int
+}
+
+func (t token) String() string {
+	if t.kind == tEOF {
+		return "end of file"
+	}
+	return strconv.Quote(t.text)
+}
+
+// lexMOF splits src into tokens, dropping comments and #pragma lines.
+func lexMOF(src string) ([]token, error) {
+	res := []token{}
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f':
+			i++
+		case strings.HasPrefix(src[i:], "//") || c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end == -1 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+end+4], "\n")
+			i += end + 4
+		case c == '"' || c == '\'':
+			j := i + 1
+			for ; j < len(src) && src[j] != c; j++ {
+				if src[j] == '\\' {
+					j++
+				}
+				if j < len(src) && src[j] == '\n' {
+					return nil, fmt.Errorf("line %d: unterminated string", line)
+				}
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			raw := src[i+1 : j]
+			if c == '\'' {
+				raw = strings.Replace(raw, `"`, `\"`, -1)
+			}
+			text, err := strconv.Unquote(`"` + raw + `"`)
+			if err != nil {
+				// MOF escapes are mostly C escapes, but fall back to
+				// the raw text rather than give up on the file.
+				text = src[i+1 : j]
+			}
+			res = append(res, token{tString, text, line})
+			i = j + 1
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i
+			for j < len(src) && (src[j] == '_' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			res = append(res, token{tIdent, src[i:j], line})
+			i = j
+		case c == '-' || c == '+' || c == '.' || unicode.IsDigit(rune(c)):
+			j := i + 1
+			for j < len(src) && (src[j] == '.' || src[j] == 'x' || src[j] == 'X' || unicode.IsDigit(rune(src[j])) ||
+				strings.ContainsRune("abcdefABCDEF", rune(src[j]))) {
+				j++
+			}
+			res = append(res, token{tNumber, src[i:j], line})
+			i = j
+		case strings.ContainsRune("[](){},;:=", rune(c)):
+			res = append(res, token{tPunct, string(c), line})
+			i++
+		default:
+			return nil, fmt.Errorf("line %d: unexpected character %q", line, c)
+		}
+	}
+	return append(res, token{tEOF, "", line}), nil
+}
+
+type mofParser struct {
+	file string
+	toks []token
+	pos  int
+}
+
+func (p *mofParser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *mofParser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *mofParser) errorf(t token, format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", p.file, t.line, fmt.Sprintf(format, args...))
+}
+
+// is reports whether the next token is the punctuation or keyword s.
+func (p *mofParser) is(s string) bool {
+	t := p.peek()
+	return (t.kind == tPunct || t.kind == tIdent) && strings.EqualFold(t.text, s)
+}
+
+func (p *mofParser) expect(s string) error {
+	if t := p.next(); !strings.EqualFold(t.text, s) || (t.kind != tPunct && t.kind != tIdent) {
+		return p.errorf(t, "expected %q, got %v", s, t)
+	}
+	return nil
+}
+
+func (p *mofParser) ident() (string, error) {
+	t := p.next()
+	if t.kind != tIdent {
+		return "", p.errorf(t, "expected a name, got %v", t)
+	}
+	return t.text, nil
+}
+
+// skipStatement skips everything up to the next ; outside of braces.
+func (p *mofParser) skipStatement() error {
+	depth := 0
+	for {
+		t := p.next()
+		switch {
+		case t.kind == tEOF:
+			return p.errorf(t, "unexpected end of file")
+		case t.text == "{" && t.kind == tPunct:
+			depth++
+		case t.text == "}" && t.kind == tPunct:
+			depth--
+		case t.text == ";" && t.kind == tPunct && depth <= 0:
+			return nil
+		}
+	}
+}
+
+// value parses a qualifier or default value.  Adjacent strings are
+// joined, and arrays are returned one value per item.
+func (p *mofParser) value() ([]string, error) {
+	if p.is("{") {
+		p.next()
+		res := []string{}
+		for !p.is("}") {
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, v...)
+			if p.is(",") {
+				p.next()
+			}
+		}
+		p.next()
+		return res, nil
+	}
+	t := p.next()
+	switch t.kind {
+	case tString:
+		s := t.text
+		for p.peek().kind == tString {
+			s += p.next().text
+		}
+		return []string{s}, nil
+	case tNumber, tIdent:
+		return []string{t.text}, nil
+	}
+	return nil, p.errorf(t, "expected a value, got %v", t)
+}
+
+// qualifiers parses a [Name, Name(value), Name{values}] list.  Names
+// are lowercased, since MOF does not care about their case.
+func (p *mofParser) qualifiers() (map[string][]string, error) {
+	res := map[string][]string{}
+	if !p.is("[") {
+		return res, nil
+	}
+	p.next()
+	for !p.is("]") {
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		val := []string{"true"}
+		switch {
+		case p.is("("):
+			p.next()
+			if val, err = p.value(); err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+		case p.is("{"):
+			if val, err = p.value(); err != nil {
+				return nil, err
+			}
+		}
+		// Flavors do not matter here.
+		if p.is(":") {
+			for p.next(); !p.is(",") && !p.is("]"); p.next() {
+				if p.peek().kind == tEOF {
+					return nil, p.errorf(p.peek(), "unexpected end of file")
+				}
+			}
+		}
+		res[strings.ToLower(name)] = val
+		if p.is(",") {
+			p.next()
+		}
+	}
+	p.next()
+	return res, nil
+}
+
+// typed parses "type [REF] name [[n]]" for properties and parameters.
+func (p *mofParser) typed(quals map[string][]string) (*property, error) {
+	typ, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	prop := newProperty(quals)
+	if cimTypes[strings.ToLower(typ)] != "" {
+		typ = strings.ToLower(typ)
+	}
+	prop.Type = typ
+	if p.is("ref") {
+		p.next()
+		prop.Ref = true
+	}
+	if prop.Name, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if p.is("[") {
+		p.next()
+		for !p.is("]") {
+			if p.next().kind == tEOF {
+				return nil, p.errorf(p.peek(), "unexpected end of file")
+			}
+		}
+		p.next()
+		prop.Array = true
+	}
+	if p.is("=") {
+		p.next()
+		if _, err := p.value(); err != nil {
+			return nil, err
+		}
+	}
+	if !prop.Ref && cimTypes[prop.Type] == "" {
+		return nil, p.errorf(p.peek(), "%s has unknown type %s", prop.Name, prop.Type)
+	}
+	return prop, nil
+}
+
+func (p *mofParser) class(quals map[string][]string) (*class, error) {
+	if err := p.expect("class"); err != nil {
+		return nil, err
+	}
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	res := &class{Name: name, Description: first(quals["description"])}
+	if p.is(":") {
+		p.next()
+		if res.Super, err = p.ident(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	for !p.is("}") {
+		quals, err := p.qualifiers()
+		if err != nil {
+			return nil, err
+		}
+		feature, err := p.typed(quals)
+		if err != nil {
+			return nil, err
+		}
+		if !p.is("(") {
+			res.Properties = append(res.Properties, feature)
+			if err := p.expect(";"); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		p.next()
+		m := &method{
+			Name:        feature.Name,
+			Type:        feature.Type,
+			Description: feature.Description,
+			ValueMap:    feature.ValueMap,
+			Values:      feature.Values,
+		}
+		for !p.is(")") {
+			quals, err := p.qualifiers()
+			if err != nil {
+				return nil, err
+			}
+			param, err := p.typed(quals)
+			if err != nil {
+				return nil, err
+			}
+			_, in := quals["in"]
+			_, out := quals["out"]
+			param.In = in || !out
+			param.Out = out
+			if in && quals["in"][0] == "false" {
+				param.In = false
+			}
+			m.Params = append(m.Params, param)
+			if p.is(",") {
+				p.next()
+			}
+		}
+		p.next()
+		if err := p.expect(";"); err != nil {
+			return nil, err
+		}
+		res.Methods = append(res.Methods, m)
+	}
+	p.next()
+	return res, p.expect(";")
+}
+
+// parseMOF parses the class declarations in a MOF file.
+func parseMOF(name string) ([]*class, error) {
+	src, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	toks, err := lexMOF(string(src))
+	if err != nil {
+		return nil, fmt.Errorf("%s:%v", name, err)
+	}
+	p := &mofParser{file: name, toks: toks}
+	res := []*class{}
+	for p.peek().kind != tEOF {
+		quals, err := p.qualifiers()
+		if err != nil {
+			return nil, err
+		}
+		if !p.is("class") {
+			if err := p.skipStatement(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		c, err := p.class(quals)
+		if err != nil {
+			return nil, err
+		}
+		c.ResourceURI = resourceURI(c.Name)
+		res = append(res, c)
+	}
+	return res, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Class XSDs, as described by DSP0230, have an element named after the
+// class whose type lists the properties, and an element for the
+// Method_INPUT and Method_OUTPUT of each method.  They have no
+// qualifiers, so there are no ValueMap constants or descriptions for
+// classes read from them.
+
+type xsdElement struct {
+	Name        string          `xml:"name,attr"`
+	Type        string          `xml:"type,attr"`
+	Ref         string          `xml:"ref,attr"`
+	MaxOccurs   string          `xml:"maxOccurs,attr"`
+	ComplexType *xsdComplexType `xml:"complexType"`
+}
+
+type xsdComplexType struct {
+	Name     string       `xml:"name,attr"`
+	Sequence []xsdElement `xml:"sequence>element"`
+	Extended []xsdElement `xml:"complexContent>extension>sequence>element"`
+}
+
+type xsdSchema struct {
+	TargetNamespace string           `xml:"targetNamespace,attr"`
+	Elements        []xsdElement     `xml:"element"`
+	ComplexTypes    []xsdComplexType `xml:"complexType"`
+}
+
+// xsdTypes maps the cim: and xs: types used in class XSDs to CIM types.
+// Anything else is treated as a string.
+var xsdTypes = map[string]string{
+	"cimBoolean":       "boolean",
+	"boolean":          "boolean",
+	"cimChar16":        "char16",
+	"cimDateTime":      "datetime",
+	"dateTime":         "datetime",
+	"cimUnsignedByte":  "uint8",
+	"unsignedByte":     "uint8",
+	"cimUnsignedShort": "uint16",
+	"unsignedShort":    "uint16",
+	"cimUnsignedInt":   "uint32",
+	"unsignedInt":      "uint32",
+	"cimUnsignedLong":  "uint64",
+	"unsignedLong":     "uint64",
+	"cimByte":          "sint8",
+	"byte":             "sint8",
+	"cimShort":         "sint16",
+	"short":            "sint16",
+	"cimInt":           "sint32",
+	"int":              "sint32",
+	"cimLong":          "sint64",
+	"long":             "sint64",
+	"cimFloat":         "real32",
+	"float":            "real32",
+	"cimDouble":        "real64",
+	"double":           "real64",
+}
+
+func localName(qname string) string {
+	return qname[strings.LastIndex(qname, ":")+1:]
+}
+
+type xsdReader struct {
+	elements map[string]xsdElement
+	types    map[string]xsdComplexType
+}
+
+// property makes a property from an element in a sequence.
+func (r *xsdReader) property(e xsdElement) *property {
+	if e.Ref != "" {
+		if found, ok := r.elements[localName(e.Ref)]; ok {
+			found.MaxOccurs = e.MaxOccurs
+			e = found
+		}
+	}
+	typ := localName(e.Type)
+	res := &property{Name: e.Name, Array: e.MaxOccurs != "" && e.MaxOccurs != "1"}
+	switch {
+	case typ == "cimReference" || typ == "EndpointReferenceType":
+		res.Ref = true
+	case typ == "cimHexBinary" || typ == "hexBinary" || typ == "cimBase64Binary" || typ == "base64Binary":
+		res.Type, res.OctetString = "string", true
+	case xsdTypes[typ] != "":
+		res.Type = xsdTypes[typ]
+	default:
+		res.Type = "string"
+	}
+	return res
+}
+
+// sequence lists the elements of an element's complex type.
+func (r *xsdReader) sequence(e xsdElement) []xsdElement {
+	ct := e.ComplexType
+	if ct == nil {
+		found, ok := r.types[localName(e.Type)]
+		if !ok {
+			return nil
+		}
+		ct = &found
+	}
+	return append(ct.Sequence, ct.Extended...)
+}
+
+// parseXSD reads the class described by a class XSD.
+func parseXSD(name string) ([]*class, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	schema := &xsdSchema{}
+	if err := xml.NewDecoder(f).Decode(schema); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %v", name, err)
+	}
+	if schema.TargetNamespace == "" {
+		return nil, fmt.Errorf("%s has no targetNamespace", name)
+	}
+	r := &xsdReader{elements: map[string]xsdElement{}, types: map[string]xsdComplexType{}}
+	for _, e := range schema.Elements {
+		r.elements[e.Name] = e
+	}
+	for _, ct := range schema.ComplexTypes {
+		r.types[ct.Name] = ct
+	}
+	c := &class{
+		Name:        schema.TargetNamespace[strings.LastIndex(schema.TargetNamespace, "/")+1:],
+		ResourceURI: schema.TargetNamespace,
+	}
+	classElem, ok := r.elements[c.Name]
+	if !ok {
+		return nil, fmt.Errorf("%s has no element for class %s", name, c.Name)
+	}
+	for _, e := range r.sequence(classElem) {
+		c.Properties = append(c.Properties, r.property(e))
+	}
+	methods := map[string]*method{}
+	for _, e := range schema.Elements {
+		var methodName string
+		var in bool
+		switch {
+		case strings.HasSuffix(e.Name, "_INPUT"):
+			methodName, in = strings.TrimSuffix(e.Name, "_INPUT"), true
+		case strings.HasSuffix(e.Name, "_OUTPUT"):
+			methodName = strings.TrimSuffix(e.Name, "_OUTPUT")
+		default:
+			continue
+		}
+		m := methods[methodName]
+		if m == nil {
+			m = &method{Name: methodName}
+			methods[methodName] = m
+			c.Methods = append(c.Methods, m)
+		}
+		for _, param := range r.sequence(e) {
+			p := r.property(param)
+			if !in && p.Name == "ReturnValue" {
+				m.Type = p.Type
+				continue
+			}
+			p.In, p.Out = in, !in
+			m.Params = append(m.Params, p)
+		}
+	}
+	return []*class{c}, nil
+}
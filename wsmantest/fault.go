@@ -0,0 +1,194 @@
+package wsmantest
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"fmt"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/wsman"
+)
+
+const (
+	// Action of faults defined by WS-Addressing
+	ADDRESSING_FAULT = "http://schemas.xmlsoap.org/ws/2004/08/addressing/fault"
+
+	// Action of faults defined by WS-Management
+	WSMAN_FAULT = "http://schemas.dmtf.org/wbem/wsman/1/wsman/fault"
+
+	// Action of faults defined by WS-Enumeration
+	ENUMERATION_FAULT = "http://schemas.xmlsoap.org/ws/2004/09/enumeration/fault"
+)
+
+// Fault is an error that will be sent back to the client as a SOAP
+// fault.  See DSP0226 section 14 for the standard ones.
+type Fault struct {
+	// Action is the Action header of the fault message.
+	Action string
+	// Code is either Sender or Receiver.
+	Code string
+	// Subcode is the QName of the fault subcode, such as
+	// wsman:InvalidSelectors.
+	Subcode string
+	Reason  string
+	// Detail, if set, is sent as the wsman:FaultDetail.
+	Detail string
+	// Status is the HTTP status the fault is sent with.  It defaults
+	// to 500.
+	Status int
+}
+
+func (f *Fault) Error() string {
+	return fmt.Sprintf("%s: %s", f.Subcode, f.Reason)
+}
+
+// Element turns the fault into the body of a SOAP message.
+func (f *Fault) Element() *dom.Element {
+	code := dom.Elem("Code", wsman.NS_SOAP).AddChild(
+		dom.ElemC("Value", wsman.NS_SOAP, "s:"+f.Code))
+	if f.Subcode != "" {
+		code.AddChild(dom.Elem("Subcode", wsman.NS_SOAP).AddChild(
+			dom.ElemC("Value", wsman.NS_SOAP, f.Subcode)))
+	}
+	res := dom.Elem("Fault", wsman.NS_SOAP).AddChild(code)
+	res.AddChild(dom.Elem("Reason", wsman.NS_SOAP).AddChild(
+		dom.ElemC("Text", wsman.NS_SOAP, f.Reason).Attr("lang", "http://www.w3.org/XML/1998/namespace", "en-US")))
+	if f.Detail != "" {
+		res.AddChild(dom.Elem("Detail", wsman.NS_SOAP).AddChild(
+			dom.ElemC("FaultDetail", wsman.NS_WSMAN, f.Detail)))
+	}
+	return res
+}
+
+func (f *Fault) status() int {
+	if f.Status == 0 {
+		return 500
+	}
+	return f.Status
+}
+
+// ActionNotSupported is returned when a resource has no handler for
+// the requested action.
+func ActionNotSupported(action string) *Fault {
+	return &Fault{
+		Action:  ADDRESSING_FAULT,
+		Code:    "Sender",
+		Subcode: "wsa:ActionNotSupported",
+		Reason:  fmt.Sprintf("The action %s is not supported by the service.", action),
+	}
+}
+
+// DestinationUnreachable is returned for resources with no handlers.
+func DestinationUnreachable(resource string) *Fault {
+	return &Fault{
+		Action:  ADDRESSING_FAULT,
+		Code:    "Sender",
+		Subcode: "wsa:DestinationUnreachable",
+		Reason:  fmt.Sprintf("No route can be determined to reach %s.", resource),
+	}
+}
+
+// InvalidSelectors is returned when the selectors do not identify an
+// instance.
+func InvalidSelectors(reason string) *Fault {
+	return &Fault{
+		Action:  WSMAN_FAULT,
+		Code:    "Sender",
+		Subcode: "wsman:InvalidSelectors",
+		Reason:  reason,
+	}
+}
+
+// AlreadyExists is returned when Create would make a duplicate.
+func AlreadyExists(reason string) *Fault {
+	return &Fault{
+		Action:  WSMAN_FAULT,
+		Code:    "Sender",
+		Subcode: "wsman:AlreadyExists",
+		Reason:  reason,
+	}
+}
+
+// InvalidEnumerationContext is returned for Pull and Release with an
+// unknown or expired enumeration context.
+func InvalidEnumerationContext() *Fault {
+	return &Fault{
+		Action:  ENUMERATION_FAULT,
+		Code:    "Receiver",
+		Subcode: "wsen:InvalidEnumerationContext",
+		Reason:  "The supplied enumeration context is invalid.",
+	}
+}
+
+// CannotProcessFilter is returned for filters the server does not
+// understand.
+func CannotProcessFilter(reason string) *Fault {
+	return &Fault{
+		Action:  ENUMERATION_FAULT,
+		Code:    "Sender",
+		Subcode: "wsen:CannotProcessFilter",
+		Reason:  reason,
+	}
+}
+
+// EncodingLimit is returned when a message is too large.  detail
+// should be one of the faultDetail URIs, such as
+// http://schemas.dmtf.org/wbem/wsman/1/wsman/faultDetail/MaxEnvelopeSize
+func EncodingLimit(detail string) *Fault {
+	return &Fault{
+		Action:  WSMAN_FAULT,
+		Code:    "Sender",
+		Subcode: "wsman:EncodingLimit",
+		Reason:  "An internal encoding limit was exceeded in a request or would be violated if the message were processed.",
+		Detail:  detail,
+	}
+}
+
+// TimedOut is returned when an operation takes too long.
+func TimedOut() *Fault {
+	return &Fault{
+		Action:  WSMAN_FAULT,
+		Code:    "Receiver",
+		Subcode: "wsman:TimedOut",
+		Reason:  "The operation has timed out.",
+	}
+}
+
+// InternalError is returned for errors from handlers that are not
+// already Faults.
+func InternalError(reason string) *Fault {
+	return &Fault{
+		Action:  WSMAN_FAULT,
+		Code:    "Receiver",
+		Subcode: "wsman:InternalError",
+		Reason:  reason,
+	}
+}
+
+// UnsupportedFeature is returned for requests that use an optional
+// part of WS-Management the server does not do.  detail should be one
+// of the faultDetail URIs, such as
+// http://schemas.dmtf.org/wbem/wsman/1/wsman/faultDetail/FragmentLevelAccess
+func UnsupportedFeature(detail string) *Fault {
+	return &Fault{
+		Action:  WSMAN_FAULT,
+		Code:    "Sender",
+		Subcode: "wsman:UnsupportedFeature",
+		Reason:  "The specified feature is not supported.",
+		Detail:  detail,
+	}
+}
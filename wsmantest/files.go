@@ -0,0 +1,153 @@
+package wsmantest
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Files is the file system of a Windows host, as far as the commands
+// wsman.FileCopier runs can tell.  Pass its Run method to HandleShell
+// to serve them.
+type Files struct {
+	// BadChecksums makes the host report checksums that never match
+	// the data.
+	BadChecksums bool
+	mu           sync.Mutex
+	files        map[string][]byte
+}
+
+// NewFiles makes an empty Files.
+func NewFiles() *Files {
+	return &Files{files: map[string][]byte{}}
+}
+
+// Get returns the content of the named file, and whether there is one.
+func (f *Files) Get(name string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.files[name]
+	return append([]byte{}, data...), ok
+}
+
+// Put sets the content of the named file.
+func (f *Files) Put(name string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[name] = append([]byte{}, data...)
+}
+
+// psString matches a PowerShell literal string.
+const psString = `'((?:[^']|'')*)'`
+
+var (
+	truncateCmd = regexp.MustCompile(`^type NUL > "([^"]+)"$`)
+	appendCmd   = regexp.MustCompile(`^echo (\S+) >> "([^"]+)"$`)
+	decodeCmd   = regexp.MustCompile(`ExpandEnvironmentVariables\(` + psString + `\).*WriteAllBytes\(` + psString + `, \$b\)`)
+	lengthCmd   = regexp.MustCompile(`^\(Get-Item -LiteralPath ` + psString + `\)\.Length$`)
+	readCmd     = regexp.MustCompile(`OpenRead\(` + psString + `\); \[void\]\$f\.Seek\((\d+), 0\); \$b = New-Object byte\[\] (\d+)`)
+	checksumCmd = regexp.MustCompile(`OpenRead\(` + psString + `\); \$h = `)
+)
+
+func unquote(s string) string {
+	return strings.Replace(s, "''", "'", -1)
+}
+
+// expand expands the environment variables cmd.exe would.
+func expand(s string) string {
+	return strings.Replace(s, "%TEMP%", `C:\Windows\Temp`, -1)
+}
+
+// Run runs the cmd.exe and PowerShell commands that wsman.FileCopier
+// uses.  Anything else fails the way cmd.exe does for unknown
+// commands.
+func (f *Files) Run(cmd *ShellCommand) (stdout, stderr []byte, exitCode int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	line := cmd.Command
+	if len(cmd.Arguments) > 0 {
+		line += " " + strings.Join(cmd.Arguments, " ")
+	}
+	if m := truncateCmd.FindStringSubmatch(line); m != nil {
+		f.files[expand(m[1])] = []byte{}
+		return nil, nil, 0
+	}
+	if m := appendCmd.FindStringSubmatch(line); m != nil {
+		name := expand(m[2])
+		if _, ok := f.files[name]; !ok {
+			return nil, []byte("The system cannot find the path specified."), 1
+		}
+		f.files[name] = append(f.files[name], m[1]+" \r\n"...)
+		return nil, nil, 0
+	}
+	script := cmd.Script()
+	if script == "" {
+		return nil, []byte(fmt.Sprintf("'%s' is not recognized as an internal or external command", cmd.Command)), 1
+	}
+	// Scripts start with the same preferences.
+	script = script[strings.Index(script, "'Stop'; ")+len("'Stop'; "):]
+	out := ""
+	if m := decodeCmd.FindStringSubmatch(script); m != nil {
+		tmp := expand(unquote(m[1]))
+		data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(f.files[tmp])), ""))
+		if err != nil {
+			return nil, []byte(err.Error()), 1
+		}
+		f.files[unquote(m[2])] = data
+		delete(f.files, tmp)
+		script = script[strings.Index(script, "; $s = ")+2:]
+	}
+	switch {
+	case lengthCmd.MatchString(script):
+		data, ok := f.files[unquote(lengthCmd.FindStringSubmatch(script)[1])]
+		if !ok {
+			return nil, []byte("Cannot find path because it does not exist."), 1
+		}
+		out = strconv.Itoa(len(data))
+	case readCmd.MatchString(script):
+		m := readCmd.FindStringSubmatch(script)
+		data := f.files[unquote(m[1])]
+		start, _ := strconv.Atoi(m[2])
+		size, _ := strconv.Atoi(m[3])
+		if start > len(data) {
+			start = len(data)
+		}
+		if end := start + size; end < len(data) {
+			data = data[:end]
+		}
+		out = base64.StdEncoding.EncodeToString(data[start:])
+	case checksumCmd.MatchString(script):
+		data, ok := f.files[unquote(checksumCmd.FindStringSubmatch(script)[1])]
+		if !ok {
+			return nil, []byte("Cannot find path because it does not exist."), 1
+		}
+		sum := sha256.Sum256(data)
+		if f.BadChecksums {
+			sum[0]++
+		}
+		out = strings.ToUpper(fmt.Sprintf("%x", sum))
+	default:
+		return nil, []byte("Cannot run " + script), 1
+	}
+	return []byte(out + "\r\n"), nil, 0
+}
@@ -0,0 +1,157 @@
+package wsmantest
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+// Failure makes a request go wrong on purpose.  It returns true if it
+// took care of responding to the request, or false if the request
+// should be handled as usual afterwards.
+type Failure func(s *Server, w http.ResponseWriter, req *Request) bool
+
+type injection struct {
+	resource, action string
+	times            int
+	failure          Failure
+}
+
+// Inject makes the next times requests for action on resource fail
+// with failure.  An empty resource or action matches any, and times
+// of 0 or less makes every matching request fail.  Injections are
+// tried in the order they were added, and only the first matching
+// one is used for a request.
+//
+// To make the first Pull of an enumeration find its context expired:
+//
+//	s.Inject("", wsman.PULL, 1, wsmantest.ExpireContext)
+func (s *Server) Inject(resource, action string, times int, failure Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.injections = append(s.injections, &injection{
+		resource: resource,
+		action:   action,
+		times:    times,
+		failure:  failure,
+	})
+}
+
+// ClearInjections removes every pending injected failure.
+func (s *Server) ClearInjections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.injections = nil
+}
+
+// injected finds the failure to use for req, if any.
+func (s *Server) injected(req *Request) Failure {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, inj := range s.injections {
+		if (inj.resource != "" && inj.resource != req.ResourceURI) ||
+			(inj.action != "" && inj.action != req.Action) {
+			continue
+		}
+		if inj.times > 0 {
+			inj.times--
+			if inj.times == 0 {
+				s.injections = append(s.injections[:i], s.injections[i+1:]...)
+			}
+		}
+		return inj.failure
+	}
+	return nil
+}
+
+// StaleNonce makes the server pick a new digest nonce and answer with
+// a 401 that marks the old one as stale, so the client has to
+// reauthorize and send the request again.  With basic auth it is a
+// plain 401.
+func StaleNonce(s *Server, w http.ResponseWriter, req *Request) bool {
+	s.mu.Lock()
+	s.nonce = newNonce()
+	s.mu.Unlock()
+	s.challenge(w, s.Digest)
+	return true
+}
+
+// SendFault answers the request with f instead of calling its
+// handler.
+func SendFault(f *Fault) Failure {
+	return func(s *Server, w http.ResponseWriter, req *Request) bool {
+		s.sendFault(w, req, f)
+		return true
+	}
+}
+
+// Truncate handles the request as usual, but cuts the body of the
+// response off after n bytes.
+func Truncate(n int) Failure {
+	return func(s *Server, w http.ResponseWriter, req *Request) bool {
+		rec := httptest.NewRecorder()
+		s.dispatch(rec, req)
+		for key, vals := range rec.Header() {
+			w.Header()[key] = vals
+		}
+		w.WriteHeader(rec.Code)
+		body := rec.Body.Bytes()
+		if n < len(body) {
+			body = body[:n]
+		}
+		w.Write(body)
+		return true
+	}
+}
+
+// Delay waits for d before handling the request as usual.
+func Delay(d time.Duration) Failure {
+	return func(s *Server, w http.ResponseWriter, req *Request) bool {
+		time.Sleep(d)
+		return false
+	}
+}
+
+// ExpireContext throws away the enumeration context of a Pull or
+// Release before it is handled, as if it had expired.
+func ExpireContext(s *Server, w http.ResponseWriter, req *Request) bool {
+	s.mu.Lock()
+	delete(s.enums, req.Context)
+	s.mu.Unlock()
+	return false
+}
+
+// ResetConnection drops the connection without responding.  Where it
+// can, it makes the connection close with a TCP reset.
+func ResetConnection(s *Server, w http.ResponseWriter, req *Request) bool {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		panic("wsmantest: ResetConnection needs a connection that can be hijacked")
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		panic(err)
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+	return true
+}
@@ -0,0 +1,147 @@
+package wsmantest
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/wsman"
+)
+
+func getFan(client *wsman.Client, id string) (*wsman.Message, error) {
+	return client.Get(fanURI).Selectors("DeviceID", id).Send()
+}
+
+func TestInjectTimes(t *testing.T) {
+	s := fanServer(false)
+	defer s.Close()
+	client := s.NewClient()
+	s.Inject(fanURI, wsman.GET, 2, SendFault(TimedOut()))
+	for i := 0; i < 2; i++ {
+		reply, err := getFan(client, "Fan.1")
+		if err == nil || reply.FaultSubcode() != "TimedOut" {
+			t.Fatalf("Get %d got %v, wanted TimedOut", i, err)
+		}
+	}
+	if _, err := getFan(client, "Fan.1"); err != nil {
+		t.Errorf("The injection should have been used up: %v", err)
+	}
+
+	// Injections for other resources or actions do not match, and
+	// the first one that matches wins.
+	s.Inject(fanURI+"X", "", 0, SendFault(TimedOut()))
+	s.Inject("", wsman.PUT, 0, SendFault(TimedOut()))
+	s.Inject("", "", 1, SendFault(InternalError("first")))
+	s.Inject("", "", 1, SendFault(InternalError("second")))
+	for _, want := range []string{"first", "second"} {
+		reply, err := getFan(client, "Fan.1")
+		if err == nil || reply.FaultReason() != want {
+			t.Errorf("Got %v, wanted the %s injection", err, want)
+		}
+	}
+	s.ClearInjections()
+	s.Inject("", "", 0, SendFault(TimedOut()))
+	s.ClearInjections()
+	if _, err := getFan(client, "Fan.1"); err != nil {
+		t.Errorf("ClearInjections left one behind: %v", err)
+	}
+}
+
+func TestInjectStaleNonce(t *testing.T) {
+	s := fanServer(true)
+	defer s.Close()
+	client := s.NewClient()
+	s.Inject("", "", 1, StaleNonce)
+	if _, err := getFan(client, "Fan.1"); err != nil {
+		t.Fatalf("The client should reauthorize after a stale nonce: %v", err)
+	}
+	// Only once, though.
+	s.Inject("", "", 2, StaleNonce)
+	if _, err := getFan(client, "Fan.1"); err == nil {
+		t.Error("Two stale nonces in a row should fail")
+	}
+}
+
+func TestInjectBrokenResponses(t *testing.T) {
+	s := fanServer(false)
+	defer s.Close()
+	client := s.NewClient()
+	s.Inject("", "", 1, Truncate(100))
+	if _, err := getFan(client, "Fan.1"); err == nil {
+		t.Error("A truncated response should not parse")
+	}
+	s.Inject("", "", 1, ResetConnection)
+	if _, err := getFan(client, "Fan.1"); err == nil {
+		t.Error("A reset connection should fail")
+	}
+	if _, err := getFan(client, "Fan.1"); err != nil {
+		t.Errorf("The client should recover after the failures: %v", err)
+	}
+}
+
+func TestInjectDelay(t *testing.T) {
+	s := fanServer(false)
+	defer s.Close()
+	client := s.NewClient()
+	s.Inject("", "", 1, Delay(50*time.Millisecond))
+	start := time.Now()
+	if _, err := getFan(client, "Fan.1"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Delayed request took only %v", elapsed)
+	}
+	client.Timeout = 10 * time.Millisecond
+	s.Inject("", "", 1, Delay(50*time.Millisecond))
+	if _, err := getFan(client, "Fan.1"); err == nil {
+		t.Error("A request delayed past the client timeout should fail")
+	}
+}
+
+func TestInjectExpireContext(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.HandleEnumerate(fanURI, func(req *Request) ([]*dom.Element, error) {
+		return []*dom.Element{
+			dom.Elem("CIM_Fan", fanURI).AddChild(dom.ElemC("DeviceID", fanURI, "Fan.1")),
+			dom.Elem("CIM_Fan", fanURI).AddChild(dom.ElemC("DeviceID", fanURI, "Fan.2")),
+		}, nil
+	})
+	client := s.NewClient()
+	s.Inject("", wsman.PULL, 1, ExpireContext)
+	_, err := client.Enumerate(fanURI).Send()
+	if err == nil || !strings.Contains(err.Error(), "InvalidEnumerationContext") {
+		t.Errorf("Got %v, wanted InvalidEnumerationContext", err)
+	}
+	reply, err := client.Enumerate(fanURI).Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items, _ := reply.EnumItems(); len(items) != 2 {
+		t.Errorf("Enumerated %d fans, wanted 2", len(items))
+	}
+
+	// Contexts also expire on their own.
+	s.ContextExpiry = time.Millisecond
+	s.Inject("", wsman.PULL, 1, Delay(10*time.Millisecond))
+	if _, err := client.Enumerate(fanURI).Send(); err == nil {
+		t.Error("Pull after the context expired should fail")
+	}
+}
@@ -0,0 +1,297 @@
+package wsmantest
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/soap"
+	"github.com/VictorLowther/wsman"
+)
+
+const (
+	scrubbedID = "uuid:00000000-0000-0000-0000-000000000000"
+	scrubbed   = "SCRUBBED"
+)
+
+var messageIDs = regexp.MustCompile(`(<(?:[\w.-]+:)?(?:MessageID|RelatesTo)\b[^>]*>)[^<]*`)
+
+// Exchange is a single recorded SOAP request and its response.
+type Exchange struct {
+	Action      string
+	ResourceURI string
+	Selectors   map[string]string `json:",omitempty"`
+	Request     string
+	Status      int
+	ContentType string
+	Response    string
+	request     *soap.Message
+}
+
+// canonical renders elements in a form that only depends on their
+// names, attributes, content, and children, so that envelopes that
+// were encoded differently can be compared.
+func canonical(elems []*dom.Element) string {
+	parts := []string{}
+	for _, e := range elems {
+		attrs := []string{}
+		for _, attr := range e.Attributes {
+			if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" {
+				continue
+			}
+			attrs = append(attrs, fmt.Sprintf("{%s}%s=%q", attr.Name.Space, attr.Name.Local, attr.Value))
+		}
+		sort.Strings(attrs)
+		parts = append(parts, fmt.Sprintf("{%s}%s[%s]%q(%s)",
+			e.Name.Space, e.Name.Local, strings.Join(attrs, ","),
+			strings.TrimSpace(string(e.Content)), canonical(e.Children())))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (x *Exchange) parse() error {
+	msg, err := soap.Parse(strings.NewReader(x.Request))
+	if err != nil {
+		return err
+	}
+	x.request = msg
+	x.Action = headerContent(msg, "Action", wsman.NS_WSA)
+	x.ResourceURI = headerContent(msg, "ResourceURI", wsman.NS_WSMAN)
+	x.Selectors = namedSet(msg, "SelectorSet")
+	if len(x.Selectors) == 0 {
+		x.Selectors = nil
+	}
+	return nil
+}
+
+// matches checks whether other is the same request as x.
+func (x *Exchange) matches(other *Exchange) bool {
+	return x.Action == other.Action &&
+		x.ResourceURI == other.ResourceURI &&
+		reflect.DeepEqual(x.Selectors, other.Selectors) &&
+		canonical(x.request.Body()) == canonical(other.request.Body())
+}
+
+// Recorder is an http.RoundTripper that saves every SOAP exchange
+// made through it to a directory, one JSON file per exchange, for
+// Replayer to serve back later.  Authorization headers are never
+// saved, and MessageIDs are scrubbed so that recordings are stable.
+//
+// To record a session, wrap the Transport of a wsman.Client:
+//
+//	client.Transport = wsmantest.NewRecorder("testdata/idrac", client.Transport)
+//
+// and replay it with a Client from Replayer.Connect.
+type Recorder struct {
+	Dir       string
+	Transport http.RoundTripper
+	// Secrets are replaced with SCRUBBED wherever they appear in
+	// the saved exchanges.
+	Secrets []string
+	mu      sync.Mutex
+	count   int
+}
+
+// NewRecorder makes a Recorder that saves exchanges made through
+// transport to dir.
+func NewRecorder(dir string, transport http.RoundTripper) *Recorder {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &Recorder{Dir: dir, Transport: transport}
+}
+
+func (r *Recorder) scrub(s string) string {
+	s = messageIDs.ReplaceAllString(s, "${1}"+scrubbedID)
+	for _, secret := range r.Secrets {
+		if secret != "" {
+			s = strings.Replace(s, secret, scrubbed, -1)
+		}
+	}
+	return s
+}
+
+func (r *Recorder) save(x *Exchange) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := os.MkdirAll(r.Dir, 0755); err != nil {
+		return err
+	}
+	r.count++
+	buf, err := json.MarshalIndent(x, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(r.Dir, fmt.Sprintf("%04d.json", r.count)), buf, 0644)
+}
+
+// RoundTrip makes Recorder an http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody := []byte{}
+	if req.Body != nil {
+		var err error
+		if reqBody, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+	res, err := r.Transport.RoundTrip(req)
+	if err != nil || res.StatusCode == http.StatusUnauthorized {
+		return res, err
+	}
+	resBody, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(resBody))
+	x := &Exchange{
+		Request:     r.scrub(string(reqBody)),
+		Status:      res.StatusCode,
+		ContentType: res.Header.Get("Content-Type"),
+		Response:    r.scrub(string(resBody)),
+	}
+	if err := x.parse(); err != nil {
+		// Not a SOAP request, so there is nothing to replay.
+		return res, nil
+	}
+	if err := r.save(x); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Replayer is an http.RoundTripper that answers requests with the
+// responses a Recorder saved.  A request gets the response of the
+// first unused exchange with the same Action, ResourceURI, selectors,
+// and body.  Once every matching exchange has been used, the last one
+// is used again.
+type Replayer struct {
+	mu        sync.Mutex
+	exchanges []*Exchange
+	used      []bool
+}
+
+// NewReplayer loads the exchanges a Recorder saved in dir.
+func NewReplayer(dir string) (*Replayer, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	res := &Replayer{}
+	for _, file := range files {
+		buf, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		x := &Exchange{}
+		if err := json.Unmarshal(buf, x); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		if err := x.parse(); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		res.exchanges = append(res.exchanges, x)
+	}
+	res.used = make([]bool, len(res.exchanges))
+	return res, nil
+}
+
+func (r *Replayer) find(x *Exchange) *Exchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	last := -1
+	for i, candidate := range r.exchanges {
+		if !candidate.matches(x) {
+			continue
+		}
+		if !r.used[i] {
+			r.used[i] = true
+			return candidate
+		}
+		last = i
+	}
+	if last == -1 {
+		return nil
+	}
+	return r.exchanges[last]
+}
+
+func response(req *http.Request, status int, contentType, body string) *http.Response {
+	res := &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+	if contentType != "" {
+		res.Header.Set("Content-Type", contentType)
+	}
+	return res
+}
+
+// Connect makes a wsman.Client that is answered by the Replayer and
+// never touches the network, not even to fetch the digest challenge.
+func (r *Replayer) Connect(endpoint, username, password string, digest bool) (*wsman.Client, error) {
+	return wsman.ConnectWith(endpoint, username, password, digest, r)
+}
+
+// RoundTrip makes Replayer an http.RoundTripper.  Requests that are
+// not SOAP messages, like the one wsman.ConnectWith uses to fetch a
+// digest challenge, get a 401 with a digest challenge.  Setting the
+// Transport of a Client that was made with Connect or NewClient is too
+// late for that, so use Replayer.Connect or wsman.ConnectWith.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := []byte{}
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	x := &Exchange{Request: string(body)}
+	if len(bytes.TrimSpace(body)) == 0 || x.parse() != nil {
+		res := response(req, http.StatusUnauthorized, "", "")
+		res.Header.Set("WWW-Authenticate", `Digest realm="replay", nonce="replay", qop="auth", algorithm="MD5"`)
+		return res, nil
+	}
+	found := r.find(x)
+	if found == nil {
+		return nil, fmt.Errorf("wsmantest: no recorded exchange for %s on %s %v", x.Action, x.ResourceURI, x.Selectors)
+	}
+	return response(req, found.Status, found.ContentType, found.Response), nil
+}
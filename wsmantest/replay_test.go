@@ -0,0 +1,103 @@
+package wsmantest
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/dom"
+)
+
+const fanURI = "http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_Fan"
+
+func fanServer(digest bool) *Server {
+	s := NewServer()
+	s.Username, s.Password, s.Digest = "root", "calvin", digest
+	s.HandleGet(fanURI, func(req *Request) (*dom.Element, error) {
+		id := req.Selectors["DeviceID"]
+		if id == "" {
+			return nil, InvalidSelectors("No DeviceID")
+		}
+		return dom.Elem("CIM_Fan", fanURI).AddChild(dom.ElemC("DeviceID", fanURI, id)), nil
+	})
+	return s
+}
+
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wsmantest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := fanServer(true)
+	endpoint := s.Endpoint()
+	client := s.NewClient()
+	rec := NewRecorder(dir, client.Transport)
+	rec.Secrets = []string{"calvin"}
+	client.Transport = rec
+	if _, err := client.Get(fanURI).Selectors("DeviceID", "Fan.1").Send(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(fanURI).Send(); err == nil {
+		t.Fatal("Get without selectors should have faulted")
+	}
+	s.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 2 {
+		t.Fatalf("Recorded %d exchanges, wanted 2", len(files))
+	}
+	for _, file := range files {
+		buf, _ := ioutil.ReadFile(file)
+		if strings.Contains(string(buf), "calvin") {
+			t.Errorf("%s has the password in it", file)
+		}
+	}
+
+	// The server is gone, so this only works if nothing, including the
+	// digest challenge, goes over the network.
+	r, err := NewReplayer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err = r.Connect(endpoint, "root", "calvin", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := client.Get(fanURI).Selectors("DeviceID", "Fan.1").Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err := reply.GetItem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(item.Children()[0].Content); got != "Fan.1" {
+		t.Errorf("Replayed DeviceID %q, wanted Fan.1", got)
+	}
+	reply, err = client.Get(fanURI).Send()
+	if err == nil || reply == nil || reply.FaultSubcode() != "InvalidSelectors" {
+		t.Errorf("Replayed fault was %v", err)
+	}
+	if _, err := client.Get(fanURI).Selectors("DeviceID", "Fan.2").Send(); err == nil {
+		t.Error("Get of an exchange that was never recorded should fail")
+	}
+}
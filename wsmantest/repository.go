@@ -0,0 +1,613 @@
+package wsmantest
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/search"
+	"github.com/VictorLowther/wsman"
+)
+
+const (
+	// Filter dialect that matches on key and property values
+	SELECTOR_FILTER = "http://schemas.dmtf.org/wbem/wsman/1/wsman/SelectorFilter"
+
+	// Filter dialect for WQL queries
+	WQL_FILTER = "http://schemas.microsoft.com/wbem/wsman/1/WQL"
+
+	// Filter dialect for CQL queries
+	CQL_FILTER = "http://schemas.dmtf.org/wbem/cql/1/dsp0202.pdf"
+)
+
+// Instance is a CIM instance held in a Repository.
+type Instance struct {
+	ResourceURI string
+	// Selectors has the values of the key properties of the instance.
+	Selectors map[string]string
+	Element   *dom.Element
+}
+
+// Repository is an in-memory store of CIM instances that can back the
+// Get, Put, Create, Delete, and Enumerate operations of a Server.  Get
+// and Put do fragment transfer of properties picked by name, or of
+// several joined with "|".
+type Repository struct {
+	// NoFragmentTransfer makes Get and Put fault fragment transfer
+	// requests, like endpoints that do not support it.
+	NoFragmentTransfer bool
+	mu                 sync.Mutex
+	// keys has the names of the key properties of each resource.
+	keys      map[string][]string
+	instances []*Instance
+}
+
+// NewRepository makes an empty Repository.
+func NewRepository() *Repository {
+	return &Repository{keys: map[string][]string{}}
+}
+
+// clone makes a deep copy of e, leaving out namespace declarations
+// that the encoder will recreate.
+func clone(e *dom.Element) *dom.Element {
+	res := dom.Elem(e.Name.Local, e.Name.Space)
+	for _, attr := range e.Attributes {
+		if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" {
+			continue
+		}
+		res.Attributes = append(res.Attributes, attr)
+	}
+	res.Content = append([]byte{}, e.Content...)
+	for _, child := range e.Children() {
+		res.AddChild(clone(child))
+	}
+	return res
+}
+
+// property returns the values of the named property of e.
+func property(e *dom.Element, name string) []string {
+	res := []string{}
+	for _, child := range e.Children() {
+		if child.Name.Local == name {
+			res = append(res, strings.TrimSpace(string(child.Content)))
+		}
+	}
+	return res
+}
+
+func className(resource string) string {
+	return resource[strings.LastIndex(resource, "/")+1:]
+}
+
+// SetKeys sets the names of the key properties of resource.  They are
+// used to make the SelectorSets of instances that are loaded from XML
+// or created.  Resources without keys use InstanceID.
+func (r *Repository) SetKeys(resource string, keys ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys[resource] = keys
+}
+
+func (r *Repository) selectorsFor(resource string, e *dom.Element) (map[string]string, error) {
+	keys := r.keys[resource]
+	if len(keys) == 0 {
+		keys = []string{"InstanceID"}
+	}
+	res := map[string]string{}
+	for _, key := range keys {
+		vals := property(e, key)
+		if len(vals) != 1 {
+			return nil, InvalidSelectors(fmt.Sprintf("%s instance has no single value for key %s", className(resource), key))
+		}
+		res[key] = vals[0]
+	}
+	return res, nil
+}
+
+// matches checks that every selector of inst is in selectors.
+// Selectors starting with __, such as __cimnamespace, are ignored.
+func (inst *Instance) matches(selectors map[string]string) bool {
+	for k, v := range inst.Selectors {
+		if selectors[k] != v {
+			return false
+		}
+	}
+	for k := range selectors {
+		if _, ok := inst.Selectors[k]; !ok && !strings.HasPrefix(k, "__") {
+			return false
+		}
+	}
+	return true
+}
+
+// find returns the index of the instance of resource selected by
+// selectors.  A resource with only one instance can be found without
+// selectors.
+func (r *Repository) find(resource string, selectors map[string]string) (int, error) {
+	candidates := []int{}
+	for i, inst := range r.instances {
+		if inst.ResourceURI != resource {
+			continue
+		}
+		if inst.matches(selectors) {
+			return i, nil
+		}
+		candidates = append(candidates, i)
+	}
+	if len(selectors) == 0 && len(candidates) == 1 {
+		return candidates[0], nil
+	}
+	return -1, InvalidSelectors(fmt.Sprintf("No %s instance matches %v", className(resource), selectors))
+}
+
+// Add adds e as an instance of resource.
+func (r *Repository) Add(resource string, e *dom.Element) (*Instance, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.add(resource, e)
+}
+
+func (r *Repository) add(resource string, e *dom.Element) (*Instance, error) {
+	selectors, err := r.selectorsFor(resource, e)
+	if err != nil {
+		return nil, err
+	}
+	return r.insert(resource, selectors, e)
+}
+
+// insert adds e as an instance of resource with selectors, unless
+// there already is one with them.
+func (r *Repository) insert(resource string, selectors map[string]string, e *dom.Element) (*Instance, error) {
+	if _, err := r.find(resource, selectors); err == nil {
+		return nil, AlreadyExists(fmt.Sprintf("%s %v already exists", className(resource), selectors))
+	}
+	inst := &Instance{ResourceURI: resource, Selectors: selectors, Element: clone(e)}
+	r.instances = append(r.instances, inst)
+	return inst, nil
+}
+
+// Instances returns the instances of resource.
+func (r *Repository) Instances(resource string) []*Instance {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := []*Instance{}
+	for _, inst := range r.instances {
+		if inst.ResourceURI == resource {
+			res = append(res, inst)
+		}
+	}
+	return res
+}
+
+// LoadXML loads instances from an XML document.  The document can
+// either have the instances as the children of its root element, or
+// be a saved Get or Enumerate response.  The ResourceURI of each
+// instance is its namespace.
+func (r *Repository) LoadXML(src io.Reader) error {
+	doc, err := dom.Parse(src)
+	if err != nil {
+		return err
+	}
+	root := doc.Root()
+	elems := root.Children()
+	if root.Name.Space == wsman.NS_SOAP && root.Name.Local == "Envelope" {
+		body := search.First(search.Tag("Body", wsman.NS_SOAP), root.Children())
+		if body == nil {
+			return fmt.Errorf("SOAP envelope has no Body")
+		}
+		elems = body.Children()
+		if items := search.First(search.Tag("Items", "*"), elems); items != nil {
+			elems = items.Children()
+		} else if len(elems) == 1 && elems[0].Name.Space == wsman.NS_WSMEN {
+			if items := search.First(search.Tag("Items", "*"), elems[0].Children()); items != nil {
+				elems = items.Children()
+			}
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range elems {
+		if _, err := r.add(e.Name.Space, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type jsonInstance struct {
+	ResourceURI string
+	Selectors   map[string]string
+	Properties  map[string]interface{}
+}
+
+func jsonProperty(resource, name string, val interface{}) []*dom.Element {
+	switch v := val.(type) {
+	case nil:
+		return []*dom.Element{dom.Elem(name, resource).Attr("nil", wsman.NS_XSI, "true")}
+	case []interface{}:
+		res := []*dom.Element{}
+		for _, item := range v {
+			res = append(res, jsonProperty(resource, name, item)...)
+		}
+		return res
+	case map[string]interface{}:
+		e := dom.Elem(name, resource)
+		for _, k := range sortedKeys(v) {
+			e.AddChildren(jsonProperty(resource, k, v[k])...)
+		}
+		return []*dom.Element{e}
+	}
+	return []*dom.Element{dom.ElemC(name, resource, fmt.Sprint(val))}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// LoadJSON loads instances from a JSON array of objects that look
+// like:
+//
+//	{"ResourceURI": "http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_Fan",
+//	 "Selectors": {"DeviceID": "Fan.1"},
+//	 "Properties": {"DeviceID": "Fan.1", "ElementName": "Fan 1", "Speed": null}}
+//
+// Arrays become repeated properties and nulls become xsi:nil
+// properties.  If Selectors is missing it will be made from the keys
+// of the resource.  Otherwise it must have every key set with SetKeys.
+// Either way, loading an instance that is already there fails like
+// Create would.
+func (r *Repository) LoadJSON(src io.Reader) error {
+	instances := []jsonInstance{}
+	if err := json.NewDecoder(src).Decode(&instances); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, ji := range instances {
+		e := dom.Elem(className(ji.ResourceURI), ji.ResourceURI)
+		for _, name := range sortedKeys(ji.Properties) {
+			e.AddChildren(jsonProperty(ji.ResourceURI, name, ji.Properties[name])...)
+		}
+		if ji.Selectors == nil {
+			if _, err := r.add(ji.ResourceURI, e); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(ji.Selectors) == 0 {
+			return InvalidSelectors(fmt.Sprintf("%s instance has empty Selectors", className(ji.ResourceURI)))
+		}
+		for _, key := range r.keys[ji.ResourceURI] {
+			if _, ok := ji.Selectors[key]; !ok {
+				return InvalidSelectors(fmt.Sprintf("%s instance has no selector for key %s", className(ji.ResourceURI), key))
+			}
+		}
+		if _, err := r.insert(ji.ResourceURI, ji.Selectors, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var fragmentName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// fragment returns the names of the properties the FragmentTransfer
+// header of req picks, or nil if it has none.
+func (r *Repository) fragment(req *Request) ([]string, error) {
+	hdr := search.First(search.Tag("FragmentTransfer", wsman.NS_WSMAN), req.AllHeaderElements())
+	if hdr == nil {
+		return nil, nil
+	}
+	if r.NoFragmentTransfer {
+		return nil, UnsupportedFeature("http://schemas.dmtf.org/wbem/wsman/1/wsman/faultDetail/FragmentLevelAccess")
+	}
+	if dialect := attrValue(hdr, "Dialect"); dialect != "" && dialect != wsman.XPATH_DIALECT {
+		return nil, CannotProcessFilter(fmt.Sprintf("Fragment dialect %s is not supported", dialect))
+	}
+	names := []string{}
+	for _, name := range strings.Split(string(hdr.Content), "|") {
+		name = strings.TrimSpace(name)
+		if !fragmentName.MatchString(name) {
+			return nil, CannotProcessFilter(fmt.Sprintf("Fragment %q does not name properties", hdr.Content))
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// xmlFragment makes a wsman:XmlFragment holding copies of the
+// properties of e that are in names.
+func xmlFragment(e *dom.Element, names map[string]bool) *dom.Element {
+	res := dom.Elem("XmlFragment", wsman.NS_WSMAN)
+	for _, child := range e.Children() {
+		if names[child.Name.Local] {
+			res.AddChild(clone(child))
+		}
+	}
+	return res
+}
+
+func nameSet(names []string) map[string]bool {
+	res := map[string]bool{}
+	for _, name := range names {
+		res[name] = true
+	}
+	return res
+}
+
+func (r *Repository) get(req *Request) (*dom.Element, error) {
+	names, err := r.fragment(req)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i, err := r.find(req.ResourceURI, req.Selectors)
+	if err != nil {
+		return nil, err
+	}
+	if names != nil {
+		return xmlFragment(r.instances[i].Element, nameSet(names)), nil
+	}
+	return clone(r.instances[i].Element), nil
+}
+
+// putFragment replaces the properties of instance i that are in names
+// with the ones in frag.  The caller must hold r.mu.
+func (r *Repository) putFragment(i int, names []string, frag *dom.Element) (*dom.Element, error) {
+	if frag.Name.Local != "XmlFragment" {
+		return nil, InvalidSelectors("Fragment Put needs an XmlFragment in the body")
+	}
+	picked := nameSet(names)
+	for _, prop := range frag.Children() {
+		if !picked[prop.Name.Local] {
+			return nil, CannotProcessFilter(fmt.Sprintf("%s is not in the fragment", prop.Name.Local))
+		}
+	}
+	// Replace the properties where they were, and add the ones
+	// the instance did not have at the end.
+	old := r.instances[i].Element
+	inst := dom.Elem(old.Name.Local, old.Name.Space)
+	inst.Attributes = clone(old).Attributes
+	done := map[string]bool{}
+	add := func(name string) {
+		for _, prop := range frag.Children() {
+			if prop.Name.Local == name {
+				inst.AddChild(clone(prop))
+			}
+		}
+		done[name] = true
+	}
+	for _, child := range old.Children() {
+		switch {
+		case !picked[child.Name.Local]:
+			inst.AddChild(clone(child))
+		case !done[child.Name.Local]:
+			add(child.Name.Local)
+		}
+	}
+	for _, name := range names {
+		if !done[name] {
+			add(name)
+		}
+	}
+	r.instances[i].Element = inst
+	return xmlFragment(inst, picked), nil
+}
+
+func requestInstance(req *Request) (*dom.Element, error) {
+	body := req.Body()
+	if len(body) != 1 {
+		return nil, InvalidSelectors(fmt.Sprintf("%s needs exactly one instance in the body", req.Action))
+	}
+	return body[0], nil
+}
+
+func (r *Repository) put(req *Request) (*dom.Element, error) {
+	names, err := r.fragment(req)
+	if err != nil {
+		return nil, err
+	}
+	e, err := requestInstance(req)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i, err := r.find(req.ResourceURI, req.Selectors)
+	if err != nil {
+		return nil, err
+	}
+	if names != nil {
+		return r.putFragment(i, names, e)
+	}
+	r.instances[i].Element = clone(e)
+	return clone(e), nil
+}
+
+func (r *Repository) delete(req *Request) (*dom.Element, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i, err := r.find(req.ResourceURI, req.Selectors)
+	if err != nil {
+		return nil, err
+	}
+	r.instances = append(r.instances[:i], r.instances[i+1:]...)
+	return nil, nil
+}
+
+// epr makes the children of an EndpointReference to inst.
+func epr(address string, inst *Instance) []*dom.Element {
+	selset := dom.Elem("SelectorSet", wsman.NS_WSMAN)
+	names := make([]string, 0, len(inst.Selectors))
+	for k := range inst.Selectors {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		selset.AddChild(dom.ElemC("Selector", wsman.NS_WSMAN, inst.Selectors[k]).Attr("Name", "", k))
+	}
+	params := dom.Elem("ReferenceParameters", wsman.NS_WSA)
+	params.AddChild(dom.ElemC("ResourceURI", wsman.NS_WSMAN, inst.ResourceURI))
+	params.AddChild(selset)
+	return []*dom.Element{dom.ElemC("Address", wsman.NS_WSA, address), params}
+}
+
+func (r *Repository) create(s *Server) Handler {
+	return func(req *Request) (*dom.Element, error) {
+		e, err := requestInstance(req)
+		if err != nil {
+			return nil, err
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		inst, err := r.add(req.ResourceURI, e)
+		if err != nil {
+			return nil, err
+		}
+		res := dom.Elem("ResourceCreated", wsman.NS_WSMT)
+		res.AddChildren(epr(s.Endpoint(), inst)...)
+		return res, nil
+	}
+}
+
+var (
+	whereClause = regexp.MustCompile(`^\s*(\w+)\s*(=|!=|<>)\s*(?:'([^']*)'|"([^"]*)"|(\S+))\s*$`)
+	andClause   = regexp.MustCompile(`(?i)\s+AND\s+`)
+)
+
+type condition struct {
+	name, value string
+	negate      bool
+}
+
+// parseQuery handles the tiny subset of WQL and CQL that is
+// SELECT * FROM class [WHERE prop = 'value' [AND ...]]
+func parseQuery(query string) ([]condition, error) {
+	res := []condition{}
+	idx := strings.Index(strings.ToUpper(query), " WHERE ")
+	if idx == -1 {
+		return res, nil
+	}
+	for _, clause := range andClause.Split(query[idx+7:], -1) {
+		m := whereClause.FindStringSubmatch(clause)
+		if m == nil {
+			return nil, CannotProcessFilter(fmt.Sprintf("Cannot parse %q", clause))
+		}
+		res = append(res, condition{name: m[1], value: m[3] + m[4] + m[5], negate: m[2] != "="})
+	}
+	return res, nil
+}
+
+// filter returns the conditions the Enumerate request filters on.
+func filter(req *Request) ([]condition, error) {
+	f := search.First(search.Tag("Filter", "*"), req.AllBodyElements())
+	if f == nil {
+		return nil, nil
+	}
+	switch attrValue(f, "Dialect") {
+	case SELECTOR_FILTER:
+		res := []condition{}
+		if selset := search.First(search.Tag("SelectorSet", wsman.NS_WSMAN), f.Children()); selset != nil {
+			for _, sel := range selset.Children() {
+				res = append(res, condition{name: attrValue(sel, "Name"), value: strings.TrimSpace(string(sel.Content))})
+			}
+		}
+		return res, nil
+	case WQL_FILTER, CQL_FILTER:
+		return parseQuery(strings.TrimSpace(string(f.Content)))
+	}
+	return nil, CannotProcessFilter(fmt.Sprintf("Filter dialect %q is not supported", attrValue(f, "Dialect")))
+}
+
+func (inst *Instance) satisfies(conds []condition) bool {
+	for _, cond := range conds {
+		found := false
+		for _, val := range property(inst.Element, cond.name) {
+			if val == cond.value {
+				found = true
+				break
+			}
+		}
+		if found == cond.negate {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Repository) enumerate(s *Server) ItemsHandler {
+	return func(req *Request) ([]*dom.Element, error) {
+		conds, err := filter(req)
+		if err != nil {
+			return nil, err
+		}
+		items := []*dom.Element{}
+		for _, inst := range r.Instances(req.ResourceURI) {
+			if !inst.satisfies(conds) {
+				continue
+			}
+			ref := dom.Elem("EndpointReference", wsman.NS_WSA)
+			ref.AddChildren(epr(s.Endpoint(), inst)...)
+			switch req.EnumerationMode {
+			case "EnumerateEPR":
+				items = append(items, ref)
+			case "EnumerateObjectAndEPR":
+				item := dom.Elem("Item", wsman.NS_WSMAN).AddChild(clone(inst.Element))
+				items = append(items, item.AddChild(ref))
+			default:
+				items = append(items, clone(inst.Element))
+			}
+		}
+		return items, nil
+	}
+}
+
+// Serve registers handlers on s that serve Get, Put, Create, Delete,
+// and Enumerate for the passed resources and every resource with
+// instances in the repository.
+func (r *Repository) Serve(s *Server, resources ...string) {
+	r.mu.Lock()
+	for _, inst := range r.instances {
+		resources = append(resources, inst.ResourceURI)
+	}
+	r.mu.Unlock()
+	seen := map[string]bool{}
+	for _, resource := range resources {
+		if seen[resource] {
+			continue
+		}
+		seen[resource] = true
+		s.HandleGet(resource, r.get)
+		s.HandlePut(resource, r.put)
+		s.HandleCreate(resource, r.create(s))
+		s.HandleDelete(resource, r.delete)
+		s.HandleEnumerate(resource, r.enumerate(s))
+	}
+}
@@ -0,0 +1,227 @@
+package wsmantest
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/wsman"
+)
+
+const fanXML = `<Fans xmlns:n="` + fanURI + `">
+  <n:CIM_Fan><n:DeviceID>Fan.1</n:DeviceID><n:ElementName>Fan 1</n:ElementName></n:CIM_Fan>
+  <n:CIM_Fan><n:DeviceID>Fan.2</n:DeviceID><n:ElementName>Fan 2</n:ElementName></n:CIM_Fan>
+</Fans>`
+
+func newFan(id, name string) *dom.Element {
+	return dom.Elem("CIM_Fan", fanURI).AddChild(
+		dom.ElemC("DeviceID", fanURI, id)).AddChild(
+		dom.ElemC("ElementName", fanURI, name))
+}
+
+func elementName(t *testing.T, client *wsman.Client, id string) string {
+	reply, err := client.Get(fanURI).Selectors("DeviceID", id).Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, err := reply.GetItem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(property(item, "ElementName"), ",")
+}
+
+func fanRepository(t *testing.T) (*Server, *wsman.Client) {
+	repo := NewRepository()
+	repo.SetKeys(fanURI, "DeviceID")
+	if err := repo.LoadXML(strings.NewReader(fanXML)); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer()
+	repo.Serve(s)
+	return s, s.NewClient()
+}
+
+func TestRepositoryCRUD(t *testing.T) {
+	s, client := fanRepository(t)
+	defer s.Close()
+	if got := elementName(t, client, "Fan.2"); got != "Fan 2" {
+		t.Errorf("Got ElementName %q, wanted Fan 2", got)
+	}
+
+	put := client.Put(fanURI).Selectors("DeviceID", "Fan.1")
+	put.SetBody(newFan("Fan.1", "Front fan"))
+	if _, err := put.Send(); err != nil {
+		t.Fatal(err)
+	}
+	if got := elementName(t, client, "Fan.1"); got != "Front fan" {
+		t.Errorf("Put did not stick, ElementName is %q", got)
+	}
+
+	create := client.Create(fanURI)
+	create.SetBody(newFan("Fan.3", "Fan 3"))
+	reply, err := create.Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	epr, err := reply.ResourceCreated()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := epr.Selector("DeviceID"); id != "Fan.3" {
+		t.Errorf("Created EPR has DeviceID %q", id)
+	}
+	if got := elementName(t, client, "Fan.3"); got != "Fan 3" {
+		t.Errorf("Created instance has ElementName %q", got)
+	}
+	create = client.Create(fanURI)
+	create.SetBody(newFan("Fan.3", "Again"))
+	if reply, err := create.Send(); err == nil || reply.FaultSubcode() != "AlreadyExists" {
+		t.Errorf("Second Create got %v, wanted AlreadyExists", err)
+	}
+	create = client.Create(fanURI)
+	create.SetBody(dom.Elem("CIM_Fan", fanURI).AddChild(dom.ElemC("ElementName", fanURI, "No key")))
+	if reply, err := create.Send(); err == nil || reply.FaultSubcode() != "InvalidSelectors" {
+		t.Errorf("Create without a key got %v, wanted InvalidSelectors", err)
+	}
+
+	reply, err = client.Enumerate(fanURI).Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items, _ := reply.EnumItems(); len(items) != 3 {
+		t.Errorf("Enumerated %d fans, wanted 3", len(items))
+	}
+
+	if _, err := client.Delete(fanURI).Selectors("DeviceID", "Fan.1").Send(); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := client.Get(fanURI).Selectors("DeviceID", "Fan.1").Send(); err == nil || reply.FaultSubcode() != "InvalidSelectors" {
+		t.Errorf("Get after Delete got %v, wanted InvalidSelectors", err)
+	}
+	if reply, err := client.Delete(fanURI).Selectors("DeviceID", "Fan.1").Send(); err == nil || reply.FaultSubcode() != "InvalidSelectors" {
+		t.Errorf("Second Delete got %v, wanted InvalidSelectors", err)
+	}
+}
+
+func TestLoadXMLDuplicate(t *testing.T) {
+	repo := NewRepository()
+	repo.SetKeys(fanURI, "DeviceID")
+	if err := repo.LoadXML(strings.NewReader(fanXML)); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.LoadXML(strings.NewReader(fanXML)); err == nil {
+		t.Error("Loading the same instances twice should fail")
+	}
+}
+
+func TestLoadJSON(t *testing.T) {
+	for _, test := range []struct {
+		name, json string
+		ok         bool
+	}{
+		{"keys", `[{"ResourceURI": "` + fanURI + `", "Properties": {"DeviceID": "Fan.1"}}]`, true},
+		{"selectors", `[{"ResourceURI": "` + fanURI + `", "Selectors": {"DeviceID": "Fan.1"}, "Properties": {"DeviceID": "Fan.1"}}]`, true},
+		{"duplicate keys", `[{"ResourceURI": "` + fanURI + `", "Properties": {"DeviceID": "Fan.1"}},
+			{"ResourceURI": "` + fanURI + `", "Properties": {"DeviceID": "Fan.1"}}]`, false},
+		{"duplicate selectors", `[{"ResourceURI": "` + fanURI + `", "Selectors": {"DeviceID": "Fan.1"}},
+			{"ResourceURI": "` + fanURI + `", "Selectors": {"DeviceID": "Fan.1"}}]`, false},
+		{"missing key", `[{"ResourceURI": "` + fanURI + `", "Selectors": {"Name": "Fan.1"}}]`, false},
+		{"empty selectors", `[{"ResourceURI": "` + fanURI + `", "Selectors": {}}]`, false},
+	} {
+		repo := NewRepository()
+		repo.SetKeys(fanURI, "DeviceID")
+		err := repo.LoadJSON(strings.NewReader(test.json))
+		if test.ok && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("%s: should have failed", test.name)
+		}
+	}
+
+	repo := NewRepository()
+	src := `[{"ResourceURI": "` + fanURI + `", "Selectors": {"DeviceID": "Fan.1"},
+		"Properties": {"DeviceID": "Fan.1", "ElementName": ["a", "b"], "Speed": null}}]`
+	if err := repo.LoadJSON(strings.NewReader(src)); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer()
+	defer s.Close()
+	repo.Serve(s)
+	if got := elementName(t, s.NewClient(), "Fan.1"); got != "a,b" {
+		t.Errorf("Array property came back as %q", got)
+	}
+}
+
+func TestRepositoryFragments(t *testing.T) {
+	s, client := fanRepository(t)
+	defer s.Close()
+	reply, err := client.Get(fanURI).Selectors("DeviceID", "Fan.2").Fragment("ElementName").Send()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := reply.Body()
+	if len(body) != 1 || body[0].Name.Local != "XmlFragment" || len(body[0].Children()) != 1 ||
+		string(body[0].Children()[0].Content) != "Fan 2" {
+		t.Errorf("Fragment Get replied %v", body)
+	}
+
+	put := client.Put(fanURI).Selectors("DeviceID", "Fan.2").Fragment("ElementName | Caption")
+	put.SetBody(dom.Elem("XmlFragment", wsman.NS_WSMAN).AddChild(
+		dom.ElemC("Caption", fanURI, "Rear")).AddChild(
+		dom.ElemC("ElementName", fanURI, "Rear fan")))
+	if _, err := put.Send(); err != nil {
+		t.Fatal(err)
+	}
+	if got := elementName(t, client, "Fan.2"); got != "Rear fan" {
+		t.Errorf("Fragment Put did not stick, ElementName is %q", got)
+	}
+
+	for _, test := range []struct {
+		path, prop, subcode string
+	}{
+		{"ElementName", "Caption", "CannotProcessFilter"},
+		{"/CIM_Fan/ElementName", "ElementName", "CannotProcessFilter"},
+	} {
+		put := client.Put(fanURI).Selectors("DeviceID", "Fan.2").Fragment(test.path)
+		put.SetBody(dom.Elem("XmlFragment", wsman.NS_WSMAN).AddChild(dom.ElemC(test.prop, fanURI, "x")))
+		if reply, err := put.Send(); err == nil || reply.FaultSubcode() != test.subcode {
+			t.Errorf("Put of %s in fragment %s: got %v, wanted %s", test.prop, test.path, err, test.subcode)
+		}
+	}
+	if got := elementName(t, client, "Fan.2"); got != "Rear fan" {
+		t.Errorf("Bad fragment Puts changed ElementName to %q", got)
+	}
+}
+
+func TestRepositoryNoFragments(t *testing.T) {
+	repo := NewRepository()
+	repo.NoFragmentTransfer = true
+	repo.SetKeys(fanURI, "DeviceID")
+	if err := repo.LoadXML(strings.NewReader(fanXML)); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer()
+	defer s.Close()
+	repo.Serve(s)
+	reply, err := s.NewClient().Get(fanURI).Selectors("DeviceID", "Fan.1").Fragment("ElementName").Send()
+	if err == nil || reply.FaultSubcode() != "UnsupportedFeature" || reply.FaultDetail() != "FragmentLevelAccess" {
+		t.Errorf("Got %v, wanted an UnsupportedFeature fault", err)
+	}
+}
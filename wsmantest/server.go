@@ -0,0 +1,527 @@
+// Package wsmantest provides an in-process WSMAN endpoint for testing
+// code built on wsman.Client.
+//
+// A Server dispatches requests on their Action and ResourceURI headers
+// to Handlers registered for them, and takes care of the SOAP and
+// WS-Addressing plumbing, enumeration contexts, faults, and Basic or
+// Digest authentication.  HandleShell serves Windows Remote Shells.
+package wsmantest
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/search"
+	"github.com/VictorLowther/soap"
+	"github.com/VictorLowther/wsman"
+	uuid "github.com/satori/go.uuid"
+)
+
+const anonymous = "http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous"
+
+// Request is a parsed WSMAN request.
+type Request struct {
+	*soap.Message
+	HTTP                           *http.Request
+	Action, ResourceURI, MessageID string
+	Selectors, Options             map[string]string
+	// MaxElements, Optimize, EnumerationMode, and Context are
+	// filled in from the body of Enumerate, Pull, and Release
+	// requests.
+	MaxElements     int
+	Optimize        bool
+	EnumerationMode string
+	Context         string
+}
+
+// Handler handles a single WSMAN request, and returns the element to
+// send back as the body of the response.  A nil element makes an
+// empty body.  If the returned error is a *Fault it will be sent as
+// is, otherwise it will be sent as an InternalError fault.
+type Handler func(req *Request) (*dom.Element, error)
+
+// ItemsHandler returns the items an Enumerate request should
+// enumerate.  The Server takes care of handing them out in Pulls.
+type ItemsHandler func(req *Request) ([]*dom.Element, error)
+
+type enumeration struct {
+	items   []*dom.Element
+	expires time.Time
+}
+
+// Server is an in-process WSMAN endpoint.
+type Server struct {
+	*httptest.Server
+	// Username and Password, if set, must be used to authenticate to
+	// the server.
+	Username, Password string
+	// Digest makes the server use digest auth instead of basic auth.
+	Digest bool
+	Realm  string
+	// ContextExpiry is how long an enumeration context may sit
+	// unused before it expires.  Zero means contexts never expire.
+	ContextExpiry time.Duration
+	mu            sync.Mutex
+	nonce         string
+	// handlers is keyed by ResourceURI, then by Action.
+	handlers   map[string]map[string]Handler
+	enums      map[string]*enumeration
+	requests   []*Request
+	injections []*injection
+	// shells and commands are the state of the shells served by
+	// HandleShell.
+	shells   map[string]bool
+	commands []*ShellCommand
+}
+
+func newServer() *Server {
+	return &Server{
+		Realm:    "wsmantest",
+		nonce:    newNonce(),
+		handlers: map[string]map[string]Handler{},
+		enums:    map[string]*enumeration{},
+		shells:   map[string]bool{},
+	}
+}
+
+// NewServer starts a new Server listening on HTTP.
+func NewServer() *Server {
+	s := newServer()
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// NewTLSServer starts a new Server listening on HTTPS.
+func NewTLSServer() *Server {
+	s := newServer()
+	s.Server = httptest.NewTLSServer(s)
+	return s
+}
+
+// Endpoint is the URL wsman.Clients should talk to.
+func (s *Server) Endpoint() string {
+	return s.URL + "/wsman"
+}
+
+// NewClient makes a wsman.Client that will talk to the server with
+// its credentials.
+func (s *Server) NewClient() *wsman.Client {
+	return wsman.NewClient(s.Endpoint(), s.Username, s.Password, s.Digest)
+}
+
+// Requests returns every request the server has handled, in order.
+func (s *Server) Requests() []*Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Request{}, s.requests...)
+}
+
+// Handle registers h to handle action on resource.
+func (s *Server) Handle(resource, action string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handlers[resource] == nil {
+		s.handlers[resource] = map[string]Handler{}
+	}
+	s.handlers[resource][action] = h
+}
+
+// HandleGet registers h to handle Get on resource.
+func (s *Server) HandleGet(resource string, h Handler) {
+	s.Handle(resource, wsman.GET, h)
+}
+
+// HandlePut registers h to handle Put on resource.
+func (s *Server) HandlePut(resource string, h Handler) {
+	s.Handle(resource, wsman.PUT, h)
+}
+
+// HandleCreate registers h to handle Create on resource.
+func (s *Server) HandleCreate(resource string, h Handler) {
+	s.Handle(resource, wsman.CREATE, h)
+}
+
+// HandleDelete registers h to handle Delete on resource.
+func (s *Server) HandleDelete(resource string, h Handler) {
+	s.Handle(resource, wsman.DELETE, h)
+}
+
+// HandleInvoke registers h to handle invoking method on resource.
+// h should return the method_OUTPUT element.
+func (s *Server) HandleInvoke(resource, method string, h Handler) {
+	s.Handle(resource, resource+"/"+method, h)
+}
+
+// HandleEnumerate registers items to provide the items for
+// Enumerate on resource.  Pull and Release for the enumeration
+// contexts it creates are handled by the server, unless handlers for
+// them are also registered for resource.
+func (s *Server) HandleEnumerate(resource string, items ItemsHandler) {
+	s.Handle(resource, wsman.ENUMERATE, func(req *Request) (*dom.Element, error) {
+		found, err := items(req)
+		if err != nil {
+			return nil, err
+		}
+		return s.startEnumeration(req, found), nil
+	})
+}
+
+func newNonce() string {
+	b := make([]byte, 16)
+	io.ReadFull(rand.Reader, b)
+	return fmt.Sprintf("%x", b)
+}
+
+func md5hex(parts ...string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(strings.Join(parts, ":"))))
+}
+
+// digestParams splits the parameters of a Digest Authorization header.
+func digestParams(header string) map[string]string {
+	res := map[string]string{}
+	s := strings.TrimSpace(strings.TrimPrefix(header, "Digest "))
+	for len(s) > 0 {
+		eq := strings.Index(s, "=")
+		if eq == -1 {
+			break
+		}
+		key := strings.TrimSpace(s[:eq])
+		s = strings.TrimSpace(s[eq+1:])
+		var val string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.Index(s[1:], `"`)
+			if end == -1 {
+				break
+			}
+			val, s = s[1:end+1], s[end+2:]
+		} else if comma := strings.Index(s, ","); comma != -1 {
+			val, s = s[:comma], s[comma:]
+		} else {
+			val, s = s, ""
+		}
+		res[key] = strings.TrimSpace(val)
+		s = strings.TrimPrefix(strings.TrimSpace(s), ",")
+	}
+	return res
+}
+
+func (s *Server) challenge(w http.ResponseWriter, stale bool) {
+	if s.Digest {
+		s.mu.Lock()
+		hdr := fmt.Sprintf(`Digest realm="%s", nonce="%s", qop="auth", algorithm="MD5"`, s.Realm, s.nonce)
+		s.mu.Unlock()
+		if stale {
+			hdr += `, stale="true"`
+		}
+		w.Header().Set("WWW-Authenticate", hdr)
+	} else {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s"`, s.Realm))
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+// authorized checks the credentials on r, and sends a challenge if
+// they are missing or wrong.
+func (s *Server) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if s.Username == "" && s.Password == "" {
+		return true
+	}
+	if !s.Digest {
+		user, pass, ok := r.BasicAuth()
+		if ok && user == s.Username && pass == s.Password {
+			return true
+		}
+		s.challenge(w, false)
+		return false
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Digest ") {
+		s.challenge(w, false)
+		return false
+	}
+	p := digestParams(auth)
+	s.mu.Lock()
+	nonce := s.nonce
+	s.mu.Unlock()
+	if p["nonce"] != nonce {
+		s.challenge(w, true)
+		return false
+	}
+	ha1 := md5hex(s.Username, s.Realm, s.Password)
+	ha2 := md5hex(r.Method, p["uri"])
+	want := md5hex(ha1, nonce, ha2)
+	if p["qop"] != "" {
+		want = md5hex(ha1, nonce, p["nc"], p["cnonce"], p["qop"], ha2)
+	}
+	if p["username"] != s.Username || p["response"] != want {
+		s.challenge(w, false)
+		return false
+	}
+	return true
+}
+
+func headerContent(msg *soap.Message, name, space string) string {
+	if hdr := search.First(search.Tag(name, space), msg.AllHeaderElements()); hdr != nil {
+		return strings.TrimSpace(string(hdr.Content))
+	}
+	return ""
+}
+
+func attrValue(e *dom.Element, name string) string {
+	for _, attr := range e.Attributes {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func namedSet(msg *soap.Message, set string) map[string]string {
+	res := map[string]string{}
+	if found := search.First(search.Tag(set, wsman.NS_WSMAN), msg.AllHeaderElements()); found != nil {
+		for _, child := range found.Children() {
+			res[attrValue(child, "Name")] = strings.TrimSpace(string(child.Content))
+		}
+	}
+	return res
+}
+
+func parseRequest(r *http.Request) (*Request, error) {
+	msg, err := soap.Parse(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	req := &Request{
+		Message:     msg,
+		HTTP:        r,
+		Action:      headerContent(msg, "Action", wsman.NS_WSA),
+		ResourceURI: headerContent(msg, "ResourceURI", wsman.NS_WSMAN),
+		MessageID:   headerContent(msg, "MessageID", wsman.NS_WSA),
+		Selectors:   namedSet(msg, "SelectorSet"),
+		Options:     namedSet(msg, "OptionSet"),
+		MaxElements: 1,
+	}
+	body := msg.AllBodyElements()
+	if max := search.First(search.Tag("MaxElements", "*"), body); max != nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(string(max.Content))); err == nil && n > 0 {
+			req.MaxElements = n
+		}
+	}
+	req.Optimize = search.First(search.Tag("OptimizeEnumeration", wsman.NS_WSMAN), body) != nil
+	if mode := search.First(search.Tag("EnumerationMode", wsman.NS_WSMAN), body); mode != nil {
+		req.EnumerationMode = strings.TrimSpace(string(mode.Content))
+	}
+	if ctx := search.First(search.Tag("EnumerationContext", wsman.NS_WSMEN), body); ctx != nil {
+		req.Context = strings.TrimSpace(string(ctx.Content))
+	}
+	return req, nil
+}
+
+func (s *Server) send(w http.ResponseWriter, req *Request, action string, body *dom.Element, status int) {
+	msg := soap.NewMessage()
+	if action != "" {
+		msg.SetHeader(
+			soap.MuElemC("Action", wsman.NS_WSA, action),
+			soap.MuElemC("To", wsman.NS_WSA, anonymous),
+			soap.MuElemC("MessageID", wsman.NS_WSA, fmt.Sprintf("uuid:%s", uuid.NewV4())),
+			dom.ElemC("RelatesTo", wsman.NS_WSA, req.MessageID))
+	}
+	if body != nil {
+		msg.SetBody(body)
+	}
+	w.Header().Set("Content-Type", soap.ContentType)
+	w.WriteHeader(status)
+	io.Copy(w, msg.Reader())
+}
+
+func (s *Server) sendFault(w http.ResponseWriter, req *Request, err error) {
+	fault, ok := err.(*Fault)
+	if !ok {
+		fault = InternalError(err.Error())
+	}
+	s.send(w, req, fault.Action, fault.Element(), fault.status())
+}
+
+func identify() *dom.Element {
+	return dom.Elem("IdentifyResponse", wsman.NS_WSMID).AddChild(
+		dom.ElemC("ProtocolVersion", wsman.NS_WSMID, wsman.NS_WSMAN)).AddChild(
+		dom.ElemC("ProductVendor", wsman.NS_WSMID, "wsmantest")).AddChild(
+		dom.ElemC("ProductVersion", wsman.NS_WSMID, "1.0"))
+}
+
+// handler finds the handler for req.
+func (s *Server) handler(req *Request) (Handler, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h, ok := s.handlers[req.ResourceURI][req.Action]; ok {
+		return h, nil
+	}
+	switch req.Action {
+	case wsman.PULL:
+		return s.pull, nil
+	case wsman.RELEASE:
+		return s.release, nil
+	}
+	if _, ok := s.handlers[req.ResourceURI]; ok {
+		return nil, ActionNotSupported(req.Action)
+	}
+	return nil, DestinationUnreachable(req.ResourceURI)
+}
+
+// ServeHTTP makes Server an http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorized(w, r) {
+		return
+	}
+	req, err := parseRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.mu.Unlock()
+	if failure := s.injected(req); failure != nil && failure(s, w, req) {
+		return
+	}
+	s.dispatch(w, req)
+}
+
+func (s *Server) dispatch(w http.ResponseWriter, req *Request) {
+	if req.Action == "" {
+		if search.First(search.Tag("Identify", wsman.NS_WSMID), req.Body()) != nil {
+			s.send(w, req, "", identify(), http.StatusOK)
+			return
+		}
+		s.sendFault(w, req, ActionNotSupported(""))
+		return
+	}
+	h, err := s.handler(req)
+	if err == nil {
+		var body *dom.Element
+		if body, err = h(req); err == nil {
+			s.send(w, req, req.Action+"Response", body, http.StatusOK)
+			return
+		}
+	}
+	s.sendFault(w, req, err)
+}
+
+func (s *Server) expiry() time.Time {
+	if s.ContextExpiry == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(s.ContextExpiry)
+}
+
+// page hands out up to max items from the enumeration, and returns
+// the context to continue with, or an empty string if the
+// enumeration is finished.
+func (s *Server) page(context string, max int) ([]*dom.Element, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	enum := s.enums[context]
+	enum.expires = s.expiry()
+	if max > len(enum.items) {
+		max = len(enum.items)
+	}
+	items := enum.items[:max]
+	enum.items = enum.items[max:]
+	if len(enum.items) == 0 {
+		delete(s.enums, context)
+		return items, ""
+	}
+	return items, context
+}
+
+func (s *Server) startEnumeration(req *Request, items []*dom.Element) *dom.Element {
+	context := fmt.Sprintf("uuid:%s", uuid.NewV4())
+	s.mu.Lock()
+	s.enums[context] = &enumeration{items: items, expires: s.expiry()}
+	s.mu.Unlock()
+	resp := dom.Elem("EnumerateResponse", wsman.NS_WSMEN)
+	if !req.Optimize {
+		return resp.AddChild(dom.ElemC("EnumerationContext", wsman.NS_WSMEN, context))
+	}
+	page, next := s.page(context, req.MaxElements)
+	if next != "" {
+		resp.AddChild(dom.ElemC("EnumerationContext", wsman.NS_WSMEN, next))
+	}
+	found := dom.Elem("Items", wsman.NS_WSMAN)
+	found.AddChildren(page...)
+	resp.AddChild(found)
+	if next == "" {
+		resp.AddChild(dom.Elem("EndOfSequence", wsman.NS_WSMAN))
+	}
+	return resp
+}
+
+// validContext checks that the enumeration context exists and has
+// not expired.  Expired contexts are removed.
+func (s *Server) validContext(context string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	enum, ok := s.enums[context]
+	if ok && !enum.expires.IsZero() && time.Now().After(enum.expires) {
+		delete(s.enums, context)
+		return false
+	}
+	return ok
+}
+
+func (s *Server) pull(req *Request) (*dom.Element, error) {
+	if !s.validContext(req.Context) {
+		return nil, InvalidEnumerationContext()
+	}
+	page, next := s.page(req.Context, req.MaxElements)
+	resp := dom.Elem("PullResponse", wsman.NS_WSMEN)
+	if next != "" {
+		resp.AddChild(dom.ElemC("EnumerationContext", wsman.NS_WSMEN, next))
+	}
+	found := dom.Elem("Items", wsman.NS_WSMEN)
+	found.AddChildren(page...)
+	resp.AddChild(found)
+	if next == "" {
+		resp.AddChild(dom.Elem("EndOfSequence", wsman.NS_WSMEN))
+	}
+	return resp, nil
+}
+
+func (s *Server) release(req *Request) (*dom.Element, error) {
+	if !s.validContext(req.Context) {
+		return nil, InvalidEnumerationContext()
+	}
+	s.mu.Lock()
+	delete(s.enums, req.Context)
+	s.mu.Unlock()
+	return nil, nil
+}
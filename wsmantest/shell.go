@@ -0,0 +1,342 @@
+package wsmantest
+
+/*
+Copyright 2015 Victor Lowther <victor.lowther@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/VictorLowther/simplexml/dom"
+	"github.com/VictorLowther/simplexml/search"
+	"github.com/VictorLowther/wsman"
+	uuid "github.com/satori/go.uuid"
+)
+
+// COMMAND_DONE and COMMAND_RUNNING are the CommandStates a shell
+// reports in Receive responses.
+const (
+	COMMAND_DONE    = wsman.NS_SHELL + "/CommandState/Done"
+	COMMAND_RUNNING = wsman.NS_SHELL + "/CommandState/Running"
+)
+
+// ShellCommand is a command started in a shell served by HandleShell.
+type ShellCommand struct {
+	ShellID, ID string
+	// Command and Arguments are the CommandLine the command was
+	// started with.
+	Command   string
+	Arguments []string
+	s         *Server
+	stdin     []byte
+	closed    chan struct{}
+	signals   []string
+	// ran is set when the handler is called, exited when it
+	// returns or the command is terminated, and delivered when
+	// its output has been received.
+	ran, exited, delivered bool
+	finished, killed       chan struct{}
+	// stdout, stderr, and exitCode are what the CommandHandler
+	// returned.
+	stdout, stderr []byte
+	exitCode       int
+}
+
+// CommandHandler runs cmd, and returns what it wrote to stdout and
+// stderr and its exit code.  It is called when the client first asks
+// the command for output.  The first Receive after it returns gets
+// the output, and the next one says the command is done.  Receives
+// that run out of time waiting for it get a TimedOut fault.  Input sent after the handler returns
+// is a fault, the same as for commands that have exited on a real
+// endpoint.
+type CommandHandler func(cmd *ShellCommand) (stdout, stderr []byte, exitCode int)
+
+// Input waits until the client says there is no more input for the
+// command or terminates it, and returns all the input the command
+// got.
+func (c *ShellCommand) Input() []byte {
+	select {
+	case <-c.closed:
+	case <-c.killed:
+	}
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	return append([]byte{}, c.stdin...)
+}
+
+// Signals returns the codes of the signals sent to the command.
+func (c *ShellCommand) Signals() []string {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	return append([]string{}, c.signals...)
+}
+
+// Script decodes the script of a powershell -EncodedCommand command,
+// or returns "" for other commands.
+func (c *ShellCommand) Script() string {
+	for i, arg := range c.Arguments {
+		if !strings.EqualFold(arg, "-EncodedCommand") || i+1 == len(c.Arguments) {
+			continue
+		}
+		buf, err := base64.StdEncoding.DecodeString(c.Arguments[i+1])
+		if err != nil || len(buf)%2 != 0 {
+			return ""
+		}
+		units := make([]uint16, len(buf)/2)
+		binary.Read(strings.NewReader(string(buf)), binary.LittleEndian, units)
+		return string(utf16.Decode(units))
+	}
+	return ""
+}
+
+// Commands returns every command started in shells the server
+// handles, in the order they were started.
+func (s *Server) Commands() []*ShellCommand {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*ShellCommand{}, s.commands...)
+}
+
+// command finds the command req is for.  The caller must hold s.mu.
+func (s *Server) command(req *Request, id string) (*ShellCommand, error) {
+	shell := req.Selectors["ShellId"]
+	if !s.shells[shell] {
+		return nil, InvalidSelectors(fmt.Sprintf("No shell %s", shell))
+	}
+	for _, cmd := range s.commands {
+		if cmd.ShellID == shell && cmd.ID == id {
+			return cmd, nil
+		}
+	}
+	return nil, InvalidSelectors(fmt.Sprintf("No command %s in shell %s", id, shell))
+}
+
+func shellBody(req *Request, name string) (*dom.Element, error) {
+	body := search.First(search.Tag(name, wsman.NS_SHELL), req.Body())
+	if body == nil {
+		return nil, InternalError(fmt.Sprintf("No %s in request", name))
+	}
+	return body, nil
+}
+
+func (s *Server) createShell(req *Request) (*dom.Element, error) {
+	body, err := shellBody(req, "Shell")
+	if err != nil {
+		return nil, err
+	}
+	id := attrValue(body, "ShellId")
+	if id == "" {
+		id = strings.ToUpper(uuid.NewV4().String())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shells[id] {
+		return nil, AlreadyExists(fmt.Sprintf("Shell %s already exists", id))
+	}
+	s.shells[id] = true
+	return dom.Elem("Shell", wsman.NS_SHELL).AddChild(dom.ElemC("ShellId", wsman.NS_SHELL, id)), nil
+}
+
+func (s *Server) deleteShell(req *Request) (*dom.Element, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := req.Selectors["ShellId"]
+	if !s.shells[id] {
+		return nil, InvalidSelectors(fmt.Sprintf("No shell %s", id))
+	}
+	delete(s.shells, id)
+	return nil, nil
+}
+
+func (s *Server) startCommand(req *Request) (*dom.Element, error) {
+	line, err := shellBody(req, "CommandLine")
+	if err != nil {
+		return nil, err
+	}
+	cmd := &ShellCommand{
+		ShellID:  req.Selectors["ShellId"],
+		ID:       attrValue(line, "CommandId"),
+		s:        s,
+		closed:   make(chan struct{}),
+		finished: make(chan struct{}),
+		killed:   make(chan struct{}),
+	}
+	if cmd.ID == "" {
+		cmd.ID = strings.ToUpper(uuid.NewV4().String())
+	}
+	for _, e := range line.Children() {
+		switch e.Name.Local {
+		case "Command":
+			cmd.Command = strings.TrimSpace(string(e.Content))
+		case "Arguments":
+			cmd.Arguments = append(cmd.Arguments, string(e.Content))
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.shells[cmd.ShellID] {
+		return nil, InvalidSelectors(fmt.Sprintf("No shell %s", cmd.ShellID))
+	}
+	s.commands = append(s.commands, cmd)
+	return dom.Elem("CommandResponse", wsman.NS_SHELL).AddChild(
+		dom.ElemC("CommandId", wsman.NS_SHELL, cmd.ID)), nil
+}
+
+func (s *Server) sendInput(req *Request) (*dom.Element, error) {
+	body, err := shellBody(req, "Send")
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, stream := range body.Children() {
+		cmd, err := s.command(req, attrValue(stream, "CommandId"))
+		if err != nil {
+			return nil, err
+		}
+		if cmd.exited {
+			return nil, InternalError(fmt.Sprintf("Command %s has already exited", cmd.ID))
+		}
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(stream.Content)))
+		if err != nil {
+			return nil, InternalError(err.Error())
+		}
+		cmd.stdin = append(cmd.stdin, data...)
+		if attrValue(stream, "End") == "true" {
+			close(cmd.closed)
+		}
+	}
+	return dom.Elem("SendResponse", wsman.NS_SHELL), nil
+}
+
+func outputStream(cmd *ShellCommand, name string, data []byte) *dom.Element {
+	return dom.ElemC("Stream", wsman.NS_SHELL, base64.StdEncoding.EncodeToString(data)).
+		Attr("Name", "", name).
+		Attr("CommandId", "", cmd.ID).
+		Attr("End", "", "true")
+}
+
+// operationTimeout returns how long req is willing to wait, or 0 if
+// it does not say.
+func operationTimeout(req *Request) time.Duration {
+	timeout := headerContent(req.Message, "OperationTimeout", wsman.NS_WSMAN)
+	if !strings.HasPrefix(timeout, "PT") || !strings.HasSuffix(timeout, "S") {
+		return 0
+	}
+	secs, err := strconv.ParseFloat(timeout[2:len(timeout)-1], 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(secs * float64(time.Second))
+}
+
+func (s *Server) receive(h CommandHandler) Handler {
+	return func(req *Request) (*dom.Element, error) {
+		body, err := shellBody(req, "Receive")
+		if err != nil {
+			return nil, err
+		}
+		desired := search.First(search.Tag("DesiredStream", wsman.NS_SHELL), body.Children())
+		id := ""
+		if desired != nil {
+			id = attrValue(desired, "CommandId")
+		}
+		if id == "" {
+			// The shell itself never has anything to say.
+			return nil, TimedOut()
+		}
+		s.mu.Lock()
+		cmd, err := s.command(req, id)
+		if err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		if !cmd.ran {
+			// Run the command in the background, so that handlers
+			// can wait for input while the client keeps asking
+			// for output.
+			cmd.ran = true
+			go func() {
+				stdout, stderr, code := h(cmd)
+				s.mu.Lock()
+				cmd.stdout, cmd.stderr, cmd.exitCode = stdout, stderr, code
+				cmd.exited = true
+				s.mu.Unlock()
+				close(cmd.finished)
+			}()
+		}
+		s.mu.Unlock()
+		if timeout := operationTimeout(req); timeout > 0 {
+			select {
+			case <-cmd.finished:
+			case <-time.After(timeout):
+				return nil, TimedOut()
+			}
+		} else {
+			<-cmd.finished
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		resp := dom.Elem("ReceiveResponse", wsman.NS_SHELL)
+		state := dom.Elem("CommandState", wsman.NS_SHELL).Attr("CommandId", "", cmd.ID)
+		if !cmd.delivered {
+			cmd.delivered = true
+			resp.AddChild(outputStream(cmd, "stdout", cmd.stdout))
+			resp.AddChild(outputStream(cmd, "stderr", cmd.stderr))
+			return resp.AddChild(state.Attr("State", "", COMMAND_RUNNING)), nil
+		}
+		state.Attr("State", "", COMMAND_DONE)
+		state.AddChild(dom.ElemC("ExitCode", wsman.NS_SHELL, fmt.Sprint(cmd.exitCode)))
+		return resp.AddChild(state), nil
+	}
+}
+
+func (s *Server) signal(req *Request) (*dom.Element, error) {
+	body, err := shellBody(req, "Signal")
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cmd, err := s.command(req, attrValue(body, "CommandId"))
+	if err != nil {
+		return nil, err
+	}
+	if code := search.First(search.Tag("Code", wsman.NS_SHELL), body.Children()); code != nil {
+		cmd.signals = append(cmd.signals, strings.TrimSpace(string(code.Content)))
+		if cmd.signals[len(cmd.signals)-1] == wsman.SIGNAL_TERMINATE && !cmd.exited {
+			cmd.exited = true
+			close(cmd.killed)
+		}
+	}
+	return dom.Elem("SignalResponse", wsman.NS_SHELL), nil
+}
+
+// HandleShell serves Windows Remote Shells of the resource type, such
+// as wsman.CMD_SHELL, running the commands started in them with h.
+func (s *Server) HandleShell(resource string, h CommandHandler) {
+	s.Handle(resource, wsman.CREATE, s.createShell)
+	s.Handle(resource, wsman.DELETE, s.deleteShell)
+	s.Handle(resource, wsman.COMMAND, s.startCommand)
+	s.Handle(resource, wsman.SEND, s.sendInput)
+	s.Handle(resource, wsman.RECEIVE, s.receive(h))
+	s.Handle(resource, wsman.SIGNAL, s.signal)
+}